@@ -8,25 +8,57 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"golang.org/x/crypto/ssh"
 
 	"termunator/internal/models"
 	"termunator/internal/services"
 	"termunator/internal/storage"
 )
 
+// Keys of the settings table
+const (
+	settingHashKnownHosts    = "known_hosts.hash"
+	settingStrictKnownHosts  = "known_hosts.strict"
+	settingKnownHostsStorage = "known_hosts.storage"
+	settingAgentSocket       = "agent.socket"
+	settingDefaultProxy      = "proxy.default"
+	settingTransferLimit     = "transfers.concurrency"
+	settingVerifyResume      = "transfers.verify_resume"
+)
+
+// Where trusted host keys are kept
+const (
+	knownHostsStorageFile = "file" // ~/.ssh/known_hosts, shared with OpenSSH
+	knownHostsStorageApp  = "app"  // known_hosts table of the app database
+)
+
 type App struct {
 	ctx         context.Context
+	dataDir     string
 	db          *storage.Database
 	sshService  *services.SSHService
 	sftpService *services.SFTPService
+	transfers   *services.TransferManager
+	sync        *services.SyncService
 	encryption  *storage.EncryptionService
+	vault       *storage.Vault
+
+	stopIdleWatch chan struct{}
 }
 
 func NewApp() *App {
+	sshService := services.NewSSHService()
+	transfers := services.NewTransferManager(sshService)
 	return &App{
-		sshService:  services.NewSSHService(),
+		sshService:  sshService,
 		sftpService: services.NewSFTPService(),
+		transfers:   transfers,
+		sync:        services.NewSyncService(transfers),
 	}
 }
 
@@ -34,35 +66,124 @@ func (a *App) startup(ctx context.Context) {
 	a.ctx = ctx
 
 	a.sshService.SetContext(ctx)
+	a.transfers.SetContext(ctx)
 
 	// Initialize db
 	homeDir, _ := os.UserHomeDir()
 	dataDir := filepath.Join(homeDir, ".termunator")
 	dbPath := filepath.Join(dataDir, "data.db")
-	saltPath := filepath.Join(dataDir, "salt")
+	a.dataDir = dataDir
 
 	// Ensure data directory exists
 	os.MkdirAll(dataDir, 0755)
 
-	// TODO
-	// For now, use a default password - in the future this will be prompted from user
-	masterPassword := "default_key"
-
-	// Load or generate salt
-	var salt []byte
-	if saltData, err := os.ReadFile(saltPath); err == nil {
-		salt = saltData
-	} else {
-		salt = storage.GenerateSalt()
-		os.WriteFile(saltPath, salt, 0600)
-	}
-
-	a.encryption = storage.NewEncryptionService(masterPassword, salt)
-
 	var err error
+	a.encryption = storage.NewLockedEncryptionService()
 	a.db, err = storage.NewDatabase(dbPath, a.encryption)
 	if err != nil {
 		fmt.Printf("Failed to initialize database: %v\n", err)
+		return
+	}
+
+	// The vault stays locked until the frontend sets up or enters the master password
+	a.vault, err = storage.NewVault(dataDir, a.db)
+	if err != nil {
+		fmt.Printf("Failed to open vault: %v\n", err)
+		return
+	}
+
+	a.sshService.SetKeyStore(a.db)
+	a.sshService.SetHostCAStore(a.db)
+	a.sshService.SetProxyStore(a.db)
+	a.transfers.SetStore(a.db)
+	a.transfers.SetConcurrency(a.GetTransferConcurrency())
+	a.transfers.SetVerifyResume(a.GetVerifyTransferResume())
+	a.sync.SetStore(a.db)
+	a.sshService.SetDefaultProxy(a.GetDefaultProxy())
+	a.sshService.KnownHosts().SetHashHosts(a.GetHashKnownHosts())
+	a.sshService.KnownHosts().SetStrict(a.GetStrictHostKeyChecking())
+	if a.GetKnownHostsStorage() == knownHostsStorageApp {
+		a.sshService.KnownHosts().SetStore(a.db)
+	}
+	if a.GetAgentSocketEnabled() {
+		if err := a.sshService.Agent().Serve(a.agentSocketPath()); err != nil {
+			log.Printf("Failed to start the SSH agent socket: %v", err)
+		}
+	}
+
+	a.stopIdleWatch = make(chan struct{})
+	go a.vault.WatchIdle(a.stopIdleWatch, a.onVaultLocked)
+}
+
+// Vault Methods
+
+// GetVaultStatus tells the frontend whether to show the setup or unlock screen
+func (a *App) GetVaultStatus() storage.VaultStatus {
+	return a.vault.Status()
+}
+
+// SetupMasterPassword sets the master password on first run
+func (a *App) SetupMasterPassword(password string) error {
+	if err := a.vault.Setup(password); err != nil {
+		return err
+	}
+	a.migrateInlineKeys()
+	a.restoreTransfers()
+	return nil
+}
+
+// migrateInlineKeys moves keys of older hosts into the key store once secrets are readable
+func (a *App) migrateInlineKeys() {
+	if err := a.db.MigrateInlineKeys(); err != nil {
+		log.Printf("Failed to move inline private keys into the key store: %v", err)
+	}
+}
+
+// Unlock loads the vault key derived from the master password
+func (a *App) Unlock(password string) error {
+	if err := a.vault.Unlock(password); err != nil {
+		return err
+	}
+	a.migrateInlineKeys()
+	a.restoreTransfers()
+	return nil
+}
+
+// restoreTransfers picks up the transfer queue of the last run and starts it once host secrets
+// are readable, transfers held while the vault was locked start again too
+func (a *App) restoreTransfers() {
+	if err := a.transfers.Restore(); err != nil {
+		log.Printf("Failed to restore the transfer queue: %v", err)
+	}
+	a.transfers.SetHeld(false)
+}
+
+// Lock wipes the vault key and every unlocked private key from memory
+func (a *App) Lock() {
+	a.vault.Lock()
+	a.onVaultLocked()
+}
+
+// ChangeMasterPassword re-encrypts every stored secret under a new master password
+func (a *App) ChangeMasterPassword(oldPassword, newPassword string) error {
+	return a.vault.ChangeMasterPassword(oldPassword, newPassword)
+}
+
+// SetAutoLockTimeout sets the idle minutes before the vault locks itself, 0 disables it
+func (a *App) SetAutoLockTimeout(minutes int) error {
+	return a.vault.SetAutoLock(minutes)
+}
+
+// TouchVault is called by the frontend on user activity to postpone the auto-lock
+func (a *App) TouchVault() {
+	a.vault.Touch()
+}
+
+func (a *App) onVaultLocked() {
+	a.transfers.SetHeld(true)
+	a.sshService.LockKeys()
+	if a.ctx != nil {
+		runtime.EventsEmit(a.ctx, "vault:locked")
 	}
 }
 
@@ -84,6 +205,20 @@ func (a *App) UpdateHost(id string, req models.HostCreateRequest) (*models.Host,
 	return a.db.UpdateHost(id, req)
 }
 
+// SSH Config Import Methods
+
+// PreviewSSHConfigImport parses an OpenSSH config, ~/.ssh/config if path is empty, and marks
+// the aliases that match a saved host
+func (a *App) PreviewSSHConfigImport(path string) (*services.SSHConfigPreview, error) {
+	return services.PreviewSSHConfigImport(a.db, path)
+}
+
+// ImportSSHConfigHosts creates hosts for the selected aliases. Identity files are added to the
+// key store and ProxyJump hops are linked to saved hosts, importing them first when needed.
+func (a *App) ImportSSHConfigHosts(path string, aliases []string) (*services.SSHConfigImportResult, error) {
+	return services.ImportSSHConfig(a.db, path, aliases)
+}
+
 // SSH Session Methods
 
 // establishes an SSH connection to a host with specified terminal dimensions
@@ -99,6 +234,10 @@ func (a *App) ConnectSSHWithDimensions(hostID string, cols, rows int) (string, e
 		log.Printf("APP API - Host %s not found", hostID)
 		return "", fmt.Errorf("host not found")
 	}
+	if host.JumpHosts, err = a.db.GetJumpHosts(host); err != nil {
+		log.Printf("APP API - Failed to resolve jump hosts for %s: %v", hostID, err)
+		return "", fmt.Errorf("failed to resolve jump hosts: %w", err)
+	}
 
 	log.Printf("APP API - Connecting to host: %s@%s:%d", host.Username, host.Hostname, host.Port)
 	session, err := a.sshService.ConnectWithDimensions(host, cols, rows)
@@ -110,6 +249,7 @@ func (a *App) ConnectSSHWithDimensions(hostID string, cols, rows int) (string, e
 	log.Printf("APP API - SSH connection successful, session ID: %s", session.ID)
 
 	a.db.UpdateHostLastUsed(hostID)
+	a.startAutoForwards(session.ID, hostID)
 
 	return session.ID, nil
 }
@@ -125,6 +265,16 @@ func (a *App) GetSessionOutput(sessionID string) (string, error) {
 	return output, err
 }
 
+// AckSessionOutput acknowledges pushed output chunks up to seq so the backend keeps sending
+func (a *App) AckSessionOutput(sessionID string, seq uint64) error {
+	return a.sshService.AckOutput(sessionID, seq)
+}
+
+// ReplaySessionOutput returns buffered output chunks newer than seq
+func (a *App) ReplaySessionOutput(sessionID string, seq uint64) ([]services.OutputChunk, error) {
+	return a.sshService.ReplayOutput(sessionID, seq)
+}
+
 func (a *App) SendInput(sessionID, input string) error {
 	return a.sshService.SendInput(sessionID, input)
 }
@@ -133,6 +283,20 @@ func (a *App) ResizeTerminal(sessionID string, width, height int) error {
 	return a.sshService.ResizeTerminal(sessionID, width, height)
 }
 
+// AnswerAuthPrompt answers a keyboard-interactive prompt pushed on the ssh:auth-prompt event
+func (a *App) AnswerAuthPrompt(promptID string, answers []string) error {
+	return a.sshService.AnswerAuthPrompt(promptID, answers)
+}
+
+// AnswerPassphrasePrompt unlocks an encrypted private key, remember stores the passphrase in the vault
+func (a *App) AnswerPassphrasePrompt(promptID, passphrase string, remember bool) error {
+	return a.sshService.AnswerPassphrasePrompt(promptID, passphrase, remember)
+}
+
+func (a *App) CancelAuthPrompt(promptID string) error {
+	return a.sshService.CancelAuthPrompt(promptID)
+}
+
 // CheckSessionHealth checks if an SSH session is responsive
 func (a *App) CheckSessionHealth(sessionID string) error {
 	return a.sshService.CheckSessionHealth(sessionID)
@@ -150,6 +314,67 @@ func (a *App) GetSessionPing(sessionID string) (int64, error) {
 	return a.sshService.GetSessionPing(sessionID)
 }
 
+// GetSharedConnections lists open SSH transports and how many tabs/panels use each
+func (a *App) GetSharedConnections() []services.PooledConnectionInfo {
+	return a.sshService.Connections()
+}
+
+// Port Forwarding Methods
+
+func (a *App) CreatePortForward(req models.PortForwardCreateRequest) (*models.PortForward, error) {
+	return a.db.CreatePortForward(req)
+}
+
+func (a *App) GetPortForwards(hostID string) ([]*models.PortForward, error) {
+	return a.db.GetPortForwards(hostID)
+}
+
+func (a *App) UpdatePortForward(id string, req models.PortForwardCreateRequest) (*models.PortForward, error) {
+	return a.db.UpdatePortForward(id, req)
+}
+
+func (a *App) DeletePortForward(id string) error {
+	return a.db.DeletePortForward(id)
+}
+
+// StartPortForward starts a saved forward on an open session
+func (a *App) StartPortForward(sessionID, forwardID string) (*models.PortForwardStatus, error) {
+	forward, err := a.db.GetPortForward(forwardID)
+	if err != nil {
+		return nil, err
+	}
+	if forward == nil {
+		return nil, fmt.Errorf("port forward not found")
+	}
+	return a.sshService.StartForward(sessionID, *forward)
+}
+
+func (a *App) StopPortForward(sessionID, forwardID string) error {
+	return a.sshService.StopForward(sessionID, forwardID)
+}
+
+// ListActivePortForwards returns running forwards with live counters, for all sessions if sessionID is empty
+func (a *App) ListActivePortForwards(sessionID string) ([]models.PortForwardStatus, error) {
+	return a.sshService.ListForwards(sessionID)
+}
+
+// starts the host's forwards marked as auto start, failures are only logged
+func (a *App) startAutoForwards(sessionID, hostID string) {
+	forwards, err := a.db.GetPortForwards(hostID)
+	if err != nil {
+		log.Printf("APP API - Failed to load port forwards for host %s: %v", hostID, err)
+		return
+	}
+	for _, forward := range forwards {
+		if !forward.AutoStart {
+			continue
+		}
+		if _, err := a.sshService.StartForward(sessionID, *forward); err != nil {
+			log.Printf("APP API - Failed to start port forward %s: %v", forward.ID, err)
+		}
+	}
+}
+
 // SFTP Methods
 
 func (a *App) ConnectSFTP(hostID string) error {
@@ -160,6 +385,9 @@ func (a *App) ConnectSFTP(hostID string) error {
 	if host == nil {
 		return fmt.Errorf("host not found")
 	}
+	if host.JumpHosts, err = a.db.GetJumpHosts(host); err != nil {
+		return fmt.Errorf("failed to resolve jump hosts: %w", err)
+	}
 
 	_, err = a.sftpService.Connect(host, a.sshService)
 	return err
@@ -181,6 +409,15 @@ func (a *App) MakeDirectory(hostID, path string) error {
 	return a.sftpService.CreateDirectory(hostID, path)
 }
 
+func (a *App) DeleteFile(hostID, path string) error {
+	return a.sftpService.DeleteFile(hostID, path)
+}
+
+// DeleteDirectory removes a remote directory with everything in it, symlinks are not followed
+func (a *App) DeleteDirectory(hostID, path string) error {
+	return a.sftpService.DeleteDirectory(hostID, path)
+}
+
 func (a *App) UploadFileFromBytes(hostID, remotePath, base64Data string) error {
 	data, err := base64.StdEncoding.DecodeString(base64Data)
 	if err != nil {
@@ -225,6 +462,162 @@ func (a *App) UploadFile(hostID, localPath, remotePath string) error {
 	return a.sftpService.UploadFile(hostID, localPath, remotePath)
 }
 
+// Transfer Queue Methods
+
+// QueueUpload adds an upload to the transfer queue, progress is reported with sftp:transfer events
+func (a *App) QueueUpload(hostID, localPath, remotePath string) (*models.Transfer, error) {
+	return a.transfers.Queue(models.TransferCreateRequest{
+		HostID:     hostID,
+		Direction:  models.TransferUpload,
+		LocalPath:  localPath,
+		RemotePath: remotePath,
+	})
+}
+
+// QueueDownload adds a download to the transfer queue, progress is reported with sftp:transfer events
+func (a *App) QueueDownload(hostID, remotePath, localPath string) (*models.Transfer, error) {
+	return a.transfers.Queue(models.TransferCreateRequest{
+		HostID:     hostID,
+		Direction:  models.TransferDownload,
+		LocalPath:  localPath,
+		RemotePath: remotePath,
+	})
+}
+
+// QueueDirectoryUpload uploads a local directory tree, symlinks are followed, preserved or
+// skipped according to the symlinks policy
+func (a *App) QueueDirectoryUpload(hostID, localPath, remotePath string, symlinks models.SymlinkPolicy) (*models.Transfer, error) {
+	return a.transfers.Queue(models.TransferCreateRequest{
+		HostID:     hostID,
+		Direction:  models.TransferUpload,
+		LocalPath:  localPath,
+		RemotePath: remotePath,
+		IsDir:      true,
+		Symlinks:   symlinks,
+	})
+}
+
+// QueueDirectoryDownload downloads a remote directory tree, see QueueDirectoryUpload
+func (a *App) QueueDirectoryDownload(hostID, remotePath, localPath string, symlinks models.SymlinkPolicy) (*models.Transfer, error) {
+	return a.transfers.Queue(models.TransferCreateRequest{
+		HostID:     hostID,
+		Direction:  models.TransferDownload,
+		LocalPath:  localPath,
+		RemotePath: remotePath,
+		IsDir:      true,
+		Symlinks:   symlinks,
+	})
+}
+
+// ScanTransferConflicts lists the destinations of a batch that already exist, with size and
+// modification time of both sides, so the conflict policy can be chosen before queueing it
+func (a *App) ScanTransferConflicts(req models.TransferBatchRequest) ([]models.TransferConflict, error) {
+	return a.transfers.ScanConflicts(req)
+}
+
+// QueueTransfers queues a batch of files and directories, existing destinations are handled
+// by the conflict policy of the batch or of the item
+func (a *App) QueueTransfers(req models.TransferBatchRequest) ([]*models.Transfer, error) {
+	return a.transfers.QueueBatch(req)
+}
+
+func (a *App) GetTransfers() []models.Transfer {
+	return a.transfers.List()
+}
+
+func (a *App) PauseTransfer(id string) error {
+	return a.transfers.Pause(id)
+}
+
+func (a *App) ResumeTransfer(id string) error {
+	return a.transfers.Resume(id)
+}
+
+func (a *App) CancelTransfer(id string) error {
+	return a.transfers.Cancel(id)
+}
+
+func (a *App) RetryTransfer(id string) error {
+	return a.transfers.Retry(id)
+}
+
+func (a *App) RemoveTransfer(id string) error {
+	return a.transfers.Remove(id)
+}
+
+// ClearFinishedTransfers removes completed, canceled and skipped transfers from the queue
+func (a *App) ClearFinishedTransfers() (int, error) {
+	return a.transfers.ClearFinished()
+}
+
+// SetTransferConcurrency sets how many transfers run at once per host
+func (a *App) SetTransferConcurrency(concurrency int) error {
+	if concurrency < 1 || concurrency > services.MaxTransferConcurrency {
+		return fmt.Errorf("concurrency must be between 1 and %d", services.MaxTransferConcurrency)
+	}
+	if err := a.db.SetSetting(settingTransferLimit, strconv.Itoa(concurrency)); err != nil {
+		return err
+	}
+	a.transfers.SetConcurrency(concurrency)
+	return nil
+}
+
+func (a *App) GetTransferConcurrency() int {
+	concurrency, err := strconv.Atoi(a.db.GetSetting(settingTransferLimit, ""))
+	if err != nil {
+		return services.DefaultTransferConcurrency
+	}
+	return concurrency
+}
+
+// SetVerifyTransferResume sets whether the end of a partial file is compared with the source
+// before a transfer continues it, otherwise a partial file is trusted by its size
+func (a *App) SetVerifyTransferResume(verify bool) error {
+	if err := a.db.SetSetting(settingVerifyResume, strconv.FormatBool(verify)); err != nil {
+		return err
+	}
+	a.transfers.SetVerifyResume(verify)
+	return nil
+}
+
+func (a *App) GetVerifyTransferResume() bool {
+	return a.db.GetSetting(settingVerifyResume, "true") == "true"
+}
+
+// Sync Profile Methods
+
+func (a *App) CreateSyncProfile(req models.SyncProfileCreateRequest) (*models.SyncProfile, error) {
+	if err := services.ValidateSyncFilters(req.Include, req.Exclude); err != nil {
+		return nil, err
+	}
+	return a.db.CreateSyncProfile(req)
+}
+
+func (a *App) GetSyncProfiles(hostID string) ([]*models.SyncProfile, error) {
+	return a.db.GetSyncProfiles(hostID)
+}
+
+func (a *App) UpdateSyncProfile(id string, req models.SyncProfileCreateRequest) (*models.SyncProfile, error) {
+	if err := services.ValidateSyncFilters(req.Include, req.Exclude); err != nil {
+		return nil, err
+	}
+	return a.db.UpdateSyncProfile(id, req)
+}
+
+func (a *App) DeleteSyncProfile(id string) error {
+	return a.db.DeleteSyncProfile(id)
+}
+
+// PreviewSync compares the directories of a profile and returns what a run would change
+func (a *App) PreviewSync(id string) (*models.SyncPlan, error) {
+	return a.sync.Plan(id)
+}
+
+// RunSync applies the changes of a profile, the copies are added to the transfer queue
+func (a *App) RunSync(id string) (*models.SyncPlan, error) {
+	return a.sync.Run(id)
+}
+
 func (a *App) ReadLocalFileAsBytes(path string) (string, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -261,35 +654,35 @@ func (a *App) GetHistory(hostID string, limit int) ([]*models.HistoryEntry, erro
 
 // Host Key Management Methods
 
+// AcceptHostKey trusts publicKey for hostname ("host:port" as reported in HostKeyInfo).
+// Only unknown hosts can be accepted, a changed key needs ReplaceHostKey.
 func (a *App) AcceptHostKey(hostname, publicKey string) error {
-	homeDir, err := os.UserHomeDir()
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(publicKey)))
 	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+		return fmt.Errorf("failed to parse host key: %w", err)
 	}
 
-	sshDir := filepath.Join(homeDir, ".ssh")
-	knownHostsPath := filepath.Join(sshDir, "known_hosts")
-
-	// Ensure .ssh directory exists
-	if err := os.MkdirAll(sshDir, 0700); err != nil {
-		return fmt.Errorf("failed to create .ssh directory: %w", err)
+	if err := a.sshService.KnownHosts().Add(hostname, key); err != nil {
+		return err
 	}
 
-	// publicKey should already be in the authorized/known_hosts key form (e.g. "ssh-ed25519 AAAA..."),
-	// write a proper known_hosts line: "hostname <keyType> <base64key>"
-	entry := fmt.Sprintf("%s %s\n", hostname, strings.TrimSpace(publicKey))
+	log.Printf("Added host key for %s to known_hosts", hostname)
+	return nil
+}
 
-	file, err := os.OpenFile(knownHostsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+// ReplaceHostKey removes the stale keys reported by a host key mismatch and trusts publicKey
+// instead. It is refused in strict mode.
+func (a *App) ReplaceHostKey(hostname, publicKey string, staleKeys []services.KnownHostKey) error {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(publicKey)))
 	if err != nil {
-		return fmt.Errorf("failed to open known_hosts file: %w", err)
+		return fmt.Errorf("failed to parse host key: %w", err)
 	}
-	defer file.Close()
 
-	if _, err := file.WriteString(entry); err != nil {
-		return fmt.Errorf("failed to write to known_hosts file: %w", err)
+	if err := a.sshService.KnownHosts().Replace(hostname, staleKeys, key); err != nil {
+		return err
 	}
 
-	log.Printf("Added host key for %s to known_hosts", hostname)
+	log.Printf("Replaced changed host key for %s in known_hosts", hostname)
 	return nil
 }
 
@@ -301,30 +694,38 @@ func (a *App) ConnectSSHWithHostKeyVerification(hostID string, cols, rows int) (
 	if host == nil {
 		return nil, fmt.Errorf("host not found")
 	}
+	if host.JumpHosts, err = a.db.GetJumpHosts(host); err != nil {
+		return nil, fmt.Errorf("failed to resolve jump hosts: %w", err)
+	}
 
 	session, err := a.sshService.ConnectWithDimensions(host, cols, rows)
 	if err != nil {
 		log.Printf("Connection error for host %s: %v (type: %T)", host.Hostname, err, err)
 
-		if hostKeyErr, ok := err.(services.HostKeyVerificationNeeded); ok {
-			log.Printf("Host key verification needed for %s", host.Hostname)
-			// Return host key info for frontend to handle
+		// A changed key is reported separately so the frontend can only offer replacing it
+		var mismatchErr services.HostKeyMismatch
+		if errors.As(err, &mismatchErr) {
+			log.Printf("Host key mismatch for %s", host.Hostname)
+			if mismatchErr.Strict {
+				return nil, mismatchErr
+			}
 			return map[string]interface{}{
 				"needsHostKeyVerification": true,
+				"hostKeyMismatch":          true,
 				"hostKeyInfo": map[string]interface{}{
-					"hostname":    hostKeyErr.HostKeyInfo.Hostname,
-					"algorithm":   hostKeyErr.HostKeyInfo.Algorithm,
-					"fingerprint": hostKeyErr.HostKeyInfo.Fingerprint,
-					"publicKey":   hostKeyErr.HostKeyInfo.PublicKey,
-					"isNewHost":   hostKeyErr.HostKeyInfo.IsNewHost,
+					"hostname":    mismatchErr.HostKeyInfo.Hostname,
+					"algorithm":   mismatchErr.HostKeyInfo.Algorithm,
+					"fingerprint": mismatchErr.HostKeyInfo.Fingerprint,
+					"publicKey":   mismatchErr.HostKeyInfo.PublicKey,
+					"isNewHost":   false,
+					"knownKeys":   mismatchErr.Known,
 				},
 			}, nil
 		}
 
-		// Check if it's wrapped in another error
 		var hostKeyErr services.HostKeyVerificationNeeded
 		if errors.As(err, &hostKeyErr) {
-			log.Printf("Host key verification needed (wrapped) for %s", host.Hostname)
+			log.Printf("Host key verification needed for %s", host.Hostname)
 			// Return host key info for frontend to handle
 			return map[string]interface{}{
 				"needsHostKeyVerification": true,
@@ -344,75 +745,224 @@ func (a *App) ConnectSSHWithHostKeyVerification(hostID string, cols, rows int) (
 
 	// Connection successful, update last used and return session ID
 	a.db.UpdateHostLastUsed(hostID)
+	a.startAutoForwards(session.ID, hostID)
 	return map[string]interface{}{
 		"needsHostKeyVerification": false,
 		"sessionId":                session.ID,
 	}, nil
 }
 
-func (a *App) GetKnownHosts() ([]map[string]interface{}, error) {
-	homeDir, err := os.UserHomeDir()
+func (a *App) GetKnownHosts() ([]services.KnownHostEntry, error) {
+	return a.sshService.KnownHosts().List()
+}
+
+// RemoveKnownHost removes every key stored for a host, hostname may include a port
+func (a *App) RemoveKnownHost(hostname string) error {
+	removed, err := a.sshService.KnownHosts().Remove(hostname)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
+		return err
 	}
-
-	knownHostsPath := filepath.Join(homeDir, ".ssh", "known_hosts")
-
-	// Check if file exists
-	if _, err := os.Stat(knownHostsPath); os.IsNotExist(err) {
-		return []map[string]interface{}{}, nil
+	if removed == 0 {
+		return fmt.Errorf("no known host key for %s", hostname)
 	}
+	return nil
+}
 
-	file, err := os.Open(knownHostsPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open known_hosts file: %w", err)
+// RemoveKnownHostEntry removes a single known_hosts line, used for hashed entries
+func (a *App) RemoveKnownHostEntry(line int) error {
+	return a.sshService.KnownHosts().RemoveLine(line)
+}
+
+func (a *App) ClearKnownHosts() error {
+	if err := a.sshService.KnownHosts().Clear(); err != nil {
+		return fmt.Errorf("failed to clear known hosts: %w", err)
 	}
-	defer file.Close()
 
-	var hosts []map[string]interface{}
+	log.Printf("Cleared all known hosts")
+	return nil
+}
 
-	// this is a placeholder
-	hosts = append(hosts, map[string]interface{}{
-		"hostname":    "example.com",
-		"algorithm":   "ECDSA",
-		"fingerprint": "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8",
-		"addedDate":   "2025-09-15",
-	})
+// SetHashKnownHosts sets whether accepted host keys are stored with hashed hostnames
+func (a *App) SetHashKnownHosts(enabled bool) error {
+	if err := a.db.SetSetting(settingHashKnownHosts, strconv.FormatBool(enabled)); err != nil {
+		return err
+	}
+	a.sshService.KnownHosts().SetHashHosts(enabled)
+	return nil
+}
 
-	return hosts, nil
+func (a *App) GetHashKnownHosts() bool {
+	return a.db.GetSetting(settingHashKnownHosts, "false") == "true"
 }
 
-func (a *App) RemoveKnownHost(hostname string) error {
-	// TODO: Implement actual known_hosts file parsing and removal
-	log.Printf("Would remove known host: %s", hostname)
+// SetStrictHostKeyChecking sets whether connections to hosts with a changed key are refused
+// without offering to replace the key
+func (a *App) SetStrictHostKeyChecking(enabled bool) error {
+	if err := a.db.SetSetting(settingStrictKnownHosts, strconv.FormatBool(enabled)); err != nil {
+		return err
+	}
+	a.sshService.KnownHosts().SetStrict(enabled)
 	return nil
 }
 
-func (a *App) ClearKnownHosts() error {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+func (a *App) GetStrictHostKeyChecking() bool {
+	return a.db.GetSetting(settingStrictKnownHosts, "false") == "true"
+}
+
+// SetKnownHostsStorage switches between ~/.ssh/known_hosts ("file") and the app database ("app").
+// Keys are not moved, ImportKnownHosts and ExportKnownHosts copy them between the two.
+func (a *App) SetKnownHostsStorage(storage string) error {
+	switch storage {
+	case knownHostsStorageFile:
+		a.sshService.KnownHosts().SetStore(nil)
+	case knownHostsStorageApp:
+		a.sshService.KnownHosts().SetStore(a.db)
+	default:
+		return fmt.Errorf("unknown known hosts storage: %s", storage)
 	}
+	return a.db.SetSetting(settingKnownHostsStorage, storage)
+}
 
-	knownHostsPath := filepath.Join(homeDir, ".ssh", "known_hosts")
+func (a *App) GetKnownHostsStorage() string {
+	return a.db.GetSetting(settingKnownHostsStorage, knownHostsStorageFile)
+}
 
-	// Create an empty file (effectively clearing it)
-	file, err := os.Create(knownHostsPath)
-	if err != nil {
-		return fmt.Errorf("failed to clear known_hosts file: %w", err)
+// ImportKnownHosts adds the entries of an OpenSSH known_hosts file, ~/.ssh/known_hosts if path
+// is empty, to the known hosts in use and returns how many were new
+func (a *App) ImportKnownHosts(path string) (int, error) {
+	return a.sshService.KnownHosts().Import(path)
+}
+
+// ExportKnownHosts adds the known hosts in use to an OpenSSH known_hosts file, ~/.ssh/known_hosts
+// if path is empty, and returns how many were new
+func (a *App) ExportKnownHosts(path string) (int, error) {
+	return a.sshService.KnownHosts().Export(path)
+}
+
+// Host CA Methods
+
+// CreateHostCA trusts a CA to sign host keys, for every host or only hosts with one of its tags
+func (a *App) CreateHostCA(req models.HostCACreateRequest) (*models.HostCA, error) {
+	return a.db.CreateHostCA(req)
+}
+
+func (a *App) GetHostCAs() ([]*models.HostCA, error) {
+	return a.db.GetHostCAs()
+}
+
+func (a *App) UpdateHostCA(id string, req models.HostCACreateRequest) (*models.HostCA, error) {
+	return a.db.UpdateHostCA(id, req)
+}
+
+func (a *App) DeleteHostCA(id string) error {
+	return a.db.DeleteHostCA(id)
+}
+
+// Proxy Methods
+
+// CreateProxy saves a SOCKS5, HTTP CONNECT or ProxyCommand proxy hosts can connect through
+func (a *App) CreateProxy(req models.ProxyCreateRequest) (*models.Proxy, error) {
+	return a.db.CreateProxy(req)
+}
+
+func (a *App) GetProxies() ([]*models.Proxy, error) {
+	return a.db.GetProxies()
+}
+
+// UpdateProxy changes a saved proxy, an empty password keeps the stored one
+func (a *App) UpdateProxy(id string, req models.ProxyCreateRequest) (*models.Proxy, error) {
+	return a.db.UpdateProxy(id, req)
+}
+
+// DeleteProxy removes a proxy no host uses, and stops using it as the default
+func (a *App) DeleteProxy(id string) error {
+	if err := a.db.DeleteProxy(id); err != nil {
+		return err
 	}
-	defer file.Close()
+	if a.GetDefaultProxy() == id {
+		return a.SetDefaultProxy("")
+	}
+	return nil
+}
 
-	log.Printf("Cleared all known hosts")
+// SetDefaultProxy sets the proxy of hosts without their own, empty connects directly
+func (a *App) SetDefaultProxy(id string) error {
+	if id != "" {
+		proxy, err := a.db.GetProxy(id)
+		if err != nil {
+			return err
+		}
+		if proxy == nil {
+			return fmt.Errorf("proxy %s not found", id)
+		}
+	}
+	if err := a.db.SetSetting(settingDefaultProxy, id); err != nil {
+		return err
+	}
+	a.sshService.SetDefaultProxy(id)
 	return nil
 }
 
+func (a *App) GetDefaultProxy() string {
+	return a.db.GetSetting(settingDefaultProxy, "")
+}
+
 // Private Key Management Methods
 
 func (a *App) CreatePrivateKey(req models.PrivateKeyCreateRequest) (*models.PrivateKey, error) {
 	return a.db.CreatePrivateKey(req)
 }
 
+// GeneratePrivateKey creates a new key pair and adds it to the key store
+func (a *App) GeneratePrivateKey(req models.PrivateKeyGenerateRequest) (*models.PrivateKey, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	keyData, _, err := services.GenerateKey(req.Type, req.Bits, req.Comment, req.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.db.CreatePrivateKey(models.PrivateKeyCreateRequest{
+		Name:               req.Name,
+		KeyData:            keyData,
+		Passphrase:         req.Passphrase,
+		RememberPassphrase: req.RememberPassphrase,
+		Comment:            req.Comment,
+	})
+}
+
+// GetPublicKey returns the public key of a stored key in OpenSSH authorized_keys format
+func (a *App) GetPublicKey(id string) (string, error) {
+	return a.db.GetPublicKey(id)
+}
+
+// InstallPublicKey appends a stored key's public key to ~/.ssh/authorized_keys on the host,
+// using the host's current authentication, then switches the host to that key
+func (a *App) InstallPublicKey(hostID, keyID string) error {
+	host, err := a.db.GetHost(hostID)
+	if err != nil {
+		return fmt.Errorf("failed to get host: %w", err)
+	}
+	if host == nil {
+		return fmt.Errorf("host not found")
+	}
+	if host.JumpHosts, err = a.db.GetJumpHosts(host); err != nil {
+		return fmt.Errorf("failed to resolve jump hosts: %w", err)
+	}
+
+	publicKey, err := a.db.GetPublicKey(keyID)
+	if err != nil {
+		return err
+	}
+
+	if err := a.sshService.InstallPublicKey(host, publicKey); err != nil {
+		return err
+	}
+	return a.db.SetHostPrivateKey(hostID, keyID)
+}
+
 func (a *App) GetPrivateKeys() ([]*models.PrivateKeyInfo, error) {
 	return a.db.GetPrivateKeys()
 }
@@ -425,7 +975,104 @@ func (a *App) DeletePrivateKey(id string) error {
 	return a.db.DeletePrivateKey(id)
 }
 
+// SetPrivateKeyCertificate attaches a renewed -cert.pub to a stored key, an empty certificate detaches it
+func (a *App) SetPrivateKeyCertificate(id, certificate string) (*models.PrivateKeyInfo, error) {
+	return a.db.SetPrivateKeyCertificate(id, certificate)
+}
+
+// GetPrivateKeyHosts lists the labels of the hosts that use a stored key
+func (a *App) GetPrivateKeyHosts(id string) ([]string, error) {
+	return a.db.GetPrivateKeyHosts(id)
+}
+
+// SetKeyUnlockTimeout sets how many minutes unlocked private keys stay in memory, 0 keeps them until locked
+func (a *App) SetKeyUnlockTimeout(minutes int) error {
+	if minutes < 0 {
+		return fmt.Errorf("timeout cannot be negative")
+	}
+	a.sshService.SetKeyCacheTimeout(time.Duration(minutes) * time.Minute)
+	return nil
+}
+
+// LockPrivateKeys forgets every unlocked private key
+func (a *App) LockPrivateKeys() {
+	a.sshService.LockKeys()
+}
+
+// ForgetKeyPassphrases forgets unlocked keys and removes the passphrases stored in the vault
+func (a *App) ForgetKeyPassphrases() error {
+	a.sshService.LockKeys()
+	return a.db.ForgetKeyPassphrases()
+}
+
+// SSH Agent Methods
+
+// GetAgentKeys lists the keys loaded into the built-in agent
+func (a *App) GetAgentKeys() []services.AgentKey {
+	return a.sshService.Agent().Keys()
+}
+
+// AddKeyToAgent loads a stored key into the built-in agent for lifetimeMinutes, 0 keeps it until
+// removed or the keys are locked. With confirm every signature has to be allowed first.
+func (a *App) AddKeyToAgent(keyID string, lifetimeMinutes int, confirm bool) ([]services.AgentKey, error) {
+	if lifetimeMinutes < 0 {
+		return nil, fmt.Errorf("lifetime cannot be negative")
+	}
+	return a.sshService.AddKeyToAgent(keyID, time.Duration(lifetimeMinutes)*time.Minute, confirm)
+}
+
+// LoadUnlockedKeysIntoAgent loads every stored key usable without a passphrase prompt and
+// returns how many were loaded
+func (a *App) LoadUnlockedKeysIntoAgent(lifetimeMinutes int, confirm bool) (int, error) {
+	if lifetimeMinutes < 0 {
+		return 0, fmt.Errorf("lifetime cannot be negative")
+	}
+	return a.sshService.LoadUnlockedKeysIntoAgent(time.Duration(lifetimeMinutes)*time.Minute, confirm)
+}
+
+// RemoveKeyFromAgent unloads a stored key from the built-in agent
+func (a *App) RemoveKeyFromAgent(keyID string) error {
+	return a.sshService.RemoveKeyFromAgent(keyID)
+}
+
+// RemoveAllKeysFromAgent unloads every key, including keys added through the socket
+func (a *App) RemoveAllKeysFromAgent() error {
+	return a.sshService.Agent().RemoveAll()
+}
+
+// SetAgentSocketEnabled serves the built-in agent on a Unix socket for CLI tools, or stops it
+func (a *App) SetAgentSocketEnabled(enabled bool) error {
+	if enabled {
+		if err := a.sshService.Agent().Serve(a.agentSocketPath()); err != nil {
+			return err
+		}
+	} else if err := a.sshService.Agent().StopServing(); err != nil {
+		log.Printf("Failed to close the SSH agent socket: %v", err)
+	}
+	return a.db.SetSetting(settingAgentSocket, strconv.FormatBool(enabled))
+}
+
+func (a *App) GetAgentSocketEnabled() bool {
+	return a.db.GetSetting(settingAgentSocket, "false") == "true"
+}
+
+// GetAgentSocketPath returns the socket to set as SSH_AUTH_SOCK, empty when it is not served
+func (a *App) GetAgentSocketPath() string {
+	return a.sshService.Agent().SocketPath()
+}
+
+func (a *App) agentSocketPath() string {
+	return filepath.Join(a.dataDir, "agent.sock")
+}
+
 func (a *App) Cleanup() {
+	if a.stopIdleWatch != nil {
+		close(a.stopIdleWatch)
+	}
+	a.sshService.Agent().StopServing()
+	if a.vault != nil {
+		a.vault.Lock()
+	}
 	if a.db != nil {
 		a.db.Close()
 	}
@@ -4,7 +4,15 @@ import type {
   Macro, 
   MacroCreateRequest, 
   Session, 
-  SFTPFileInfo
+  SFTPFileInfo,
+  SymlinkPolicy,
+  ConflictPolicy,
+  Transfer,
+  TransferConflict,
+  TransferItem,
+  SyncProfile,
+  SyncProfileCreateRequest,
+  SyncPlan
 } from '../types/api';
 
 // Import Wails runtime
@@ -629,4 +637,169 @@ export class SFTPAPI {
       throw error;
     }
   }
+
+  static async deleteFile(hostId: string, path: string): Promise<void> {
+    const isWails = await initializeEnvironment();
+    if (!isWails) {
+      // Mock implementation for browser
+      console.log('Mock: Deleting remote file', path, 'for host', hostId);
+      return;
+    }
+    await App.DeleteFile(hostId, path);
+  }
+
+  // Deletes the directory with everything in it
+  static async deleteDirectory(hostId: string, path: string): Promise<void> {
+    const isWails = await initializeEnvironment();
+    if (!isWails) {
+      // Mock implementation for browser
+      console.log('Mock: Deleting remote directory', path, 'for host', hostId);
+      return;
+    }
+    await App.DeleteDirectory(hostId, path);
+  }
+
+  // Transfer queue, progress arrives on the "sftp:transfer" event
+
+  static async queueUpload(hostId: string, localPath: string, remotePath: string): Promise<Transfer | null> {
+    const isWails = await initializeEnvironment();
+    if (!isWails) {
+      // Mock implementation for browser
+      console.log('Mock: Queueing upload', localPath, 'to', remotePath);
+      return null;
+    }
+    return await App.QueueUpload(hostId, localPath, remotePath);
+  }
+
+  static async queueDownload(hostId: string, remotePath: string, localPath: string): Promise<Transfer | null> {
+    const isWails = await initializeEnvironment();
+    if (!isWails) {
+      // Mock implementation for browser
+      console.log('Mock: Queueing download', remotePath, 'to', localPath);
+      return null;
+    }
+    return await App.QueueDownload(hostId, remotePath, localPath);
+  }
+
+  static async queueDirectoryUpload(hostId: string, localPath: string, remotePath: string, symlinks: SymlinkPolicy = 'follow'): Promise<Transfer | null> {
+    const isWails = await initializeEnvironment();
+    if (!isWails) {
+      // Mock implementation for browser
+      console.log('Mock: Queueing directory upload', localPath, 'to', remotePath);
+      return null;
+    }
+    return await App.QueueDirectoryUpload(hostId, localPath, remotePath, symlinks);
+  }
+
+  static async queueDirectoryDownload(hostId: string, remotePath: string, localPath: string, symlinks: SymlinkPolicy = 'follow'): Promise<Transfer | null> {
+    const isWails = await initializeEnvironment();
+    if (!isWails) {
+      // Mock implementation for browser
+      console.log('Mock: Queueing directory download', remotePath, 'to', localPath);
+      return null;
+    }
+    return await App.QueueDirectoryDownload(hostId, remotePath, localPath, symlinks);
+  }
+
+  // Lists the destinations of a batch that exist already, with size and time of both sides
+  static async scanTransferConflicts(
+    hostId: string,
+    direction: 'upload' | 'download',
+    items: TransferItem[],
+    symlinks: SymlinkPolicy = 'follow'
+  ): Promise<TransferConflict[]> {
+    const isWails = await initializeEnvironment();
+    if (!isWails) {
+      return [];
+    }
+    const request = models.TransferBatchRequest.createFrom({ host_id: hostId, direction, items, symlinks });
+    return (await App.ScanTransferConflicts(request)) || [];
+  }
+
+  static async queueTransfers(
+    hostId: string,
+    direction: 'upload' | 'download',
+    items: TransferItem[],
+    conflict: ConflictPolicy = 'overwrite',
+    symlinks: SymlinkPolicy = 'follow'
+  ): Promise<Transfer[]> {
+    const isWails = await initializeEnvironment();
+    if (!isWails) {
+      // Mock implementation for browser
+      console.log('Mock: Queueing', items.length, direction + 's', 'with conflict policy', conflict);
+      return [];
+    }
+    const request = models.TransferBatchRequest.createFrom({ host_id: hostId, direction, items, conflict, symlinks });
+    return (await App.QueueTransfers(request)) || [];
+  }
+
+  static async getTransfers(): Promise<Transfer[]> {
+    const isWails = await initializeEnvironment();
+    if (!isWails) {
+      return [];
+    }
+    try {
+      return (await App.GetTransfers()) || [];
+    } catch (error) {
+      console.error('Failed to get transfers:', error);
+      return [];
+    }
+  }
+
+  static async pauseTransfer(id: string): Promise<void> {
+    return await App.PauseTransfer(id);
+  }
+
+  static async resumeTransfer(id: string): Promise<void> {
+    return await App.ResumeTransfer(id);
+  }
+
+  static async cancelTransfer(id: string): Promise<void> {
+    return await App.CancelTransfer(id);
+  }
+
+  static async retryTransfer(id: string): Promise<void> {
+    return await App.RetryTransfer(id);
+  }
+
+  static async removeTransfer(id: string): Promise<void> {
+    return await App.RemoveTransfer(id);
+  }
+
+  static async clearFinishedTransfers(): Promise<number> {
+    return await App.ClearFinishedTransfers();
+  }
+}
+
+// Sync Profile API
+export class SyncAPI {
+  static async create(request: SyncProfileCreateRequest): Promise<SyncProfile> {
+    return await App.CreateSyncProfile(models.SyncProfileCreateRequest.createFrom(request));
+  }
+
+  static async getAll(hostId: string): Promise<SyncProfile[]> {
+    const isWails = await initializeEnvironment();
+    if (!isWails) {
+      return [];
+    }
+    return (await App.GetSyncProfiles(hostId)) || [];
+  }
+
+  static async update(id: string, request: SyncProfileCreateRequest): Promise<SyncProfile> {
+    return await App.UpdateSyncProfile(id, models.SyncProfileCreateRequest.createFrom(request));
+  }
+
+  static async delete(id: string): Promise<void> {
+    return await App.DeleteSyncProfile(id);
+  }
+
+  // Compares both directories without changing anything
+  static async preview(id: string): Promise<SyncPlan> {
+    return await App.PreviewSync(id);
+  }
+
+  // Creates directories and deletes right away, copies are added to the transfer queue
+  static async run(id: string): Promise<SyncPlan> {
+    return await App.RunSync(id);
+  }
 }
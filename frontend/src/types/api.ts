@@ -8,6 +8,41 @@ export type Macro = models.Macro;
 export type MacroCreateRequest = models.MacroCreateRequest;
 export type SFTPFileInfo = models.SFTPFileInfo;
 export type HistoryEntry = models.HistoryEntry;
+export type Transfer = models.Transfer;
+
+// What a directory transfer does with the symlinks it finds
+export type SymlinkPolicy = 'follow' | 'preserve' | 'skip';
+
+// What a transfer does when its destination exists
+export type ConflictPolicy = 'overwrite' | 'skip' | 'keep-both' | 'newer' | 'size';
+export type TransferConflict = models.TransferConflict;
+
+// A file or directory of a batch of transfers
+export interface TransferItem {
+  local_path: string;
+  remote_path: string;
+  is_dir: boolean;
+  conflict?: ConflictPolicy; // overrides the policy of the batch
+}
+
+// Saved pair of a local and a remote directory kept in sync, and the diff of a run
+export type SyncProfile = models.SyncProfile;
+export type SyncPlan = models.SyncPlan;
+export type SyncEntry = models.SyncEntry;
+export type SyncDirection = 'upload' | 'download' | 'two-way';
+export type SyncCompare = 'size-time' | 'checksum';
+
+export interface SyncProfileCreateRequest {
+  host_id: string;
+  name: string;
+  local_path: string;
+  remote_path: string;
+  direction: SyncDirection;
+  compare: SyncCompare;
+  include: string[];
+  exclude: string[];
+  delete_extraneous: boolean;
+}
 
 // Session type based on the Go SSHSession
 export interface Session {
@@ -20,8 +55,63 @@ export interface Session {
   cachedOutput?: string; // Cached terminal output
 }
 
+// Terminal output pushed by the backend on the "ssh:output" event
+export interface OutputChunk {
+  sessionId: string;
+  seq: number;
+  data: string;
+  dropped: number; // bytes lost to buffer overflow before this chunk
+}
+
+// Connection state pushed by the backend on the "ssh:state" event
+export interface SessionStateEvent {
+  sessionId: string;
+  state: 'connected' | 'disconnected' | 'reconnecting' | 'closed';
+  attempt?: number;
+  error?: string;
+}
+
+// Keyboard-interactive questions pushed by the backend on the "ssh:auth-prompt" event
+export interface AuthPrompt {
+  id: string;
+  kind: 'keyboard_interactive' | 'passphrase' | 'confirm';
+  hostId: string;
+  hostLabel: string;
+  user: string;
+  instruction: string;
+  questions: Array<{ prompt: string; echo: boolean }>;
+  allowRemember: boolean;
+}
+
+// Key known_hosts still holds for a host whose key changed
+export interface KnownHostKey {
+  line: number;
+  algorithm: string;
+  fingerprint: string;
+}
+
+// Master password vault state
+export interface VaultStatus {
+  initialized: boolean;
+  locked: boolean;
+  autoLockMinutes: number;
+}
+
+// Host alias parsed from an OpenSSH config for the import preview
+export interface SSHConfigEntry {
+  alias: string;
+  hostname: string;
+  port: number;
+  user: string;
+  identityFiles: string[];
+  proxyJump: string[];
+  source: string;
+  duplicateOf?: string;
+  warnings?: string[];
+}
+
 // Auth method type for convenience
-export type AuthMethod = 'password' | 'private_key' | 'ssh_agent';
+export type AuthMethod = 'password' | 'private_key' | 'ssh_agent' | 'keyboard_interactive';
 
 // SFTP System Types
 export interface FileItem {
@@ -35,30 +125,6 @@ export interface FileItem {
   group?: string;
 }
 
-export interface TransferItem {
-  id: string;
-  sourceFile: FileItem;
-  destinationPath: string;
-  direction: 'upload' | 'download';
-  status: 'pending' | 'in-progress' | 'completed' | 'failed' | 'paused' | 'cancelled';
-  progress: number; // 0-100
-  bytesTransferred: number;
-  totalBytes: number;
-  speed: number; // bytes per second
-  errorMessage?: string;
-  startTime: Date;
-  endTime?: Date;
-}
-
-export interface TransferQueue {
-  items: TransferItem[];
-  activeTransfers: number;
-  maxConcurrency: number;
-  totalItems: number;
-  completedItems: number;
-  failedItems: number;
-}
-
 export interface ConflictResolution {
   action: 'replace' | 'keep-both' | 'cancel';
   applyToAll: boolean;
@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+type ForwardType string
+
+const (
+	ForwardLocal   ForwardType = "local"   // -L bind:port -> target via the server
+	ForwardRemote  ForwardType = "remote"  // -R server bind:port -> local target
+	ForwardDynamic ForwardType = "dynamic" // -D local SOCKS5 proxy through the server
+)
+
+type PortForward struct {
+	ID          string      `json:"id" db:"id"`
+	HostID      string      `json:"host_id" db:"host_id"`
+	Label       string      `json:"label" db:"label"`
+	Type        ForwardType `json:"type" db:"type"`
+	BindAddress string      `json:"bind_address" db:"bind_address"`
+	BindPort    int         `json:"bind_port" db:"bind_port"`
+	TargetHost  string      `json:"target_host" db:"target_host"` // Unused for dynamic forwards
+	TargetPort  int         `json:"target_port" db:"target_port"` // Unused for dynamic forwards
+	AutoStart   bool        `json:"auto_start" db:"auto_start"`
+	CreatedAt   time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at" db:"updated_at"`
+}
+
+type PortForwardCreateRequest struct {
+	HostID      string      `json:"host_id"`
+	Label       string      `json:"label"`
+	Type        ForwardType `json:"type"`
+	BindAddress string      `json:"bind_address"`
+	BindPort    int         `json:"bind_port"`
+	TargetHost  string      `json:"target_host"`
+	TargetPort  int         `json:"target_port"`
+	AutoStart   bool        `json:"auto_start"`
+}
+
+// PortForwardStatus is the live state of a forward running on a session
+type PortForwardStatus struct {
+	Forward     PortForward `json:"forward"`
+	SessionID   string      `json:"session_id"`
+	Active      bool        `json:"active"`
+	Connections int64       `json:"connections"` // currently open
+	BytesIn     int64       `json:"bytes_in"`    // received from the remote side
+	BytesOut    int64       `json:"bytes_out"`   // sent to the remote side
+	StartedAt   time.Time   `json:"started_at"`
+	Error       string      `json:"error,omitempty"`
+}
@@ -8,41 +8,82 @@ const (
 	AuthPassword   AuthMethod = "password"
 	AuthPrivateKey AuthMethod = "private_key"
 	AuthAgent      AuthMethod = "ssh_agent"
+	// Server questions are relayed to the UI, OTP prompts can be answered from TOTPSecret
+	AuthKeyboardInteractive AuthMethod = "keyboard_interactive"
+)
+
+// AgentForwarding selects the local agent a host can use through the connection
+type AgentForwarding string
+
+const (
+	ForwardAgentOff     AgentForwarding = ""
+	ForwardAgentSystem  AgentForwarding = "system"  // the agent at SSH_AUTH_SOCK
+	ForwardAgentBuiltin AgentForwarding = "builtin" // keys loaded into Termunator's own agent
 )
 
 type Host struct {
-	ID         string     `json:"id" db:"id"`
-	Label      string     `json:"label" db:"label"`
-	Hostname   string     `json:"hostname" db:"hostname"`
-	Port       int        `json:"port" db:"port"`
-	Username   string     `json:"username" db:"username"`
-	AuthMethod AuthMethod `json:"auth_method" db:"auth_method"`
-	Password   string     `json:"password,omitempty" db:"password"`       // Encrypted
-	PrivateKey string     `json:"private_key,omitempty" db:"private_key"` // Encrypted
-	Tags       []string   `json:"tags" db:"tags"`
-	LastUsed   *time.Time `json:"last_used" db:"last_used"`
-	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+	ID                  string          `json:"id" db:"id"`
+	Label               string          `json:"label" db:"label"`
+	Hostname            string          `json:"hostname" db:"hostname"`
+	Port                int             `json:"port" db:"port"`
+	Username            string          `json:"username" db:"username"`
+	AuthMethod          AuthMethod      `json:"auth_method" db:"auth_method"`
+	Password            string          `json:"password,omitempty" db:"password"`       // Encrypted
+	PrivateKey          string          `json:"private_key,omitempty" db:"private_key"` // Encrypted, only for hosts not yet moved to the key store
+	PrivateKeyID        string          `json:"private_key_id,omitempty" db:"private_key_id"`
+	TOTPSecret          string          `json:"totp_secret,omitempty" db:"totp_secret"` // Encrypted, base32
+	HasTOTPSecret       bool            `json:"has_totp_secret" db:"-"`                 // the secret itself never leaves the backend
+	Tags                []string        `json:"tags" db:"tags"`
+	JumpHostIDs         []string        `json:"jump_host_ids" db:"jump_host_ids"` // Saved hosts to tunnel through, in dial order (ProxyJump)
+	JumpHosts           []*Host         `json:"-" db:"-"`                         // Resolved chain with credentials, only set when connecting
+	AutoReconnect       bool            `json:"auto_reconnect" db:"auto_reconnect"`
+	ResumeCommand       string          `json:"resume_command" db:"resume_command"` // Sent after a reconnect, e.g. "tmux attach"
+	ForwardAgent        AgentForwarding `json:"forward_agent" db:"forward_agent"`
+	ForwardAgentConfirm bool            `json:"forward_agent_confirm" db:"forward_agent_confirm"` // ask before the host signs with a forwarded key
+	ForwardX11          bool            `json:"forward_x11" db:"forward_x11"`                     // proxy X11 clients to the local $DISPLAY
+	ProxyID             string          `json:"proxy_id,omitempty" db:"proxy_id"`                 // saved proxy, ProxyDirect, or empty for the default proxy
+	LastUsed            *time.Time      `json:"last_used" db:"last_used"`
+	CreatedAt           time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time       `json:"updated_at" db:"updated_at"`
 }
 
 type HostCreateRequest struct {
-	Label      string     `json:"label"`
-	Hostname   string     `json:"hostname"`
-	Port       int        `json:"port"`
-	Username   string     `json:"username"`
-	AuthMethod AuthMethod `json:"auth_method"`
-	Password   string     `json:"password,omitempty"`
-	PrivateKey string     `json:"private_key,omitempty"`
-	Tags       []string   `json:"tags"`
+	Label               string          `json:"label"`
+	Hostname            string          `json:"hostname"`
+	Port                int             `json:"port"`
+	Username            string          `json:"username"`
+	AuthMethod          AuthMethod      `json:"auth_method"`
+	Password            string          `json:"password,omitempty"`
+	PrivateKey          string          `json:"private_key,omitempty"` // imported into the key store, use PrivateKeyID for stored keys
+	PrivateKeyID        string          `json:"private_key_id,omitempty"`
+	TOTPSecret          string          `json:"totp_secret,omitempty"`       // empty keeps the stored secret on update
+	ClearTOTPSecret     bool            `json:"clear_totp_secret,omitempty"` // drops the stored secret on update
+	Tags                []string        `json:"tags"`
+	JumpHostIDs         []string        `json:"jump_host_ids"`
+	AutoReconnect       bool            `json:"auto_reconnect"`
+	ResumeCommand       string          `json:"resume_command"`
+	ForwardAgent        AgentForwarding `json:"forward_agent"`
+	ForwardAgentConfirm bool            `json:"forward_agent_confirm"`
+	ForwardX11          bool            `json:"forward_x11"`
+	ProxyID             string          `json:"proxy_id,omitempty"`
 }
 
 type HostUpdateRequest struct {
-	Label      *string     `json:"label,omitempty"`
-	Hostname   *string     `json:"hostname,omitempty"`
-	Port       *int        `json:"port,omitempty"`
-	Username   *string     `json:"username,omitempty"`
-	AuthMethod *AuthMethod `json:"auth_method,omitempty"`
-	Password   *string     `json:"password,omitempty"`
-	PrivateKey *string     `json:"private_key,omitempty"`
-	Tags       []string    `json:"tags,omitempty"`
+	Label               *string          `json:"label,omitempty"`
+	Hostname            *string          `json:"hostname,omitempty"`
+	Port                *int             `json:"port,omitempty"`
+	Username            *string          `json:"username,omitempty"`
+	AuthMethod          *AuthMethod      `json:"auth_method,omitempty"`
+	Password            *string          `json:"password,omitempty"`
+	PrivateKey          *string          `json:"private_key,omitempty"`
+	PrivateKeyID        *string          `json:"private_key_id,omitempty"`
+	TOTPSecret          *string          `json:"totp_secret,omitempty"`
+	Tags                []string         `json:"tags,omitempty"`
+	JumpHostIDs         []string         `json:"jump_host_ids,omitempty"`
+	AutoReconnect       *bool            `json:"auto_reconnect,omitempty"`
+	ResumeCommand       *string          `json:"resume_command,omitempty"`
+	ForwardAgent        *AgentForwarding `json:"forward_agent,omitempty"`
+	ForwardAgentConfirm *bool            `json:"forward_agent_confirm,omitempty"`
+	ForwardX11          *bool            `json:"forward_x11,omitempty"`
+	ProxyID             *string          `json:"proxy_id,omitempty"`
 }
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// HostCA is a certificate authority trusted to sign server host keys. Hosts presenting a
+// certificate it signed are accepted without a fingerprint prompt.
+type HostCA struct {
+	ID          string    `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	PublicKey   string    `json:"public_key" db:"public_key"` // authorized_keys format
+	Fingerprint string    `json:"fingerprint" db:"fingerprint"`
+	Tags        []string  `json:"tags" db:"tags"` // only trusted for hosts with one of these tags, empty for every host
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type HostCACreateRequest struct {
+	Name      string   `json:"name"`
+	PublicKey string   `json:"public_key"`
+	Tags      []string `json:"tags"`
+}
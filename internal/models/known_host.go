@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// KnownHost is a trusted host key kept in the app database instead of ~/.ssh/known_hosts.
+// Each row is one known_hosts line, so markers, wildcards and hashed hosts survive import and export.
+type KnownHost struct {
+	ID          string    `json:"id" db:"id"`
+	Marker      string    `json:"marker,omitempty" db:"marker"` // @revoked, @cert-authority or empty
+	Hosts       string    `json:"hosts" db:"hosts"`             // comma separated patterns as in known_hosts
+	Algorithm   string    `json:"algorithm" db:"algorithm"`
+	Fingerprint string    `json:"fingerprint" db:"fingerprint"`
+	PublicKey   string    `json:"public_key" db:"public_key"` // authorized_keys format
+	Comment     string    `json:"comment,omitempty" db:"comment"`
+	AcceptedBy  string    `json:"accepted_by" db:"accepted_by"` // local user who accepted it, or the import source
+	FirstSeen   time.Time `json:"first_seen" db:"first_seen"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
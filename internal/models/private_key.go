@@ -6,21 +6,57 @@ type PrivateKey struct {
 	ID          string    `json:"id" db:"id"`
 	Name        string    `json:"name" db:"name"`
 	Fingerprint string    `json:"fingerprint" db:"fingerprint"`
-	KeyType     string    `json:"key_type" db:"key_type"`           // RSA, ECDSA, ED25519, etc.
-	KeyData     string    `json:"key_data,omitempty" db:"key_data"` // Encrypted
+	KeyType     string    `json:"key_type" db:"key_type"`                 // RSA, ECDSA, ED25519, etc.
+	KeyData     string    `json:"key_data,omitempty" db:"key_data"`       // Encrypted
+	Encrypted   bool      `json:"encrypted" db:"encrypted"`               // protected by a passphrase
+	Certificate string    `json:"certificate,omitempty" db:"certificate"` // attached OpenSSH user certificate (-cert.pub)
+	PublicKey   string    `json:"public_key,omitempty" db:"public_key"`   // authorized_keys line with comment
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
 
 type PrivateKeyCreateRequest struct {
-	Name    string `json:"name"`
-	KeyData string `json:"key_data"`
+	Name               string `json:"name"`
+	KeyData            string `json:"key_data"`
+	Passphrase         string `json:"passphrase,omitempty"`          // only needed for encrypted keys
+	RememberPassphrase bool   `json:"remember_passphrase,omitempty"` // store the passphrase in the vault
+	Certificate        string `json:"certificate,omitempty"`         // optional -cert.pub signed for this key
+	Comment            string `json:"comment,omitempty"`             // comment of the public key, e.g. user@laptop
+}
+
+// Key types PrivateKeyGenerateRequest accepts
+const (
+	KeyTypeEd25519 = "ed25519"
+	KeyTypeECDSA   = "ecdsa"
+	KeyTypeRSA     = "rsa"
+)
+
+type PrivateKeyGenerateRequest struct {
+	Name               string `json:"name"`
+	Type               string `json:"type"` // ed25519, ecdsa or rsa
+	Bits               int    `json:"bits"` // 256/384/521 for ECDSA, 2048 or more for RSA, 0 for the default
+	Comment            string `json:"comment"`
+	Passphrase         string `json:"passphrase,omitempty"`
+	RememberPassphrase bool   `json:"remember_passphrase,omitempty"`
 }
 
 type PrivateKeyInfo struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Fingerprint string    `json:"fingerprint"`
-	KeyType     string    `json:"key_type"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          string           `json:"id"`
+	Name        string           `json:"name"`
+	Fingerprint string           `json:"fingerprint"`
+	KeyType     string           `json:"key_type"`
+	Encrypted   bool             `json:"encrypted"`
+	Certificate *CertificateInfo `json:"certificate,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+}
+
+// CertificateInfo describes the user certificate attached to a stored key
+type CertificateInfo struct {
+	KeyID         string     `json:"key_id"`
+	Serial        uint64     `json:"serial"`
+	Principals    []string   `json:"principals"` // empty means valid for any principal
+	ValidAfter    time.Time  `json:"valid_after"`
+	ValidBefore   *time.Time `json:"valid_before,omitempty"` // nil when the certificate never expires
+	Expired       bool       `json:"expired"`
+	CAFingerprint string     `json:"ca_fingerprint"`
 }
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+type ProxyType string
+
+const (
+	ProxySOCKS5  ProxyType = "socks5"
+	ProxyHTTP    ProxyType = "http"    // HTTP CONNECT
+	ProxyCommand ProxyType = "command" // subprocess speaking SSH on stdin and stdout, like OpenSSH ProxyCommand
+)
+
+// ProxyDirect as a host's ProxyID connects directly even when a default proxy is set
+const ProxyDirect = "direct"
+
+// Proxy is a saved way to reach SSH servers. Hosts pick one by ID or use the default proxy.
+type Proxy struct {
+	ID        string    `json:"id" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	Type      ProxyType `json:"type" db:"type"`
+	Host      string    `json:"host,omitempty" db:"host"`
+	Port      int       `json:"port,omitempty" db:"port"`
+	Username  string    `json:"username,omitempty" db:"username"`
+	Password  string    `json:"password,omitempty" db:"password"` // Encrypted
+	Command   string    `json:"command,omitempty" db:"command"`   // %h, %p and %r are replaced with the target
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type ProxyCreateRequest struct {
+	Name     string    `json:"name"`
+	Type     ProxyType `json:"type"`
+	Host     string    `json:"host,omitempty"`
+	Port     int       `json:"port,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Password string    `json:"password,omitempty"` // empty keeps the stored password on update
+	Command  string    `json:"command,omitempty"`
+}
@@ -0,0 +1,101 @@
+package models
+
+import "time"
+
+type SyncDirection string
+
+const (
+	SyncUpload   SyncDirection = "upload"   // make the remote tree match the local one
+	SyncDownload SyncDirection = "download" // make the local tree match the remote one
+	SyncTwoWay   SyncDirection = "two-way"  // copy changes both ways, the newer file wins when both changed
+)
+
+type SyncCompare string
+
+const (
+	SyncCompareSizeTime SyncCompare = "size-time" // the default
+	SyncCompareChecksum SyncCompare = "checksum"  // SHA-256 of files of equal size, remote files are read over SFTP
+)
+
+// SyncProfile is a saved pair of a local and a remote directory of a host that are kept in sync
+type SyncProfile struct {
+	ID         string        `json:"id" db:"id"`
+	HostID     string        `json:"host_id" db:"host_id"`
+	Name       string        `json:"name" db:"name"`
+	LocalPath  string        `json:"local_path" db:"local_path"`
+	RemotePath string        `json:"remote_path" db:"remote_path"`
+	Direction  SyncDirection `json:"direction" db:"direction"`
+	Compare    SyncCompare   `json:"compare" db:"compare"`
+	// Globs matched against the slash separated path below the roots and against each name in
+	// it, "**" also matches slashes. Without includes every file is included.
+	Include          []string   `json:"include" db:"include"`
+	Exclude          []string   `json:"exclude" db:"exclude"`
+	DeleteExtraneous bool       `json:"delete_extraneous" db:"delete_extraneous"` // delete what the other side does not have
+	LastRunAt        *time.Time `json:"last_run_at,omitempty" db:"last_run_at"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type SyncProfileCreateRequest struct {
+	HostID           string        `json:"host_id"`
+	Name             string        `json:"name"`
+	LocalPath        string        `json:"local_path"`
+	RemotePath       string        `json:"remote_path"`
+	Direction        SyncDirection `json:"direction"`
+	Compare          SyncCompare   `json:"compare"`
+	Include          []string      `json:"include"`
+	Exclude          []string      `json:"exclude"`
+	DeleteExtraneous bool          `json:"delete_extraneous"`
+}
+
+// SyncFileState is a file that was the same on both sides when a profile last ran. Two-way
+// sync tells deletions from new files and which side changed a file by it.
+type SyncFileState struct {
+	Path          string    `json:"path"`
+	Size          int64     `json:"size"`
+	LocalModTime  time.Time `json:"local_mod_time"`
+	RemoteModTime time.Time `json:"remote_mod_time"`
+}
+
+type SyncChange string
+
+const (
+	SyncNew     SyncChange = "new"
+	SyncChanged SyncChange = "changed"
+	SyncDeleted SyncChange = "deleted"
+)
+
+type SyncAction string
+
+const (
+	SyncActionUpload       SyncAction = "upload"
+	SyncActionDownload     SyncAction = "download"
+	SyncActionDeleteLocal  SyncAction = "delete-local"
+	SyncActionDeleteRemote SyncAction = "delete-remote"
+	SyncActionNone         SyncAction = "none" // left alone, like a file on one side and a directory on the other
+)
+
+// SyncEntry is a difference between the trees and what the sync does about it
+type SyncEntry struct {
+	Path          string     `json:"path"` // slash separated path below the roots
+	IsDir         bool       `json:"is_dir"`
+	Change        SyncChange `json:"change"`
+	Action        SyncAction `json:"action"`
+	Conflict      bool       `json:"conflict,omitempty"` // changed on both sides since the last run, or never in sync
+	LocalSize     int64      `json:"local_size"`
+	LocalModTime  time.Time  `json:"local_mod_time"`
+	RemoteSize    int64      `json:"remote_size"`
+	RemoteModTime time.Time  `json:"remote_mod_time"`
+}
+
+// SyncPlan is the diff of a profile, a dry run returns it without changing anything
+type SyncPlan struct {
+	ProfileID string      `json:"profile_id"`
+	Entries   []SyncEntry `json:"entries"`
+	Uploads   int         `json:"uploads"`
+	Downloads int         `json:"downloads"`
+	Deletes   int         `json:"deletes"`
+	Bytes     int64       `json:"bytes"` // to copy
+	InSync    int         `json:"in_sync"`
+	DryRun    bool        `json:"dry_run"`
+}
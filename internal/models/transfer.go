@@ -0,0 +1,121 @@
+package models
+
+import "time"
+
+type TransferDirection string
+
+const (
+	TransferUpload   TransferDirection = "upload"
+	TransferDownload TransferDirection = "download"
+)
+
+type TransferStatus string
+
+const (
+	TransferQueued    TransferStatus = "queued"
+	TransferRunning   TransferStatus = "running"
+	TransferPaused    TransferStatus = "paused"
+	TransferCompleted TransferStatus = "completed"
+	TransferFailed    TransferStatus = "failed"
+	TransferCanceled  TransferStatus = "canceled"
+	TransferSkipped   TransferStatus = "skipped" // the destination existed and the conflict policy kept it
+)
+
+// SymlinkPolicy says what a directory transfer does with the symlinks it finds
+type SymlinkPolicy string
+
+const (
+	SymlinksFollow   SymlinkPolicy = "follow"   // copy what the link points to, the default
+	SymlinksPreserve SymlinkPolicy = "preserve" // recreate the link with the same target
+	SymlinksSkip     SymlinkPolicy = "skip"
+)
+
+// ConflictPolicy says what a transfer does when its destination already exists. A directory
+// transfer into an existing directory merges the trees and applies the policy to each file,
+// only keep both renames the directory itself.
+type ConflictPolicy string
+
+const (
+	ConflictOverwrite   ConflictPolicy = "overwrite" // the default
+	ConflictSkip        ConflictPolicy = "skip"
+	ConflictKeepBoth    ConflictPolicy = "keep-both" // copy to "name (n).ext" instead
+	ConflictNewer       ConflictPolicy = "newer"     // overwrite if the source was modified later
+	ConflictSizeDiffers ConflictPolicy = "size"      // overwrite if the sizes differ
+)
+
+// Transfer is a queued SFTP upload or download. Queued, running and paused transfers are
+// kept in the database so they can be picked up again after a restart.
+//
+// A directory transfer walks its source tree when it starts, creates the directories and
+// queues a child transfer per file. Its status and progress are the sum of its children.
+type Transfer struct {
+	ID          string            `json:"id" db:"id"`
+	HostID      string            `json:"host_id" db:"host_id"`
+	ParentID    string            `json:"parent_id,omitempty" db:"parent_id"` // directory transfer of a file
+	Direction   TransferDirection `json:"direction" db:"direction"`
+	LocalPath   string            `json:"local_path" db:"local_path"`
+	RemotePath  string            `json:"remote_path" db:"remote_path"`
+	IsDir       bool              `json:"is_dir" db:"is_dir"`
+	Symlinks    SymlinkPolicy     `json:"symlinks,omitempty" db:"symlinks"`
+	Conflict    ConflictPolicy    `json:"conflict,omitempty" db:"conflict"`
+	Status      TransferStatus    `json:"status" db:"status"`
+	Size        int64             `json:"size" db:"size"`               // 0 until the source is opened
+	Transferred int64             `json:"transferred" db:"transferred"` // bytes written to the destination
+	Attempts    int               `json:"attempts" db:"attempts"`
+	Error       string            `json:"error,omitempty" db:"error"`
+	CreatedAt   time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at" db:"updated_at"`
+
+	// Live figures of a running transfer, not stored
+	BytesPerSecond int64 `json:"bytes_per_second"`
+	ETASeconds     int64 `json:"eta_seconds"` // -1 while unknown
+
+	// Files of a directory transfer, not stored
+	Files        int `json:"files"`
+	FilesDone    int `json:"files_done"`
+	FilesFailed  int `json:"files_failed"`
+	FilesSkipped int `json:"files_skipped"`
+}
+
+type TransferCreateRequest struct {
+	HostID     string            `json:"host_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Direction  TransferDirection `json:"direction"`
+	LocalPath  string            `json:"local_path"`
+	RemotePath string            `json:"remote_path"`
+	IsDir      bool              `json:"is_dir"`
+	Symlinks   SymlinkPolicy     `json:"symlinks,omitempty"`
+	Conflict   ConflictPolicy    `json:"conflict,omitempty"`
+	Size       int64             `json:"size,omitempty"` // expected size, known for files of a directory transfer
+}
+
+// TransferItem is a file or directory of a batch of transfers
+type TransferItem struct {
+	LocalPath  string         `json:"local_path"`
+	RemotePath string         `json:"remote_path"`
+	IsDir      bool           `json:"is_dir"`
+	Conflict   ConflictPolicy `json:"conflict,omitempty"` // overrides the policy of the batch
+}
+
+// TransferBatchRequest queues several transfers in one direction with one conflict policy
+type TransferBatchRequest struct {
+	HostID    string            `json:"host_id"`
+	Direction TransferDirection `json:"direction"`
+	Items     []TransferItem    `json:"items"`
+	Conflict  ConflictPolicy    `json:"conflict,omitempty"`
+	Symlinks  SymlinkPolicy     `json:"symlinks,omitempty"`
+}
+
+// TransferConflict is a destination of a batch that already exists
+type TransferConflict struct {
+	Item               int       `json:"item"` // index into the items of the batch
+	Path               string    `json:"path"` // slash separated path inside a directory item, empty for the item itself
+	Source             string    `json:"source"`
+	Destination        string    `json:"destination"`
+	IsDir              bool      `json:"is_dir"`
+	SourceSize         int64     `json:"source_size"`
+	SourceModTime      time.Time `json:"source_mod_time"`
+	DestinationIsDir   bool      `json:"destination_is_dir"`
+	DestinationSize    int64     `json:"destination_size"`
+	DestinationModTime time.Time `json:"destination_mod_time"`
+}
@@ -0,0 +1,485 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AgentKey is a key loaded into the built-in agent
+type AgentKey struct {
+	KeyID       string     `json:"keyId,omitempty"` // empty for keys added through the socket, e.g. by ssh-add
+	Name        string     `json:"name"`
+	Algorithm   string     `json:"algorithm"`
+	Fingerprint string     `json:"fingerprint"`
+	Certificate bool       `json:"certificate"`
+	Confirm     bool       `json:"confirm"` // ask before every signature
+	AddedAt     time.Time  `json:"addedAt"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+}
+
+// KeyAgent is the built-in SSH agent. Keys live in an agent.Keyring, which also handles
+// lifetimes and locking, and keys added with confirmation ask the user before signing.
+// It can be served on a Unix socket so OpenSSH and other CLI tools can use vault keys.
+type KeyAgent struct {
+	keyring agent.ExtendedAgent
+	service *SSHService // answers confirmation prompts
+
+	mutex  sync.Mutex
+	loaded map[string]*AgentKey // by marshaled public key
+
+	listenerMutex sync.Mutex
+	listener      net.Listener
+	socketPath    string
+}
+
+func newKeyAgent(service *SSHService) *KeyAgent {
+	return &KeyAgent{
+		keyring: agent.NewKeyring().(agent.ExtendedAgent),
+		service: service,
+		loaded:  make(map[string]*AgentKey),
+	}
+}
+
+// Keys lists the loaded keys in the order they were added
+func (a *KeyAgent) Keys() []AgentKey {
+	identities, err := a.keyring.List()
+	if err != nil {
+		// A locked keyring lists nothing
+		return []AgentKey{}
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	keys := make([]AgentKey, 0, len(identities))
+	present := make(map[string]bool, len(identities))
+	for _, identity := range identities {
+		blob := string(identity.Marshal())
+		present[blob] = true
+		if info, exists := a.loaded[blob]; exists {
+			keys = append(keys, *info)
+		}
+	}
+	// Forget keys the keyring expired
+	for blob := range a.loaded {
+		if !present[blob] {
+			delete(a.loaded, blob)
+		}
+	}
+	return keys
+}
+
+// List returns the identities known to the agent
+func (a *KeyAgent) List() ([]*agent.Key, error) {
+	return a.keyring.List()
+}
+
+// Sign has the agent sign the data using a protocol 2 key
+func (a *KeyAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags signs like Sign and asks first for keys added with confirmation
+func (a *KeyAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	a.mutex.Lock()
+	info, exists := a.loaded[string(key.Marshal())]
+	var loaded AgentKey
+	if exists {
+		loaded = *info
+	}
+	a.mutex.Unlock()
+
+	if exists && loaded.Confirm {
+		if err := a.confirm(loaded); err != nil {
+			log.Printf("KEYS - Agent refused to sign with %s: %v", loaded.Fingerprint, err)
+			return nil, err
+		}
+	}
+	return a.keyring.SignWithFlags(key, data, flags)
+}
+
+// confirm asks the user whether the key may be used for one signature
+func (a *KeyAgent) confirm(key AgentKey) error {
+	_, err := a.service.prompts.ask(a.service, AuthPrompt{
+		Kind:        PromptConfirm,
+		HostLabel:   "SSH agent",
+		Instruction: fmt.Sprintf("Allow use of key %q (%s %s)?", key.Name, key.Algorithm, key.Fingerprint),
+	})
+	return err
+}
+
+// Add adds a private key to the agent, honouring its lifetime and confirmation constraints
+func (a *KeyAgent) Add(key agent.AddedKey) error {
+	_, err := a.add(key, "")
+	return err
+}
+
+func (a *KeyAgent) add(key agent.AddedKey, keyID string) (*AgentKey, error) {
+	signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	var publicKey ssh.PublicKey = signer.PublicKey()
+	if key.Certificate != nil {
+		publicKey = key.Certificate
+	}
+
+	if err := a.keyring.Add(key); err != nil {
+		return nil, err
+	}
+
+	info := &AgentKey{
+		KeyID:       keyID,
+		Name:        key.Comment,
+		Algorithm:   publicKey.Type(),
+		Fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+		Certificate: key.Certificate != nil,
+		Confirm:     key.ConfirmBeforeUse,
+		AddedAt:     time.Now(),
+	}
+	if key.LifetimeSecs > 0 {
+		expiresAt := info.AddedAt.Add(time.Duration(key.LifetimeSecs) * time.Second)
+		info.ExpiresAt = &expiresAt
+	}
+
+	a.mutex.Lock()
+	a.loaded[string(publicKey.Marshal())] = info
+	a.mutex.Unlock()
+
+	log.Printf("KEYS - Agent loaded %s %s (%s)", info.Algorithm, info.Fingerprint, info.Name)
+	return info, nil
+}
+
+// Remove removes all identities with the given public key
+func (a *KeyAgent) Remove(key ssh.PublicKey) error {
+	if err := a.keyring.Remove(key); err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	delete(a.loaded, string(key.Marshal()))
+	a.mutex.Unlock()
+	return nil
+}
+
+// RemoveAll removes all identities
+func (a *KeyAgent) RemoveAll() error {
+	if err := a.keyring.RemoveAll(); err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	a.loaded = make(map[string]*AgentKey)
+	a.mutex.Unlock()
+	return nil
+}
+
+// Lock locks the agent until Unlock is called with the same passphrase
+func (a *KeyAgent) Lock(passphrase []byte) error {
+	return a.keyring.Lock(passphrase)
+}
+
+// Unlock undoes the effect of Lock
+func (a *KeyAgent) Unlock(passphrase []byte) error {
+	return a.keyring.Unlock(passphrase)
+}
+
+// Signers returns signers for all the loaded keys. They sign through the agent so
+// confirmation also applies to our own connections.
+func (a *KeyAgent) Signers() ([]ssh.Signer, error) {
+	identities, err := a.keyring.List()
+	if err != nil {
+		return nil, err
+	}
+
+	signers := make([]ssh.Signer, 0, len(identities))
+	for _, identity := range identities {
+		signers = append(signers, &agentSigner{agent: a, publicKey: identity})
+	}
+	return signers, nil
+}
+
+// Extension processes a custom extension request, none are supported
+func (a *KeyAgent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
+
+// agentSigner signs with a key of the built-in agent
+type agentSigner struct {
+	agent     *KeyAgent
+	publicKey ssh.PublicKey
+}
+
+func (s *agentSigner) PublicKey() ssh.PublicKey {
+	return s.publicKey
+}
+
+func (s *agentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.agent.Sign(s.publicKey, data)
+}
+
+func (s *agentSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case ssh.KeyAlgoRSASHA256, ssh.CertAlgoRSASHA256v01:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.KeyAlgoRSASHA512, ssh.CertAlgoRSASHA512v01:
+		flags = agent.SignatureFlagRsaSha512
+	}
+	return s.agent.SignWithFlags(s.publicKey, data, flags)
+}
+
+// Serve exposes the agent on a Unix socket, a stale socket left at the path is replaced
+func (a *KeyAgent) Serve(path string) error {
+	a.listenerMutex.Lock()
+	defer a.listenerMutex.Unlock()
+
+	if a.listener != nil {
+		if a.socketPath == path {
+			return nil
+		}
+		a.listener.Close()
+		a.listener = nil
+		a.socketPath = ""
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create agent socket directory: %w", err)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("agent socket path %s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove stale agent socket: %w", err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on agent socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict agent socket: %w", err)
+	}
+
+	a.listener = listener
+	a.socketPath = path
+	log.Printf("KEYS - Agent listening on %s", path)
+
+	go a.accept(listener)
+	return nil
+}
+
+func (a *KeyAgent) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("KEYS - Agent socket stopped accepting: %v", err)
+			}
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			if err := agent.ServeAgent(a, conn); err != nil && !errors.Is(err, io.EOF) {
+				log.Printf("KEYS - Agent client failed: %v", err)
+			}
+		}()
+	}
+}
+
+// StopServing closes the agent socket, the keys stay loaded
+func (a *KeyAgent) StopServing() error {
+	a.listenerMutex.Lock()
+	defer a.listenerMutex.Unlock()
+
+	if a.listener == nil {
+		return nil
+	}
+	err := a.listener.Close()
+	log.Printf("KEYS - Agent stopped listening on %s", a.socketPath)
+	a.listener = nil
+	a.socketPath = ""
+	return err
+}
+
+// SocketPath returns the socket the agent is served on, empty when it is not
+func (a *KeyAgent) SocketPath() string {
+	a.listenerMutex.Lock()
+	defer a.listenerMutex.Unlock()
+	return a.socketPath
+}
+
+// Agent returns the built-in SSH agent
+func (s *SSHService) Agent() *KeyAgent {
+	return s.agent
+}
+
+// AddKeyToAgent unlocks a stored key, asking for its passphrase when needed, and loads it into
+// the built-in agent with its certificate. A lifetime of zero keeps it until removed or locked.
+func (s *SSHService) AddKeyToAgent(keyID string, lifetime time.Duration, confirm bool) ([]AgentKey, error) {
+	if s.keyStore == nil {
+		return nil, errors.New("key store is not available")
+	}
+	key, err := s.keyStore.GetPrivateKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("private key %s not found", keyID)
+	}
+
+	rawKey, err := ssh.ParseRawPrivateKey([]byte(key.KeyData))
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if !errors.As(err, &missing) {
+			return nil, fmt.Errorf("failed to parse private key %q: %w", key.Name, err)
+		}
+		rawKey, err = s.unlockPrivateKey(AuthPrompt{HostLabel: "SSH agent"}, key.KeyData, key.Name, missing)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	added := agent.AddedKey{
+		PrivateKey:       rawKey,
+		Comment:          key.Name,
+		LifetimeSecs:     uint32(lifetime / time.Second),
+		ConfirmBeforeUse: confirm,
+	}
+	info, err := s.agent.add(added, key.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add private key %q to the agent: %w", key.Name, err)
+	}
+	loaded := []AgentKey{*info}
+
+	// Like ssh-add, a key with a certificate is loaded twice, certificate and plain key
+	if key.Certificate != "" {
+		parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.Certificate))
+		if err != nil {
+			return loaded, fmt.Errorf("failed to parse certificate of private key %q: %w", key.Name, err)
+		}
+		cert, ok := parsed.(*ssh.Certificate)
+		if !ok {
+			return loaded, fmt.Errorf("certificate of private key %q is not an OpenSSH certificate", key.Name)
+		}
+		added.Certificate = cert
+		info, err := s.agent.add(added, key.ID)
+		if err != nil {
+			return loaded, fmt.Errorf("failed to add certificate of private key %q to the agent: %w", key.Name, err)
+		}
+		loaded = append(loaded, *info)
+	}
+	return loaded, nil
+}
+
+// LoadUnlockedKeysIntoAgent loads every stored key that needs no passphrase prompt, plain keys
+// and keys with a remembered passphrase, and returns how many were loaded
+func (s *SSHService) LoadUnlockedKeysIntoAgent(lifetime time.Duration, confirm bool) (int, error) {
+	if s.keyStore == nil {
+		return 0, errors.New("key store is not available")
+	}
+	keys, err := s.keyStore.GetPrivateKeys()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, info := range keys {
+		if info.Encrypted && !s.hasRememberedPassphrase(info.ID) {
+			continue
+		}
+		if _, err := s.AddKeyToAgent(info.ID, lifetime, confirm); err != nil {
+			log.Printf("KEYS - Failed to load %q into the agent: %v", info.Name, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (s *SSHService) hasRememberedPassphrase(keyID string) bool {
+	key, err := s.keyStore.GetPrivateKey(keyID)
+	if err != nil || key == nil {
+		return false
+	}
+	passphrase, err := s.keyStore.GetKeyPassphrase(key.KeyData)
+	return err == nil && passphrase != ""
+}
+
+// RemoveKeyFromAgent unloads a stored key, and its certificate, from the built-in agent
+func (s *SSHService) RemoveKeyFromAgent(keyID string) error {
+	s.agent.mutex.Lock()
+	var blobs []string
+	for blob, info := range s.agent.loaded {
+		if info.KeyID == keyID {
+			blobs = append(blobs, blob)
+		}
+	}
+	s.agent.mutex.Unlock()
+
+	if len(blobs) == 0 {
+		return fmt.Errorf("private key %s is not loaded in the agent", keyID)
+	}
+	for _, blob := range blobs {
+		publicKey, err := ssh.ParsePublicKey([]byte(blob))
+		if err != nil {
+			return err
+		}
+		if err := s.agent.Remove(publicKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// agentSigners returns the signers of the built-in agent followed by those of the system
+// agent at SSH_AUTH_SOCK, when it is running and is not our own socket. The system agent
+// signs over its connection, closeAgent closes it once the signers are no longer needed.
+func (s *SSHService) agentSigners() (signers []ssh.Signer, closeAgent func(), err error) {
+	closeAgent = func() {}
+	signers, err = s.agent.Signers()
+	if err != nil {
+		log.Printf("SSH SERVICE - Built-in agent unavailable: %v", err)
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" || socket == s.agent.SocketPath() {
+		if len(signers) == 0 {
+			return nil, nil, errors.New("no keys loaded in the built-in agent and SSH_AUTH_SOCK environment variable not set")
+		}
+		return signers, closeAgent, nil
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		if len(signers) > 0 {
+			log.Printf("SSH SERVICE - System agent unavailable, using the built-in agent: %v", err)
+			return signers, closeAgent, nil
+		}
+		return nil, nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+	}
+
+	systemSigners, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		if len(signers) > 0 {
+			return signers, closeAgent, nil
+		}
+		return nil, nil, fmt.Errorf("failed to list SSH agent keys: %w", err)
+	}
+	return append(signers, systemSigners...), func() { conn.Close() }, nil
+}
+
+var _ agent.ExtendedAgent = (*KeyAgent)(nil)
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"termunator/internal/models"
+)
+
+// confirmingAgent asks the user before a forwarded agent signs for the remote host
+type confirmingAgent struct {
+	agent.ExtendedAgent
+	service *SSHService
+	host    *models.Host
+}
+
+func (a *confirmingAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a *confirmingAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	_, err := a.service.prompts.ask(a.service, AuthPrompt{
+		Kind:      PromptConfirm,
+		HostID:    a.host.ID,
+		HostLabel: a.host.Label,
+		User:      a.host.Username,
+		Instruction: fmt.Sprintf("%s wants to sign with your key %s %s through the forwarded agent.\n"+
+			"Allow this signature?", a.host.Label, key.Type(), ssh.FingerprintSHA256(key)),
+	})
+	if err != nil {
+		log.Printf("SSH SERVICE - Refused forwarded agent signature for %s: %v", a.host.Label, err)
+		return nil, err
+	}
+	return a.ExtendedAgent.SignWithFlags(key, data, flags)
+}
+
+// forwardedAgent returns the local agent a host forwards, the system agent connection
+// is closed together with the client
+func (s *SSHService) forwardedAgent(client *ssh.Client, host *models.Host) (agent.ExtendedAgent, error) {
+	var forwarded agent.ExtendedAgent
+
+	switch host.ForwardAgent {
+	case models.ForwardAgentBuiltin:
+		forwarded = s.agent
+
+	case models.ForwardAgentSystem:
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, errors.New("SSH_AUTH_SOCK environment variable not set")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+		}
+		go func() {
+			client.Wait()
+			conn.Close()
+		}()
+		forwarded = agent.NewClient(conn)
+
+	default:
+		return nil, fmt.Errorf("unknown agent forwarding: %s", host.ForwardAgent)
+	}
+
+	if host.ForwardAgentConfirm {
+		forwarded = &confirmingAgent{ExtendedAgent: forwarded, service: s, host: host}
+	}
+	return forwarded, nil
+}
+
+// serveForwardedAgent answers the agent channels the host opens on a freshly dialed client.
+// It is registered once per transport, sessions only request forwarding.
+func (s *SSHService) serveForwardedAgent(client *ssh.Client, host *models.Host) error {
+	forwarded, err := s.forwardedAgent(client, host)
+	if err != nil {
+		return err
+	}
+	if err := agent.ForwardToAgent(client, forwarded); err != nil {
+		return err
+	}
+	log.Printf("SSH SERVICE - Forwarding the %s agent to %s", host.ForwardAgent, host.Label)
+	return nil
+}
@@ -0,0 +1,255 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"termunator/internal/models"
+)
+
+// maxKeepBothSuffix bounds the search for a free "name (n).ext"
+const maxKeepBothSuffix = 10000
+
+// errSkipped ends a transfer whose destination is kept by its conflict policy
+var errSkipped = errors.New("destination exists")
+
+// transferEnds returns where a transfer reads from and writes to, and whether the destination
+// is on the server
+func transferEnds(transfer models.Transfer) (source, destination string, remoteDestination bool) {
+	if transfer.Direction == models.TransferUpload {
+		return transfer.LocalPath, transfer.RemotePath, true
+	}
+	return transfer.RemotePath, transfer.LocalPath, false
+}
+
+// statPath stats a path on the server or on this machine
+func statPath(client *sftp.Client, remote bool, p string) (os.FileInfo, error) {
+	if remote {
+		return client.Stat(p)
+	}
+	return os.Stat(p)
+}
+
+// joinPath appends a slash separated path below root on the server or on this machine
+func joinPath(remote bool, root, rel string) string {
+	if remote {
+		return path.Join(root, rel)
+	}
+	return filepath.Join(root, filepath.FromSlash(rel))
+}
+
+// resolveConflict applies the conflict policy of a transfer to its destination. Keep both
+// moves the destination of the transfer to a free name, errSkipped means the destination is
+// kept as it is.
+func resolveConflict(client *sftp.Client, transfer *models.Transfer) error {
+	source, destination, remote := transferEnds(*transfer)
+	existing, err := statPath(client, remote, destination)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", destination, err)
+	}
+
+	if existing.IsDir() != transfer.IsDir && transfer.Conflict != models.ConflictKeepBoth && transfer.Conflict != models.ConflictSkip {
+		if existing.IsDir() {
+			return fmt.Errorf("%s is a directory", destination)
+		}
+		return fmt.Errorf("%s is not a directory", destination)
+	}
+
+	switch transfer.Conflict {
+	case "", models.ConflictOverwrite:
+		return nil
+	case models.ConflictSkip:
+		if transfer.IsDir && existing.IsDir() {
+			return nil
+		}
+		return errSkipped
+	case models.ConflictKeepBoth:
+		free, err := freeName(client, remote, destination, transfer.IsDir)
+		if err != nil {
+			return err
+		}
+		if remote {
+			transfer.RemotePath = free
+		} else {
+			transfer.LocalPath = free
+		}
+		return nil
+	}
+
+	// Directories are merged, the files in them are compared one by one
+	if transfer.IsDir {
+		return nil
+	}
+	info, err := statPath(client, !remote, source)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", source, err)
+	}
+
+	switch transfer.Conflict {
+	case models.ConflictNewer:
+		// SFTP only carries whole seconds
+		if info.ModTime().Truncate(time.Second).After(existing.ModTime().Truncate(time.Second)) {
+			return nil
+		}
+		return errSkipped
+	case models.ConflictSizeDiffers:
+		if info.Size() != existing.Size() {
+			return nil
+		}
+		return errSkipped
+	}
+	return fmt.Errorf("unknown conflict policy: %s", transfer.Conflict)
+}
+
+// freeName finds the first "name (n).ext" next to p that does not exist, directories are
+// numbered after their whole name
+func freeName(client *sftp.Client, remote bool, p string, isDir bool) (string, error) {
+	dir, base := path.Split(p)
+	if !remote {
+		dir, base = filepath.Split(p)
+	}
+
+	name, ext := base, ""
+	if dot := strings.LastIndex(base, "."); dot > 0 && !isDir {
+		name, ext = base[:dot], base[dot:]
+	}
+
+	for n := 1; n <= maxKeepBothSuffix; n++ {
+		candidate := dir + fmt.Sprintf("%s (%d)%s", name, n, ext)
+		if _, err := statPath(client, remote, candidate); errors.Is(err, os.ErrNotExist) {
+			return candidate, nil
+		} else if err != nil {
+			return "", fmt.Errorf("failed to check %s: %w", candidate, err)
+		}
+	}
+	return "", fmt.Errorf("no free name for %s", p)
+}
+
+// ScanConflicts lists the destinations of a batch that already exist. Directories that exist
+// on both sides are walked and the files that exist in both are listed too.
+func (m *TransferManager) ScanConflicts(req models.TransferBatchRequest) ([]models.TransferConflict, error) {
+	if req.Direction != models.TransferUpload && req.Direction != models.TransferDownload {
+		return nil, fmt.Errorf("unknown transfer direction: %s", req.Direction)
+	}
+
+	client, closeClient, err := m.connect(req.HostID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient()
+
+	conflicts := []models.TransferConflict{}
+	for i, item := range req.Items {
+		source, destination, remote := transferEnds(models.Transfer{
+			Direction:  req.Direction,
+			LocalPath:  item.LocalPath,
+			RemotePath: item.RemotePath,
+		})
+
+		info, err := statPath(client, !remote, source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", source, err)
+		}
+		existing, err := statPath(client, remote, destination)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", destination, err)
+		}
+		conflicts = append(conflicts, newConflict(i, "", source, destination, info, existing))
+		if !info.IsDir() || !existing.IsDir() {
+			continue
+		}
+
+		walk := newTreeWalk(context.Background(), req.Symlinks)
+		if remote {
+			err = walk.walkLocal(source, "")
+		} else {
+			err = walk.walkRemote(client, source, "")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", source, err)
+		}
+
+		for _, entry := range walk.entries {
+			if entry.info.IsDir() || entry.isLink() {
+				continue
+			}
+			target := joinPath(remote, destination, entry.rel)
+			existing, err := statPath(client, remote, target)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to check %s: %w", target, err)
+			}
+			conflicts = append(conflicts, newConflict(i, entry.rel, entry.source, target, entry.info, existing))
+		}
+	}
+	return conflicts, nil
+}
+
+func newConflict(item int, rel, source, destination string, info, existing os.FileInfo) models.TransferConflict {
+	return models.TransferConflict{
+		Item:               item,
+		Path:               rel,
+		Source:             source,
+		Destination:        destination,
+		IsDir:              info.IsDir(),
+		SourceSize:         info.Size(),
+		SourceModTime:      info.ModTime(),
+		DestinationIsDir:   existing.IsDir(),
+		DestinationSize:    existing.Size(),
+		DestinationModTime: existing.ModTime(),
+	}
+}
+
+// QueueBatch queues the items of a batch, each with the conflict policy of the batch unless
+// it has its own
+func (m *TransferManager) QueueBatch(req models.TransferBatchRequest) ([]*models.Transfer, error) {
+	policies := []models.ConflictPolicy{req.Conflict}
+	for _, item := range req.Items {
+		policies = append(policies, item.Conflict)
+	}
+	for _, policy := range policies {
+		switch policy {
+		case "", models.ConflictOverwrite, models.ConflictSkip, models.ConflictKeepBoth, models.ConflictNewer, models.ConflictSizeDiffers:
+		default:
+			return nil, fmt.Errorf("unknown conflict policy: %s", policy)
+		}
+	}
+
+	transfers := make([]*models.Transfer, 0, len(req.Items))
+	for _, item := range req.Items {
+		conflict := item.Conflict
+		if conflict == "" {
+			conflict = req.Conflict
+		}
+		transfer, err := m.Queue(models.TransferCreateRequest{
+			HostID:     req.HostID,
+			Direction:  req.Direction,
+			LocalPath:  item.LocalPath,
+			RemotePath: item.RemotePath,
+			IsDir:      item.IsDir,
+			Symlinks:   req.Symlinks,
+			Conflict:   conflict,
+		})
+		if err != nil {
+			return transfers, err
+		}
+		transfers = append(transfers, transfer)
+	}
+	return transfers, nil
+}
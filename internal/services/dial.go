@@ -0,0 +1,113 @@
+package services
+
+import (
+	"fmt"
+	"log"
+
+	"golang.org/x/crypto/ssh"
+
+	"termunator/internal/models"
+)
+
+// dialHost opens an authenticated SSH client to host. When the host has jump hosts
+// the chain is dialed hop by hop over direct-tcpip channels, each hop with its own
+// auth and host key verification. Closing the returned client closes the whole chain.
+func (s *SSHService) dialHost(host *models.Host) (*ssh.Client, error) {
+	hops := append(append([]*models.Host{}, host.JumpHosts...), host)
+
+	var chain []*ssh.Client
+	closeChain := func() {
+		for i := len(chain) - 1; i >= 0; i-- {
+			chain[i].Close()
+		}
+	}
+
+	for i, hop := range hops {
+		config, release, err := s.BuildSSHConfig(hop)
+		if err != nil {
+			closeChain()
+			return nil, fmt.Errorf("failed to build SSH config for %s: %w", hopName(hop, i, len(hops)), err)
+		}
+
+		address := fmt.Sprintf("%s:%d", hop.Hostname, hop.Port)
+
+		var client *ssh.Client
+		if len(chain) == 0 {
+			log.Printf("SSH SERVICE - Dialing %s", address)
+			client, err = s.dialDirect(hop, address, config)
+		} else {
+			log.Printf("SSH SERVICE - Dialing %s through %s", address, chain[len(chain)-1].RemoteAddr())
+			client, err = dialThrough(chain[len(chain)-1], address, config)
+		}
+		release()
+		if err != nil {
+			closeChain()
+			return nil, fmt.Errorf("failed to connect to %s (%s): %w", hopName(hop, i, len(hops)), address, err)
+		}
+		chain = append(chain, client)
+	}
+
+	target := chain[len(chain)-1]
+
+	// Only the target gets the agent and X11, jump hosts never see them, like ProxyJump
+	if host.ForwardAgent != models.ForwardAgentOff {
+		if err := s.serveForwardedAgent(target, host); err != nil {
+			log.Printf("SSH SERVICE - Agent forwarding to %s unavailable: %v", host.Label, err)
+		}
+	}
+	if host.ForwardX11 {
+		if err := s.serveX11(target, host); err != nil {
+			log.Printf("SSH SERVICE - X11 forwarding for %s unavailable: %v", host.Label, err)
+		}
+	}
+	if len(chain) > 1 {
+		jumps := chain[:len(chain)-1]
+		go func() {
+			target.Wait()
+			for i := len(jumps) - 1; i >= 0; i-- {
+				jumps[i].Close()
+			}
+		}()
+	}
+
+	return target, nil
+}
+
+// dialDirect opens an SSH client to the first hop, through its proxy if it has one
+func (s *SSHService) dialDirect(host *models.Host, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := s.dialHop(host, address, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+// dialThrough opens an SSH client to address tunnelled over an existing client
+func dialThrough(via *ssh.Client, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := via.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
+
+func hopName(host *models.Host, index, total int) string {
+	if index == total-1 {
+		return host.Label
+	}
+	return fmt.Sprintf("jump host %s", host.Label)
+}
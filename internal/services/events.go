@@ -0,0 +1,24 @@
+package services
+
+import (
+	"context"
+
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+)
+
+// Event names pushed to the frontend through the Wails runtime
+const (
+	EventSessionOutput = "ssh:output"
+	EventSessionState  = "ssh:state"
+	EventAuthPrompt    = "ssh:auth-prompt"
+
+	EventTransferProgress = "sftp:transfer"
+)
+
+// emitEvent sends an event to the frontend, it is a no-op until the Wails context is set
+func emitEvent(ctx context.Context, name string, data ...interface{}) {
+	if ctx == nil {
+		return
+	}
+	runtime.EventsEmit(ctx, name, data...)
+}
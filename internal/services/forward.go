@@ -0,0 +1,390 @@
+package services
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"termunator/internal/models"
+)
+
+const defaultForwardBindAddress = "127.0.0.1"
+
+// activeForward is a port forward running on top of a session's ssh.Client
+type activeForward struct {
+	rule        models.PortForward
+	sessionID   string
+	client      *ssh.Client
+	listener    net.Listener
+	startedAt   time.Time
+	connections atomic.Int64
+	bytesIn     atomic.Int64
+	bytesOut    atomic.Int64
+
+	mutex   sync.Mutex
+	conns   map[net.Conn]struct{}
+	stopped bool
+	err     string
+}
+
+func (f *activeForward) status() models.PortForwardStatus {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return models.PortForwardStatus{
+		Forward:     f.rule,
+		SessionID:   f.sessionID,
+		Active:      !f.stopped && f.err == "",
+		Connections: f.connections.Load(),
+		BytesIn:     f.bytesIn.Load(),
+		BytesOut:    f.bytesOut.Load(),
+		StartedAt:   f.startedAt,
+		Error:       f.err,
+	}
+}
+
+// StartForward starts a -L, -R or -D style forward on an open session
+func (s *SSHService) StartForward(sessionID string, rule models.PortForward) (*models.PortForwardStatus, error) {
+	s.mutex.RLock()
+	session, exists := s.sessions[sessionID]
+	s.mutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+
+	if !session.IsActive {
+		return nil, fmt.Errorf("session %s is not active", sessionID)
+	}
+
+	session.forwardMutex.Lock()
+	defer session.forwardMutex.Unlock()
+
+	if _, running := session.forwards[rule.ID]; running {
+		return nil, fmt.Errorf("port forward %s is already running", rule.ID)
+	}
+
+	bindAddress := rule.BindAddress
+	if bindAddress == "" {
+		bindAddress = defaultForwardBindAddress
+	}
+	address := net.JoinHostPort(bindAddress, strconv.Itoa(rule.BindPort))
+
+	var listener net.Listener
+	var err error
+	session.connMutex.RLock()
+	client := session.Client
+	session.connMutex.RUnlock()
+
+	switch rule.Type {
+	case models.ForwardLocal, models.ForwardDynamic:
+		listener, err = net.Listen("tcp", address)
+	case models.ForwardRemote:
+		listener, err = client.Listen("tcp", address)
+	default:
+		return nil, fmt.Errorf("unsupported forward type: %s", rule.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+
+	forward := &activeForward{
+		rule:      rule,
+		sessionID: sessionID,
+		client:    client,
+		listener:  listener,
+		startedAt: time.Now(),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	session.forwards[rule.ID] = forward
+
+	log.Printf("FORWARD - Started %s forward %s on %s for session %s", rule.Type, rule.ID, listener.Addr(), sessionID)
+	go forward.serve()
+
+	status := forward.status()
+	return &status, nil
+}
+
+// StopForward stops a running forward and closes its open connections
+func (s *SSHService) StopForward(sessionID, forwardID string) error {
+	s.mutex.RLock()
+	session, exists := s.sessions[sessionID]
+	s.mutex.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+
+	session.forwardMutex.Lock()
+	forward, running := session.forwards[forwardID]
+	delete(session.forwards, forwardID)
+	session.forwardMutex.Unlock()
+
+	if !running {
+		return fmt.Errorf("port forward %s is not running", forwardID)
+	}
+
+	forward.stop()
+	log.Printf("FORWARD - Stopped forward %s for session %s", forwardID, sessionID)
+	return nil
+}
+
+// ListForwards returns the forwards of a session, or of every session if sessionID is empty
+func (s *SSHService) ListForwards(sessionID string) ([]models.PortForwardStatus, error) {
+	s.mutex.RLock()
+	var sessions []*SSHSession
+	if sessionID == "" {
+		for _, session := range s.sessions {
+			sessions = append(sessions, session)
+		}
+	} else if session, exists := s.sessions[sessionID]; exists {
+		sessions = append(sessions, session)
+	}
+	s.mutex.RUnlock()
+
+	if sessionID != "" && len(sessions) == 0 {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+
+	result := []models.PortForwardStatus{}
+	for _, session := range sessions {
+		session.forwardMutex.Lock()
+		for _, forward := range session.forwards {
+			result = append(result, forward.status())
+		}
+		session.forwardMutex.Unlock()
+	}
+	return result, nil
+}
+
+// stopForwards stops every forward of a session, used when the session closes
+func (session *SSHSession) stopForwards() {
+	session.forwardMutex.Lock()
+	forwards := session.forwards
+	session.forwards = make(map[string]*activeForward)
+	session.forwardMutex.Unlock()
+
+	for _, forward := range forwards {
+		forward.stop()
+	}
+}
+
+func (f *activeForward) stop() {
+	f.mutex.Lock()
+	f.stopped = true
+	conns := f.conns
+	f.conns = make(map[net.Conn]struct{})
+	f.mutex.Unlock()
+
+	f.listener.Close()
+	for conn := range conns {
+		conn.Close()
+	}
+}
+
+func (f *activeForward) serve() {
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			f.mutex.Lock()
+			if !f.stopped {
+				f.err = err.Error()
+				log.Printf("FORWARD - Forward %s stopped accepting: %v", f.rule.ID, err)
+			}
+			f.mutex.Unlock()
+			return
+		}
+
+		go f.handle(conn)
+	}
+}
+
+func (f *activeForward) handle(conn net.Conn) {
+	if !f.track(conn) {
+		conn.Close()
+		return
+	}
+	defer f.untrack(conn)
+
+	var target net.Conn
+	var err error
+	switch f.rule.Type {
+	case models.ForwardLocal:
+		target, err = f.client.Dial("tcp", net.JoinHostPort(f.rule.TargetHost, strconv.Itoa(f.rule.TargetPort)))
+	case models.ForwardRemote:
+		target, err = net.Dial("tcp", net.JoinHostPort(f.rule.TargetHost, strconv.Itoa(f.rule.TargetPort)))
+	case models.ForwardDynamic:
+		target, err = socks5Accept(conn, f.client.Dial)
+	}
+	if err != nil {
+		log.Printf("FORWARD - Forward %s failed to open target: %v", f.rule.ID, err)
+		conn.Close()
+		return
+	}
+	if !f.track(target) {
+		conn.Close()
+		target.Close()
+		return
+	}
+	defer f.untrack(target)
+
+	f.connections.Add(1)
+	defer f.connections.Add(-1)
+
+	// conn faces the listener, so for remote forwards it is the server side
+	if f.rule.Type == models.ForwardRemote {
+		pipeConns(target, conn, &f.bytesOut, &f.bytesIn)
+	} else {
+		pipeConns(conn, target, &f.bytesOut, &f.bytesIn)
+	}
+}
+
+// track registers an open connection, it returns false if the forward was stopped meanwhile
+func (f *activeForward) track(conn net.Conn) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.stopped {
+		return false
+	}
+	f.conns[conn] = struct{}{}
+	return true
+}
+
+func (f *activeForward) untrack(conn net.Conn) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.conns, conn)
+}
+
+// countingWriter adds the number of written bytes to a counter
+type countingWriter struct {
+	w       io.Writer
+	counter *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if c.counter != nil {
+		c.counter.Add(int64(n))
+	}
+	return n, err
+}
+
+// pipeConns copies in both directions until either side closes.
+// local is the client side, remote is the side reached through SSH, counters may be nil.
+func pipeConns(local, remote io.ReadWriteCloser, sent, received *atomic.Int64) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(countingWriter{remote, sent}, local)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(countingWriter{local, received}, remote)
+		done <- struct{}{}
+	}()
+	<-done
+	local.Close()
+	remote.Close()
+	<-done
+}
+
+// socks5Accept performs the server side of a SOCKS5 CONNECT handshake (no auth)
+// and dials the requested destination
+func socks5Accept(conn net.Conn, dial func(network, address string) (net.Conn, error)) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, fmt.Errorf("failed to read SOCKS greeting: %w", err)
+	}
+	if header[0] != 5 {
+		return nil, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return nil, fmt.Errorf("failed to read SOCKS methods: %w", err)
+	}
+	noAuth := false
+	for _, method := range methods {
+		if method == 0 {
+			noAuth = true
+		}
+	}
+	if !noAuth {
+		conn.Write([]byte{5, 0xff})
+		return nil, errors.New("SOCKS client does not support unauthenticated access")
+	}
+	if _, err := conn.Write([]byte{5, 0}); err != nil {
+		return nil, err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return nil, fmt.Errorf("failed to read SOCKS request: %w", err)
+	}
+	if request[1] != 1 {
+		socks5Reply(conn, 7) // command not supported
+		return nil, fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case 1:
+		addr := make([]byte, 4)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return nil, err
+		}
+		host = net.IP(addr).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return nil, err
+		}
+		host = string(name)
+	case 4:
+		addr := make([]byte, 16)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return nil, err
+		}
+		host = net.IP(addr).String()
+	default:
+		socks5Reply(conn, 8) // address type not supported
+		return nil, fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+
+	portBytes := make([]byte, 2)
+	if _, err := io.ReadFull(conn, portBytes); err != nil {
+		return nil, err
+	}
+	address := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(portBytes))))
+
+	target, err := dial("tcp", address)
+	if err != nil {
+		socks5Reply(conn, 5) // connection refused
+		return nil, fmt.Errorf("failed to dial %s: %w", address, err)
+	}
+	if err := socks5Reply(conn, 0); err != nil {
+		target.Close()
+		return nil, err
+	}
+	return target, nil
+}
+
+func socks5Reply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{5, code, 0, 1, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"log"
+	"net"
+
+	"golang.org/x/crypto/ssh"
+
+	"termunator/internal/models"
+)
+
+// HostCAStore lists the certificate authorities trusted to sign host keys
+type HostCAStore interface {
+	GetHostCAs() ([]*models.HostCA, error)
+}
+
+// SetHostCAStore sets where trusted host CAs are read from
+func (s *SSHService) SetHostCAStore(store HostCAStore) {
+	s.hostCAs = store
+}
+
+// hostCAsFor returns the fingerprints of the CAs trusted for host, global ones and
+// those limited to one of its tags
+func (s *SSHService) hostCAsFor(host *models.Host) map[string]string {
+	if s.hostCAs == nil {
+		return nil
+	}
+	cas, err := s.hostCAs.GetHostCAs()
+	if err != nil {
+		log.Printf("SSH SERVICE - Failed to load host CAs: %v", err)
+		return nil
+	}
+
+	trusted := make(map[string]string)
+	for _, ca := range cas {
+		if len(ca.Tags) == 0 || sharesTag(ca.Tags, host.Tags) {
+			trusted[ca.Fingerprint] = ca.Name
+		}
+	}
+	return trusted
+}
+
+// checkHostCertificate reports whether cert is a valid host certificate for hostname signed
+// by a CA trusted for host. Principals, validity period and signature are all checked.
+func (s *SSHService) checkHostCertificate(host *models.Host, hostname string, remote net.Addr, cert *ssh.Certificate) bool {
+	trusted := s.hostCAsFor(host)
+	if len(trusted) == 0 {
+		return false
+	}
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			_, ok := trusted[ssh.FingerprintSHA256(auth)]
+			return ok
+		},
+		// known_hosts @revoked lines apply to app CAs too, for the host key and its signer
+		IsRevoked: func(cert *ssh.Certificate) bool {
+			return s.hostKeyRevoked(cert.Key) || s.hostKeyRevoked(cert.SignatureKey)
+		},
+	}
+	if err := checker.CheckHostKey(hostname, remote, cert); err != nil {
+		log.Printf("SSH SERVICE - Host certificate of %s not accepted: %v", hostname, err)
+		return false
+	}
+
+	log.Printf("SSH SERVICE - Host certificate of %s signed by trusted CA %s", hostname, trusted[ssh.FingerprintSHA256(cert.SignatureKey)])
+	return true
+}
+
+// hostKeyRevoked reports whether key is marked @revoked, failing closed when known_hosts cannot be read
+func (s *SSHService) hostKeyRevoked(key ssh.PublicKey) bool {
+	revoked, err := s.known.Revoked(key)
+	if err != nil {
+		log.Printf("SSH SERVICE - Failed to check known_hosts revocations: %v", err)
+		return true
+	}
+	return revoked
+}
+
+func sharesTag(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
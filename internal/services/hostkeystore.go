@@ -0,0 +1,220 @@
+package services
+
+import (
+	"log"
+	"os/user"
+	"strings"
+	"time"
+
+	"termunator/internal/models"
+)
+
+// KnownHostsStore keeps trusted host keys in the app database so ~/.ssh/known_hosts
+// used by the command-line OpenSSH is left alone
+type KnownHostsStore interface {
+	GetKnownHosts() ([]*models.KnownHost, error)
+	SaveKnownHosts(deleted []string, saved []*models.KnownHost) error
+}
+
+// SetStore switches host key checks and edits to store, nil goes back to the known_hosts file
+func (k *KnownHostsService) SetStore(store KnownHostsStore) {
+	k.mutex.Lock()
+	k.store = store
+	k.mutex.Unlock()
+}
+
+// UsesStore reports whether host keys are kept in the app store
+func (k *KnownHostsService) UsesStore() bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.store != nil
+}
+
+// readStore loads the rows of the app store as known_hosts lines numbered by position
+func (k *KnownHostsService) readStore() ([]knownHostsLine, error) {
+	records, err := k.store.GetKnownHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make([]knownHostsLine, 0, len(records))
+	for _, record := range records {
+		text := knownHostText(record)
+		entry := parseKnownHostsLine(text, len(lines)+1)
+		if entry == nil {
+			log.Printf("KNOWN HOSTS - Skipping invalid stored host key %s", record.ID)
+			continue
+		}
+		firstSeen := record.FirstSeen
+		entry.AcceptedBy = record.AcceptedBy
+		entry.FirstSeen = &firstSeen
+		lines = append(lines, knownHostsLine{text: text, entry: entry, record: record})
+	}
+	return lines, nil
+}
+
+// writeStore saves lines as the content of the app store. Rows keep their first-seen date and
+// who accepted them, new lines are recorded as accepted by the local user.
+func (k *KnownHostsService) writeStore(lines []knownHostsLine) error {
+	existing, err := k.store.GetKnownHosts()
+	if err != nil {
+		return err
+	}
+
+	kept := make(map[string]bool)
+	var saved []*models.KnownHost
+	for _, line := range lines {
+		entry := line.entry
+		if entry == nil {
+			entry = parseKnownHostsLine(line.text, 0)
+		}
+		if entry == nil {
+			// comments and blank lines only exist in files
+			continue
+		}
+
+		record := line.record
+		if record == nil {
+			record = &models.KnownHost{AcceptedBy: localUsername()}
+		} else if record.ID != "" {
+			kept[record.ID] = true
+			if knownHostText(record) == strings.TrimSpace(line.text) {
+				continue
+			}
+		}
+
+		record.Marker = entry.Marker
+		record.Hosts = entry.Hostname
+		record.Algorithm = entry.Algorithm
+		record.Fingerprint = entry.Fingerprint
+		record.PublicKey = entry.PublicKey
+		record.Comment = entry.Comment
+		saved = append(saved, record)
+	}
+
+	var deleted []string
+	for _, record := range existing {
+		if !kept[record.ID] {
+			deleted = append(deleted, record.ID)
+		}
+	}
+
+	return k.store.SaveKnownHosts(deleted, saved)
+}
+
+// Import adds every entry of an OpenSSH known_hosts file, ~/.ssh/known_hosts if path is empty,
+// that the store in use does not have yet. It returns how many entries were added.
+func (k *KnownHostsService) Import(path string) (int, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	path = expandHome(path)
+	if path == "" {
+		path = k.path
+	}
+	source, err := readKnownHostsFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	lines, err := k.read()
+	if err != nil {
+		return 0, err
+	}
+
+	added := mergeKnownHosts(&lines, source, func() *models.KnownHost {
+		return &models.KnownHost{AcceptedBy: "import from " + path, FirstSeen: time.Now()}
+	})
+	if added == 0 {
+		return 0, nil
+	}
+	if err := k.write(lines); err != nil {
+		return 0, err
+	}
+	log.Printf("KNOWN HOSTS - Imported %d entries from %s", added, path)
+	return added, nil
+}
+
+// Export adds every entry of the store in use to an OpenSSH known_hosts file that does not
+// have it yet, ~/.ssh/known_hosts if path is empty. It returns how many entries were added.
+func (k *KnownHostsService) Export(path string) (int, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	path = expandHome(path)
+	if path == "" {
+		path = k.path
+	}
+	lines, err := k.read()
+	if err != nil {
+		return 0, err
+	}
+
+	target, err := readKnownHostsFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	added := mergeKnownHosts(&target, lines, nil)
+	if added == 0 {
+		return 0, nil
+	}
+	if err := writeKnownHostsFile(path, target); err != nil {
+		return 0, err
+	}
+	log.Printf("KNOWN HOSTS - Exported %d entries to %s", added, path)
+	return added, nil
+}
+
+// mergeKnownHosts appends the entries of source missing from lines, comparing marker, hosts and key.
+// newRecord, when set, gives the store row of every appended entry.
+func mergeKnownHosts(lines *[]knownHostsLine, source []knownHostsLine, newRecord func() *models.KnownHost) int {
+	present := make(map[string]bool)
+	for _, line := range *lines {
+		if line.entry != nil {
+			present[knownHostIdentity(line.entry)] = true
+		}
+	}
+
+	added := 0
+	for _, line := range source {
+		entry := line.entry
+		if entry == nil || present[knownHostIdentity(entry)] {
+			continue
+		}
+		present[knownHostIdentity(entry)] = true
+
+		text := strings.TrimSpace(line.text)
+		merged := knownHostsLine{text: text, entry: parseKnownHostsLine(text, len(*lines)+1)}
+		if newRecord != nil {
+			merged.record = newRecord()
+		}
+		*lines = append(*lines, merged)
+		added++
+	}
+	return added
+}
+
+func knownHostIdentity(entry *KnownHostEntry) string {
+	return entry.Marker + " " + entry.Hostname + " " + entry.PublicKey
+}
+
+// knownHostText formats a store row as a known_hosts line
+func knownHostText(record *models.KnownHost) string {
+	text := record.Hosts + " " + record.PublicKey
+	if record.Marker != "" {
+		text = record.Marker + " " + text
+	}
+	if record.Comment != "" {
+		text += " " + record.Comment
+	}
+	return text
+}
+
+func localUsername() string {
+	current, err := user.Current()
+	if err != nil {
+		return "unknown"
+	}
+	return current.Username
+}
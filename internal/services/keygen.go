@@ -0,0 +1,134 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"termunator/internal/models"
+)
+
+const (
+	defaultRSABits   = 4096
+	minRSABits       = 2048
+	defaultECDSABits = 256
+)
+
+// GenerateKey creates a new private key in OpenSSH format, encrypted when passphrase is set,
+// and returns it with its public key in authorized_keys format
+func GenerateKey(keyType string, bits int, comment, passphrase string) (string, string, error) {
+	var key crypto.PrivateKey
+	var err error
+
+	switch keyType {
+	case models.KeyTypeEd25519, "":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+
+	case models.KeyTypeECDSA:
+		var curve elliptic.Curve
+		switch bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return "", "", fmt.Errorf("ECDSA keys must be 256, 384 or 521 bits")
+		}
+		key, err = ecdsa.GenerateKey(curve, rand.Reader)
+
+	case models.KeyTypeRSA:
+		if bits == 0 {
+			bits = defaultRSABits
+		}
+		if bits < minRSABits {
+			return "", "", fmt.Errorf("RSA keys must be at least %d bits", minRSABits)
+		}
+		key, err = rsa.GenerateKey(rand.Reader, bits)
+
+	default:
+		return "", "", fmt.Errorf("unsupported key type: %s", keyType)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate %s key: %w", keyType, err)
+	}
+
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(key, comment, []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(key, comment)
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read public key: %w", err)
+	}
+	publicKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	if comment != "" {
+		publicKey += " " + comment
+	}
+
+	return string(pem.EncodeToMemory(block)), publicKey, nil
+}
+
+// installPublicKeyScript appends the key from stdin to ~/.ssh/authorized_keys unless it is already
+// there, creating the directory and file with the permissions sshd insists on, like ssh-copy-id
+const installPublicKeyScript = `umask 077
+mkdir -p ~/.ssh && chmod 700 ~/.ssh || exit 1
+touch ~/.ssh/authorized_keys && chmod 600 ~/.ssh/authorized_keys || exit 1
+read -r key || exit 1
+if grep -qxF "$key" ~/.ssh/authorized_keys; then exit 0; fi
+if [ -s ~/.ssh/authorized_keys ] && [ -n "$(tail -c 1 ~/.ssh/authorized_keys)" ]; then echo >> ~/.ssh/authorized_keys; fi
+printf '%s\n' "$key" >> ~/.ssh/authorized_keys`
+
+// InstallPublicKey adds publicKey to the authorized_keys of the host's user over the shared
+// connection, so an open password-authenticated session is reused
+func (s *SSHService) InstallPublicKey(host *models.Host, publicKey string) error {
+	publicKey = strings.TrimSpace(publicKey)
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey)); err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+
+	client, err := s.pool.Acquire(host)
+	if err != nil {
+		return err
+	}
+	defer s.pool.Release(client)
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	session.Stdin = strings.NewReader(publicKey + "\n")
+	output, err := session.CombinedOutput("sh -c '" + strings.ReplaceAll(installPublicKeyScript, "'", `'\''`) + "'")
+	if err != nil {
+		return fmt.Errorf("failed to install public key: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	log.Printf("SSH SERVICE - Installed public key %s for %s@%s", publicKeyFingerprint(publicKey), host.Username, host.Hostname)
+	return nil
+}
+
+func publicKeyFingerprint(authorizedKey string) string {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(authorizedKey))
+	if err != nil {
+		return ""
+	}
+	return ssh.FingerprintSHA256(key)
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"termunator/internal/models"
+	"termunator/internal/storage"
+)
+
+const (
+	defaultKeyCacheTimeout = 30 * time.Minute
+	passphraseMaxAttempts  = 3
+)
+
+// KeyStore resolves the stored keys hosts reference and keeps the passphrases the user
+// asked us to remember, keyed by the key data
+type KeyStore interface {
+	GetPrivateKey(id string) (*models.PrivateKey, error)
+	GetPrivateKeys() ([]*models.PrivateKeyInfo, error)
+	GetKeyPassphrase(keyData string) (string, error)
+	SaveKeyPassphrase(keyData, passphrase string) error
+}
+
+// keyCache holds decrypted signers in memory so an encrypted key is only unlocked once
+// per timeout. A timeout of zero keeps signers until the cache is cleared.
+type keyCache struct {
+	mutex   sync.Mutex
+	signers map[string]*cachedSigner
+	timeout time.Duration
+}
+
+type cachedSigner struct {
+	signer ssh.Signer
+	timer  *time.Timer
+}
+
+func newKeyCache() *keyCache {
+	return &keyCache{
+		signers: make(map[string]*cachedSigner),
+		timeout: defaultKeyCacheTimeout,
+	}
+}
+
+func (c *keyCache) get(id string) ssh.Signer {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if entry, exists := c.signers[id]; exists {
+		return entry.signer
+	}
+	return nil
+}
+
+func (c *keyCache) put(id string, signer ssh.Signer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if entry, exists := c.signers[id]; exists && entry.timer != nil {
+		entry.timer.Stop()
+	}
+
+	entry := &cachedSigner{signer: signer}
+	if c.timeout > 0 {
+		entry.timer = time.AfterFunc(c.timeout, func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			if c.signers[id] == entry {
+				delete(c.signers, id)
+				log.Printf("KEYS - Unlocked key %s expired", id[:12])
+			}
+		})
+	}
+	c.signers[id] = entry
+}
+
+func (c *keyCache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, entry := range c.signers {
+		if entry.timer != nil {
+			entry.timer.Stop()
+		}
+	}
+	c.signers = make(map[string]*cachedSigner)
+}
+
+func (c *keyCache) setTimeout(timeout time.Duration) {
+	c.mutex.Lock()
+	c.timeout = timeout
+	c.mutex.Unlock()
+}
+
+// SetKeyStore sets where stored keys and remembered passphrases are read from
+func (s *SSHService) SetKeyStore(store KeyStore) {
+	s.keyStore = store
+}
+
+// SetKeyCacheTimeout sets how long unlocked keys stay in memory, zero keeps them until LockKeys.
+// Keys that are already unlocked keep their old expiry.
+func (s *SSHService) SetKeyCacheTimeout(timeout time.Duration) {
+	s.keys.setTimeout(timeout)
+}
+
+// LockKeys forgets every unlocked key, including the keys loaded into the built-in agent,
+// the next use asks for the passphrase again
+func (s *SSHService) LockKeys() {
+	s.keys.clear()
+	if err := s.agent.RemoveAll(); err != nil {
+		log.Printf("KEYS - Failed to remove keys from the built-in agent: %v", err)
+	}
+	log.Printf("KEYS - Forgot all unlocked keys")
+}
+
+// hostKey returns the key a host uses, inline keys of older hosts only carry KeyData
+func (s *SSHService) hostKey(host *models.Host) (*models.PrivateKey, error) {
+	if host.PrivateKeyID == "" {
+		if host.PrivateKey == "" {
+			return nil, fmt.Errorf("private key is required for key authentication")
+		}
+		return &models.PrivateKey{KeyData: host.PrivateKey}, nil
+	}
+
+	if s.keyStore == nil {
+		return nil, errors.New("key store is not available")
+	}
+	key, err := s.keyStore.GetPrivateKey(host.PrivateKeyID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("private key %s not found", host.PrivateKeyID)
+	}
+	return key, nil
+}
+
+// privateKeySigners returns the signers to offer for the host's key. A key with an attached
+// certificate offers the certificate first and the plain key as a fallback, like OpenSSH.
+func (s *SSHService) privateKeySigners(host *models.Host) ([]ssh.Signer, error) {
+	key, err := s.hostKey(host)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := s.privateKeySigner(host, key.KeyData, key.Name)
+	if err != nil {
+		return nil, err
+	}
+	if key.Certificate == "" {
+		return []ssh.Signer{signer}, nil
+	}
+
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.Certificate))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate of private key %q: %w", key.Name, err)
+	}
+	cert, ok := parsed.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("certificate of private key %q is not an OpenSSH certificate", key.Name)
+	}
+
+	if err := s.confirmCertificateValidity(host, key.Name, cert); err != nil {
+		return nil, err
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use certificate of private key %q: %w", key.Name, err)
+	}
+	log.Printf("KEYS - Offering certificate %q (serial %d) for %s", cert.KeyId, cert.Serial, host.Label)
+	return []ssh.Signer{certSigner, signer}, nil
+}
+
+// confirmCertificateValidity asks the user whether to connect anyway when the certificate
+// is expired or not valid yet, the server would most likely reject it
+func (s *SSHService) confirmCertificateValidity(host *models.Host, keyName string, cert *ssh.Certificate) error {
+	now := uint64(time.Now().Unix())
+
+	var problem string
+	switch {
+	case cert.ValidBefore != ssh.CertTimeInfinity && now >= cert.ValidBefore:
+		problem = fmt.Sprintf("expired on %s", time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC1123))
+	case now < cert.ValidAfter:
+		problem = fmt.Sprintf("is not valid before %s", time.Unix(int64(cert.ValidAfter), 0).Format(time.RFC1123))
+	default:
+		return nil
+	}
+
+	log.Printf("KEYS - Certificate of key %q for %s %s", keyName, host.Label, problem)
+	_, err := s.prompts.ask(s, AuthPrompt{
+		Kind:      PromptConfirm,
+		HostID:    host.ID,
+		HostLabel: host.Label,
+		User:      host.Username,
+		Instruction: fmt.Sprintf("The certificate of private key %q %s and will likely be rejected.\n"+
+			"Connect anyway and fall back to the plain key?", keyName, problem),
+	})
+	if err != nil {
+		return fmt.Errorf("certificate of private key %q %s", keyName, problem)
+	}
+	return nil
+}
+
+// privateKeySigner parses a private key, unlocking it with a cached signer, a
+// remembered passphrase or a passphrase prompt when it is encrypted
+func (s *SSHService) privateKeySigner(host *models.Host, keyData, keyName string) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(keyData))
+	if err == nil {
+		return signer, nil
+	}
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, fmt.Errorf("failed to parse private key (key may be invalid or corrupted): %w", err)
+	}
+
+	id := storage.KeyDataID(keyData)
+	if signer := s.keys.get(id); signer != nil {
+		log.Printf("KEYS - Using unlocked key for %s", host.Label)
+		return signer, nil
+	}
+
+	prompt := AuthPrompt{
+		HostID:    host.ID,
+		HostLabel: host.Label,
+		User:      host.Username,
+	}
+	key, err := s.unlockPrivateKey(prompt, keyData, keyName, missing)
+	if err != nil {
+		return nil, err
+	}
+	signer, err = ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to use private key: %w", err)
+	}
+	s.keys.put(id, signer)
+	return signer, nil
+}
+
+// unlockPrivateKey decrypts an encrypted private key with a remembered passphrase or by
+// asking for it. The prompt carries who the key is unlocked for.
+func (s *SSHService) unlockPrivateKey(prompt AuthPrompt, keyData, keyName string, missing *ssh.PassphraseMissingError) (interface{}, error) {
+	if s.keyStore != nil {
+		passphrase, err := s.keyStore.GetKeyPassphrase(keyData)
+		if err != nil {
+			log.Printf("KEYS - Failed to read remembered passphrase: %v", err)
+		} else if passphrase != "" {
+			key, err := ssh.ParseRawPrivateKeyWithPassphrase([]byte(keyData), []byte(passphrase))
+			if err == nil {
+				log.Printf("KEYS - Unlocked key for %s with remembered passphrase", prompt.HostLabel)
+				return key, nil
+			}
+			log.Printf("KEYS - Remembered passphrase for %s no longer works: %v", prompt.HostLabel, err)
+		}
+	}
+
+	prompt.Kind = PromptPassphrase
+	prompt.Instruction = "The private key is encrypted."
+	prompt.Questions = []AuthPromptQuestion{{Prompt: "Passphrase:", Echo: false}}
+	prompt.AllowRemember = s.keyStore != nil
+	if keyName != "" {
+		prompt.Instruction = fmt.Sprintf("The private key %q is encrypted.", keyName)
+	} else if missing.PublicKey != nil {
+		prompt.Instruction = fmt.Sprintf("The private key %s is encrypted.", ssh.FingerprintSHA256(missing.PublicKey))
+	}
+
+	for attempt := 1; attempt <= passphraseMaxAttempts; attempt++ {
+		reply, err := s.prompts.ask(s, prompt)
+		if err != nil {
+			return nil, err
+		}
+		if len(reply.answers) != 1 {
+			return nil, fmt.Errorf("expected 1 answer, got %d", len(reply.answers))
+		}
+
+		key, err := ssh.ParseRawPrivateKeyWithPassphrase([]byte(keyData), []byte(reply.answers[0]))
+		if err != nil {
+			if errors.Is(err, x509.IncorrectPasswordError) {
+				prompt.Instruction = "Incorrect passphrase, try again."
+				continue
+			}
+			return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+		}
+
+		if reply.remember && s.keyStore != nil {
+			if err := s.keyStore.SaveKeyPassphrase(keyData, reply.answers[0]); err != nil {
+				log.Printf("KEYS - Failed to remember passphrase: %v", err)
+			}
+		}
+		log.Printf("KEYS - Unlocked key for %s", prompt.HostLabel)
+		return key, nil
+	}
+
+	return nil, errors.New("incorrect passphrase for private key")
+}
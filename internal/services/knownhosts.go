@@ -0,0 +1,511 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"termunator/internal/models"
+)
+
+// known_hosts line markers
+const (
+	MarkerRevoked       = "@revoked"
+	MarkerCertAuthority = "@cert-authority"
+)
+
+// KnownHostEntry is one key line of a known_hosts file, or one row of the app store
+// where Line is its position
+type KnownHostEntry struct {
+	Line        int        `json:"line"`
+	Marker      string     `json:"marker,omitempty"`
+	Hosts       []string   `json:"hosts"` // patterns as written, hashed ones stay hashed
+	Hashed      bool       `json:"hashed"`
+	Hostname    string     `json:"hostname"` // hosts joined for display
+	Algorithm   string     `json:"algorithm"`
+	Fingerprint string     `json:"fingerprint"`
+	PublicKey   string     `json:"publicKey"`
+	Comment     string     `json:"comment,omitempty"`
+	AcceptedBy  string     `json:"acceptedBy,omitempty"` // only kept by the app store
+	FirstSeen   *time.Time `json:"firstSeen,omitempty"`
+}
+
+// KnownHostsService reads and edits an OpenSSH known_hosts file, or the app store when
+// one is set. Edits rewrite the file through a temporary file so it is never left half written.
+type KnownHostsService struct {
+	mutex   sync.Mutex
+	path    string
+	store   KnownHostsStore
+	hashNew bool
+	strict  bool
+}
+
+func NewKnownHostsService() *KnownHostsService {
+	path := ""
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		path = filepath.Join(homeDir, ".ssh", "known_hosts")
+	}
+	return &KnownHostsService{path: path}
+}
+
+// SetHashHosts sets whether new entries are written with hashed hostnames (HashKnownHosts)
+func (k *KnownHostsService) SetHashHosts(enabled bool) {
+	k.mutex.Lock()
+	k.hashNew = enabled
+	k.mutex.Unlock()
+}
+
+// SetStrict sets whether changed host keys are refused outright instead of offered for replacement
+func (k *KnownHostsService) SetStrict(enabled bool) {
+	k.mutex.Lock()
+	k.strict = enabled
+	k.mutex.Unlock()
+}
+
+func (k *KnownHostsService) Strict() bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.strict
+}
+
+// knownHostsLine is a raw line with its parsed entry, entry is nil for comments, blanks and invalid lines.
+// Lines loaded from the app store keep their row in record.
+type knownHostsLine struct {
+	text   string
+	entry  *KnownHostEntry
+	record *models.KnownHost
+}
+
+// read loads every line of the store in use
+func (k *KnownHostsService) read() ([]knownHostsLine, error) {
+	if k.store != nil {
+		return k.readStore()
+	}
+	return readKnownHostsFile(k.path)
+}
+
+// write replaces the content of the store in use with lines
+func (k *KnownHostsService) write(lines []knownHostsLine) error {
+	if k.store != nil {
+		return k.writeStore(lines)
+	}
+	return writeKnownHostsFile(k.path, lines)
+}
+
+// readKnownHostsFile parses a whole file, keeping every line so edits preserve comments and order
+func readKnownHostsFile(path string) ([]knownHostsLine, error) {
+	if path == "" {
+		return nil, errors.New("failed to locate known_hosts file")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read known_hosts file: %w", err)
+	}
+
+	var lines []knownHostsLine
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := scanner.Text()
+		lines = append(lines, knownHostsLine{text: text, entry: parseKnownHostsLine(text, number)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts file: %w", err)
+	}
+	return lines, nil
+}
+
+func parseKnownHostsLine(text string, number int) *KnownHostEntry {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.HasPrefix(text, "#") {
+		return nil
+	}
+
+	entry := &KnownHostEntry{Line: number}
+	fields := strings.Fields(text)
+	if strings.HasPrefix(fields[0], "@") {
+		entry.Marker = fields[0]
+		fields = fields[1:]
+	}
+	if len(fields) < 3 {
+		return nil
+	}
+
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[1:], " ")))
+	if err != nil {
+		return nil
+	}
+
+	entry.Hosts = strings.Split(fields[0], ",")
+	entry.Hashed = strings.HasPrefix(fields[0], "|1|")
+	entry.Hostname = fields[0]
+	entry.Algorithm = key.Type()
+	entry.Fingerprint = ssh.FingerprintSHA256(key)
+	entry.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	entry.Comment = comment
+	return entry
+}
+
+// writeKnownHostsFile replaces the file atomically with lines
+func writeKnownHostsFile(path string, lines []knownHostsLine) error {
+	if path == "" {
+		return errors.New("failed to locate known_hosts file")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create .ssh directory: %w", err)
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	var buffer bytes.Buffer
+	for _, line := range lines {
+		buffer.WriteString(line.text)
+		buffer.WriteByte('\n')
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".known_hosts-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary known_hosts file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buffer.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write known_hosts file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write known_hosts file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write known_hosts file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return fmt.Errorf("failed to set known_hosts permissions: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace known_hosts file: %w", err)
+	}
+	return nil
+}
+
+// List returns every key entry of the file
+func (k *KnownHostsService) List() ([]KnownHostEntry, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	lines, err := k.read()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []KnownHostEntry{}
+	for _, line := range lines {
+		if line.entry != nil {
+			entries = append(entries, *line.entry)
+		}
+	}
+	return entries, nil
+}
+
+// Add records key for a host that has no key yet. A host whose key changed is refused,
+// its stale keys have to be dropped with Replace so a second line is never appended.
+func (k *KnownHostsService) Add(address string, key ssh.PublicKey) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	lines, err := k.read()
+	if err != nil {
+		return err
+	}
+
+	host := knownhosts.Normalize(address)
+	fingerprint := ssh.FingerprintSHA256(key)
+	for _, line := range lines {
+		entry := line.entry
+		if entry == nil || entry.Marker != "" || !entryNamesHost(entry, host) {
+			continue
+		}
+		if entry.Fingerprint == fingerprint {
+			return nil
+		}
+		return fmt.Errorf("a different host key is already known for %s (known_hosts line %d)", host, entry.Line)
+	}
+
+	lines = append(lines, knownHostsLine{text: k.hostLine(host, key)})
+	if err := k.write(lines); err != nil {
+		return err
+	}
+	log.Printf("KNOWN HOSTS - Added %s key for %s", key.Type(), host)
+	return nil
+}
+
+// Replace drops the stale keys a HostKeyMismatch reported for address and records key instead.
+// Every stale line must still hold the reported key, so a file edited since the mismatch is left alone.
+// Only the patterns naming the host are removed, a line it matched through a wildcard trusts other
+// hosts as well and is refused.
+func (k *KnownHostsService) Replace(address string, stale []KnownHostKey, key ssh.PublicKey) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.strict {
+		return errors.New("strict host key checking is enabled, remove the old key from known hosts first")
+	}
+	if len(stale) == 0 {
+		return errors.New("no stale host key to replace")
+	}
+
+	lines, err := k.read()
+	if err != nil {
+		return err
+	}
+
+	host := knownhosts.Normalize(address)
+	staleLines := make(map[int]bool)
+	for _, old := range stale {
+		if old.Line < 1 || old.Line > len(lines) {
+			return fmt.Errorf("known_hosts line %d no longer exists, reconnect to check the host key again", old.Line)
+		}
+		entry := lines[old.Line-1].entry
+		if entry == nil || entry.Marker != "" || entry.Fingerprint != old.Fingerprint {
+			return fmt.Errorf("known_hosts line %d has changed, reconnect to check the host key again", old.Line)
+		}
+		if !entryNamesHost(entry, host) {
+			return fmt.Errorf("known_hosts line %d matches %s only through the pattern %q, edit the file to change its key",
+				old.Line, host, entry.Hostname)
+		}
+		staleLines[old.Line] = true
+	}
+
+	result := lines[:0]
+	for i, line := range lines {
+		if !staleLines[i+1] {
+			result = append(result, line)
+			continue
+		}
+		if rest, kept := withoutHost(line, host); kept {
+			result = append(result, rest)
+		}
+	}
+	result = append(result, knownHostsLine{text: k.hostLine(host, key)})
+
+	if err := k.write(result); err != nil {
+		return err
+	}
+	log.Printf("KNOWN HOSTS - Replaced %d stale keys for %s with %s %s", len(staleLines), host, key.Type(), ssh.FingerprintSHA256(key))
+	return nil
+}
+
+// hostLine formats a new key line, hashing host when HashKnownHosts is on
+func (k *KnownHostsService) hostLine(host string, key ssh.PublicKey) string {
+	if k.hashNew {
+		host = knownhosts.HashHostname(host)
+	}
+	return host + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+}
+
+// Remove deletes every key stored for address, markers are left alone.
+// It returns how many entries were removed.
+func (k *KnownHostsService) Remove(address string) (int, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	lines, err := k.read()
+	if err != nil {
+		return 0, err
+	}
+
+	lines, removed := removeKnownHost(lines, address)
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := k.write(lines); err != nil {
+		return 0, err
+	}
+	log.Printf("KNOWN HOSTS - Removed %d entries for %s", removed, knownhosts.Normalize(address))
+	return removed, nil
+}
+
+// RemoveLine deletes a single entry by its line number as returned by List
+func (k *KnownHostsService) RemoveLine(number int) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	lines, err := k.read()
+	if err != nil {
+		return err
+	}
+	if number < 1 || number > len(lines) || lines[number-1].entry == nil {
+		return fmt.Errorf("known_hosts line %d is not a host key entry", number)
+	}
+
+	lines = append(lines[:number-1], lines[number:]...)
+	return k.write(lines)
+}
+
+// Clear removes every entry
+func (k *KnownHostsService) Clear() error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.write(nil)
+}
+
+// Check verifies a host key against the store in use, honoring @revoked and @cert-authority.
+// An unknown host yields a *knownhosts.KeyError without wanted keys, a changed key one with them.
+func (k *KnownHostsService) Check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	k.mutex.Lock()
+	path, store := k.path, k.store
+	k.mutex.Unlock()
+
+	if store != nil {
+		// The app store is checked as a temporary known_hosts file with one line per row,
+		// so matching and line numbers are exactly OpenSSH's
+		k.mutex.Lock()
+		lines, err := k.readStore()
+		k.mutex.Unlock()
+		if err != nil {
+			return err
+		}
+
+		tmp, err := os.CreateTemp("", "termunator-known_hosts-*")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary known_hosts file: %w", err)
+		}
+		path = tmp.Name()
+		tmp.Close()
+		defer os.Remove(path)
+
+		if err := writeKnownHostsFile(path, lines); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return &knownhosts.KeyError{}
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return fmt.Errorf("failed to load known_hosts: %w", err)
+	}
+	return callback(hostname, remote, key)
+}
+
+// Revoked reports whether key is listed on a @revoked line of the store in use
+func (k *KnownHostsService) Revoked(key ssh.PublicKey) (bool, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	lines, err := k.read()
+	if err != nil {
+		return false, err
+	}
+
+	fingerprint := ssh.FingerprintSHA256(key)
+	for _, line := range lines {
+		if line.entry != nil && line.entry.Marker == MarkerRevoked && line.entry.Fingerprint == fingerprint {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// removeKnownHost drops address from every plain or hashed key line. Lines listing other
+// hosts as well keep those, lines with markers are never touched.
+func removeKnownHost(lines []knownHostsLine, address string) ([]knownHostsLine, int) {
+	host := knownhosts.Normalize(address)
+
+	removed := 0
+	result := lines[:0]
+	for _, line := range lines {
+		entry := line.entry
+		if entry == nil || entry.Marker != "" || !entryNamesHost(entry, host) {
+			result = append(result, line)
+			continue
+		}
+
+		removed++
+		if rest, kept := withoutHost(line, host); kept {
+			result = append(result, rest)
+		}
+	}
+	return result, removed
+}
+
+// entryNamesHost reports whether one of the patterns of entry names exactly host
+func entryNamesHost(entry *KnownHostEntry, host string) bool {
+	for _, pattern := range entry.Hosts {
+		if knownHostMatches(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutHost returns line with the patterns naming host removed, kept is false when none is left
+func withoutHost(line knownHostsLine, host string) (knownHostsLine, bool) {
+	entry := line.entry
+
+	var keep []string
+	for _, pattern := range entry.Hosts {
+		if !knownHostMatches(pattern, host) {
+			keep = append(keep, pattern)
+		}
+	}
+
+	switch {
+	case len(keep) == 0:
+		return knownHostsLine{}, false
+	case len(keep) == len(entry.Hosts):
+		return line, true
+	default:
+		text := strings.Join(keep, ",") + strings.TrimSpace(line.text)[len(entry.Hostname):]
+		return knownHostsLine{text: text, entry: parseKnownHostsLine(text, entry.Line), record: line.record}, true
+	}
+}
+
+// knownHostMatches reports whether a host pattern of a key line names exactly host,
+// hashed patterns are compared by hashing host with their salt
+func knownHostMatches(pattern, host string) bool {
+	if strings.HasPrefix(pattern, "|1|") {
+		parts := strings.Split(pattern[3:], "|")
+		if len(parts) != 2 {
+			return false
+		}
+		salt, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return false
+		}
+		want, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(host))
+		return hmac.Equal(mac.Sum(nil), want)
+	}
+	return strings.EqualFold(pattern, host)
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// maximum bytes of output kept per session, oldest chunks are dropped first
+	outputBufferMaxBytes = 1 << 20
+	// chunks are merged until they reach this size or get emitted
+	outputChunkMaxBytes = 32 * 1024
+	// maximum bytes emitted to the frontend without being acknowledged
+	outputWindowBytes = 256 * 1024
+)
+
+// OutputChunk is a piece of terminal output pushed to the frontend
+type OutputChunk struct {
+	SessionID string `json:"sessionId"`
+	Seq       uint64 `json:"seq"`
+	Data      string `json:"data"`
+	Dropped   int    `json:"dropped"` // bytes lost to buffer overflow right before this chunk
+}
+
+// outputBuffer is a bounded per-session ring of output chunks.
+// The reader appends, the emitter pushes chunks out as long as the
+// frontend keeps acknowledging them.
+type outputBuffer struct {
+	mutex    sync.Mutex
+	chunks   []*OutputChunk
+	size     int
+	nextSeq  uint64
+	emitted  uint64 // highest seq pushed to the frontend
+	acked    uint64 // highest seq acknowledged by the frontend
+	dropped  int    // bytes dropped since the last emitted chunk
+	notify   chan struct{}
+	closed   bool
+	maxBytes int
+}
+
+func newOutputBuffer() *outputBuffer {
+	return &outputBuffer{
+		nextSeq:  1,
+		notify:   make(chan struct{}, 1),
+		maxBytes: outputBufferMaxBytes,
+	}
+}
+
+func (b *outputBuffer) signal() {
+	select {
+	case b.notify <- struct{}{}:
+	default:
+	}
+}
+
+// write appends output, merging into the newest chunk while it has not been emitted yet
+func (b *outputBuffer) write(sessionID string, data string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if n := len(b.chunks); n > 0 {
+		last := b.chunks[n-1]
+		if last.Seq > b.emitted && len(last.Data)+len(data) <= outputChunkMaxBytes {
+			last.Data += data
+			b.size += len(data)
+			b.trim()
+			b.signal()
+			return
+		}
+	}
+
+	b.chunks = append(b.chunks, &OutputChunk{
+		SessionID: sessionID,
+		Seq:       b.nextSeq,
+		Data:      data,
+	})
+	b.nextSeq++
+	b.size += len(data)
+	b.trim()
+	b.signal()
+}
+
+// trim drops the oldest chunks until the buffer fits, caller holds the lock
+func (b *outputBuffer) trim() {
+	drop := 0
+	for drop < len(b.chunks)-1 && b.size > b.maxBytes {
+		chunk := b.chunks[drop]
+		b.size -= len(chunk.Data)
+		if chunk.Seq > b.emitted {
+			// never reached the frontend
+			b.dropped += len(chunk.Data)
+			b.emitted = chunk.Seq
+		}
+		if chunk.Seq > b.acked {
+			b.acked = chunk.Seq
+		}
+		drop++
+	}
+	if drop > 0 {
+		b.chunks = append(b.chunks[:0], b.chunks[drop:]...)
+	}
+}
+
+// inFlight returns the bytes emitted but not yet acknowledged, caller holds the lock
+func (b *outputBuffer) inFlight() int {
+	total := 0
+	for _, chunk := range b.chunks {
+		if chunk.Seq > b.acked && chunk.Seq <= b.emitted {
+			total += len(chunk.Data)
+		}
+	}
+	return total
+}
+
+// next returns the next chunk to emit, or nil if nothing is pending or the window is full
+func (b *outputBuffer) next() *OutputChunk {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.inFlight() >= outputWindowBytes {
+		return nil
+	}
+
+	for _, chunk := range b.chunks {
+		if chunk.Seq > b.emitted {
+			b.emitted = chunk.Seq
+			out := *chunk
+			out.Dropped = b.dropped
+			b.dropped = 0
+			return &out
+		}
+	}
+	return nil
+}
+
+// rest returns the chunks not emitted yet regardless of the window and marks them emitted
+func (b *outputBuffer) rest() []*OutputChunk {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var result []*OutputChunk
+	for _, chunk := range b.chunks {
+		if chunk.Seq > b.emitted {
+			out := *chunk
+			out.Dropped = b.dropped
+			b.dropped = 0
+			result = append(result, &out)
+		}
+	}
+	if n := len(b.chunks); n > 0 && b.chunks[n-1].Seq > b.emitted {
+		b.emitted = b.chunks[n-1].Seq
+	}
+	return result
+}
+
+// ack marks every chunk up to seq as received by the frontend
+func (b *outputBuffer) ack(seq uint64) {
+	b.mutex.Lock()
+	if seq > b.acked {
+		b.acked = seq
+	}
+	if b.acked > b.emitted {
+		b.emitted = b.acked
+	}
+	b.mutex.Unlock()
+	b.signal()
+}
+
+// since returns copies of all buffered chunks newer than seq, used to replay output
+func (b *outputBuffer) since(seq uint64) []OutputChunk {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var result []OutputChunk
+	for _, chunk := range b.chunks {
+		if chunk.Seq > seq {
+			result = append(result, *chunk)
+		}
+	}
+	return result
+}
+
+// drain returns all output not yet acknowledged and acknowledges it
+func (b *outputBuffer) drain() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var sb strings.Builder
+	for _, chunk := range b.chunks {
+		if chunk.Seq > b.acked {
+			sb.WriteString(chunk.Data)
+		}
+	}
+	if n := len(b.chunks); n > 0 {
+		b.acked = b.chunks[n-1].Seq
+		b.emitted = b.acked
+	}
+	b.dropped = 0
+	return sb.String()
+}
+
+// completeUTF8 returns the length of data without a trailing incomplete UTF-8 sequence, output
+// is sent as strings and a rune split across reads would turn into U+FFFD on both sides
+func completeUTF8(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(data)
+}
+
+func (b *outputBuffer) close() {
+	b.mutex.Lock()
+	b.closed = true
+	b.mutex.Unlock()
+	b.signal()
+}
+
+func (b *outputBuffer) isClosed() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.closed
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	"termunator/internal/models"
+)
+
+// ConnectionPool shares one authenticated ssh.Client per host between terminal
+// sessions, SFTP and exec. Users acquire the client and open their own channels
+// on it, the transport is closed when the last user releases it. Editing a host
+// changes its pool key, so later users get a new transport to the new settings while
+// current ones keep theirs until they release it.
+type ConnectionPool struct {
+	mutex    sync.Mutex
+	conns    map[string]*pooledConn // by poolKey
+	byClient map[*ssh.Client]*pooledConn
+	dial     func(host *models.Host) (*ssh.Client, error)
+}
+
+type pooledConn struct {
+	key     string
+	hostID  string
+	label   string
+	address string
+	client  *ssh.Client
+	refs    int
+	ready   chan struct{} // closed once dialing finished
+	err     error
+}
+
+// PooledConnectionInfo describes a shared transport for the frontend
+type PooledConnectionInfo struct {
+	HostID  string `json:"hostId"`
+	Label   string `json:"label"`
+	Address string `json:"address"`
+	Users   int    `json:"users"`
+}
+
+func NewConnectionPool(dial func(host *models.Host) (*ssh.Client, error)) *ConnectionPool {
+	return &ConnectionPool{
+		conns:    make(map[string]*pooledConn),
+		byClient: make(map[*ssh.Client]*pooledConn),
+		dial:     dial,
+	}
+}
+
+// Acquire returns the shared client of a host, dialing it if there is none yet.
+// Every successful Acquire must be paired with a Release.
+func (p *ConnectionPool) Acquire(host *models.Host) (*ssh.Client, error) {
+	key := poolKey(host)
+
+	p.mutex.Lock()
+	conn, exists := p.conns[key]
+	if exists {
+		conn.refs++
+		p.mutex.Unlock()
+
+		<-conn.ready
+		if conn.err != nil {
+			return nil, conn.err
+		}
+		log.Printf("POOL - Reusing connection to %s (%d users)", conn.address, conn.refs)
+		return conn.client, nil
+	}
+
+	conn = &pooledConn{
+		key:     key,
+		hostID:  host.ID,
+		label:   host.Label,
+		address: fmt.Sprintf("%s:%d", host.Hostname, host.Port),
+		refs:    1,
+		ready:   make(chan struct{}),
+	}
+	p.conns[key] = conn
+	p.mutex.Unlock()
+
+	client, err := p.dial(host)
+
+	p.mutex.Lock()
+	if err != nil {
+		conn.err = err
+		if p.conns[key] == conn {
+			delete(p.conns, key)
+		}
+	} else {
+		conn.client = client
+		p.byClient[client] = conn
+	}
+	p.mutex.Unlock()
+	close(conn.ready)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Forget the transport as soon as it dies so the next Acquire redials
+	go func() {
+		client.Wait()
+		p.forget(conn)
+	}()
+
+	return client, nil
+}
+
+// Release drops one user of a client and closes the transport when none are left
+func (p *ConnectionPool) Release(client *ssh.Client) {
+	if client == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	conn, exists := p.byClient[client]
+	if !exists {
+		p.mutex.Unlock()
+		return
+	}
+	conn.refs--
+	last := conn.refs <= 0
+	if last {
+		p.remove(conn)
+	}
+	p.mutex.Unlock()
+
+	if last {
+		log.Printf("POOL - Closing connection to %s, no users left", conn.address)
+		client.Close()
+	}
+}
+
+// Discard closes a transport known to be dead and removes it from the pool,
+// other users will see their channels fail
+func (p *ConnectionPool) Discard(client *ssh.Client) {
+	if client == nil {
+		return
+	}
+
+	p.mutex.Lock()
+	if conn, exists := p.byClient[client]; exists {
+		p.remove(conn)
+	}
+	p.mutex.Unlock()
+
+	client.Close()
+}
+
+// Connections lists the shared transports and how many users each has
+func (p *ConnectionPool) Connections() []PooledConnectionInfo {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	result := []PooledConnectionInfo{}
+	for _, conn := range p.conns {
+		if conn.client == nil {
+			continue
+		}
+		result = append(result, PooledConnectionInfo{
+			HostID:  conn.hostID,
+			Label:   conn.label,
+			Address: conn.address,
+			Users:   conn.refs,
+		})
+	}
+	return result
+}
+
+// poolKey identifies the transport a host needs: its ID and a digest of every setting
+// of it and its jump hosts that the dial depends on
+func poolKey(host *models.Host) string {
+	hash := sha256.New()
+	for _, hop := range append(append([]*models.Host{}, host.JumpHosts...), host) {
+		fmt.Fprintf(hash, "%q %q %d %q %q %q %q %q %q\n", hop.ID, hop.Hostname, hop.Port, hop.Username, hop.AuthMethod,
+			hop.Password, hop.PrivateKey, hop.PrivateKeyID, hop.ProxyID)
+	}
+	fmt.Fprintf(hash, "%q %q %t %t", strings.Join(host.JumpHostIDs, ","), host.ForwardAgent, host.ForwardAgentConfirm, host.ForwardX11)
+	return host.ID + ":" + hex.EncodeToString(hash.Sum(nil))
+}
+
+func (p *ConnectionPool) forget(conn *pooledConn) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.remove(conn)
+}
+
+// remove unlinks a connection, caller holds the lock
+func (p *ConnectionPool) remove(conn *pooledConn) {
+	if p.conns[conn.key] == conn {
+		delete(p.conns, conn.key)
+	}
+	if conn.client != nil {
+		delete(p.byClient, conn.client)
+	}
+}
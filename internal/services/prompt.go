@@ -0,0 +1,194 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+
+	"termunator/internal/models"
+)
+
+const authPromptTimeout = 2 * time.Minute
+
+var (
+	passwordPromptPattern = regexp.MustCompile(`(?i)password`)
+	otpPromptPattern      = regexp.MustCompile(`(?i)(verification code|one[- ]time|otp|token|2fa|two[- ]factor|authenticator|passcode)`)
+)
+
+// AuthPromptQuestion is a single keyboard-interactive question
+type AuthPromptQuestion struct {
+	Prompt string `json:"prompt"`
+	Echo   bool   `json:"echo"`
+}
+
+// Kinds of AuthPrompt
+const (
+	PromptKeyboardInteractive = "keyboard_interactive"
+	PromptPassphrase          = "passphrase"
+	PromptConfirm             = "confirm" // no questions, answering continues and cancelling aborts
+)
+
+// AuthPrompt is pushed to the frontend when the server asks questions we cannot answer ourselves
+type AuthPrompt struct {
+	ID            string               `json:"id"`
+	Kind          string               `json:"kind"`
+	HostID        string               `json:"hostId"`
+	HostLabel     string               `json:"hostLabel"`
+	User          string               `json:"user"`
+	Instruction   string               `json:"instruction"`
+	Questions     []AuthPromptQuestion `json:"questions"`
+	AllowRemember bool                 `json:"allowRemember"` // offer to store the answer in the vault
+}
+
+// promptReply carries the answers of a prompt, nil answers mean it was cancelled
+type promptReply struct {
+	answers  []string
+	remember bool
+}
+
+// promptBroker relays questions to the frontend and waits for the answers
+type promptBroker struct {
+	mutex   sync.Mutex
+	pending map[string]chan promptReply
+}
+
+func newPromptBroker() *promptBroker {
+	return &promptBroker{
+		pending: make(map[string]chan promptReply),
+	}
+}
+
+// ask emits the prompt and blocks until it is answered, cancelled or times out
+func (b *promptBroker) ask(s *SSHService, prompt AuthPrompt) (promptReply, error) {
+	if s.ctx == nil {
+		return promptReply{}, errors.New("no frontend available to answer the authentication prompt")
+	}
+
+	prompt.ID = uuid.New().String()
+	if prompt.Kind == "" {
+		prompt.Kind = PromptKeyboardInteractive
+	}
+	answers := make(chan promptReply, 1)
+
+	b.mutex.Lock()
+	b.pending[prompt.ID] = answers
+	b.mutex.Unlock()
+
+	defer func() {
+		b.mutex.Lock()
+		delete(b.pending, prompt.ID)
+		b.mutex.Unlock()
+	}()
+
+	emitEvent(s.ctx, EventAuthPrompt, prompt)
+
+	select {
+	case result := <-answers:
+		if result.answers == nil {
+			return promptReply{}, errors.New("authentication cancelled")
+		}
+		return result, nil
+	case <-time.After(authPromptTimeout):
+		return promptReply{}, errors.New("authentication prompt timed out")
+	}
+}
+
+// answer delivers the frontend's answers, nil answers cancel the prompt
+func (b *promptBroker) answer(promptID string, reply promptReply) error {
+	b.mutex.Lock()
+	channel, exists := b.pending[promptID]
+	b.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("authentication prompt %s not found or expired", promptID)
+	}
+
+	select {
+	case channel <- reply:
+	default:
+	}
+	return nil
+}
+
+// AnswerAuthPrompt answers a pending keyboard-interactive prompt
+func (s *SSHService) AnswerAuthPrompt(promptID string, answers []string) error {
+	if answers == nil {
+		answers = []string{}
+	}
+	return s.prompts.answer(promptID, promptReply{answers: answers})
+}
+
+// AnswerPassphrasePrompt answers a pending key passphrase prompt, remember stores the
+// passphrase in the vault once it unlocked the key
+func (s *SSHService) AnswerPassphrasePrompt(promptID, passphrase string, remember bool) error {
+	return s.prompts.answer(promptID, promptReply{answers: []string{passphrase}, remember: remember})
+}
+
+// CancelAuthPrompt aborts a pending keyboard-interactive or passphrase prompt
+func (s *SSHService) CancelAuthPrompt(promptID string) error {
+	return s.prompts.answer(promptID, promptReply{})
+}
+
+// keyboardInteractive answers password and OTP questions from the host's stored
+// secrets and relays everything else to the frontend
+func (s *SSHService) keyboardInteractive(host *models.Host) ssh.AuthMethod {
+	return ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		if len(questions) == 0 {
+			return []string{}, nil
+		}
+
+		answers := make([]string, len(questions))
+		var unanswered []int
+		for i, question := range questions {
+			switch {
+			case !echos[i] && otpPromptPattern.MatchString(question) && host.TOTPSecret != "":
+				code, err := GenerateTOTP(host.TOTPSecret, time.Now())
+				if err != nil {
+					return nil, err
+				}
+				log.Printf("SSH SERVICE - Answering OTP prompt for %s from stored TOTP secret", host.Hostname)
+				answers[i] = code
+			case !echos[i] && passwordPromptPattern.MatchString(question) && host.Password != "":
+				answers[i] = host.Password
+			default:
+				unanswered = append(unanswered, i)
+			}
+		}
+
+		if len(unanswered) == 0 {
+			return answers, nil
+		}
+
+		prompt := AuthPrompt{
+			HostID:      host.ID,
+			HostLabel:   host.Label,
+			User:        host.Username,
+			Instruction: instruction,
+		}
+		if name != "" && instruction == "" {
+			prompt.Instruction = name
+		}
+		for _, i := range unanswered {
+			prompt.Questions = append(prompt.Questions, AuthPromptQuestion{Prompt: questions[i], Echo: echos[i]})
+		}
+
+		reply, err := s.prompts.ask(s, prompt)
+		if err != nil {
+			return nil, err
+		}
+		replies := reply.answers
+		if len(replies) != len(unanswered) {
+			return nil, fmt.Errorf("expected %d answers, got %d", len(unanswered), len(replies))
+		}
+		for j, i := range unanswered {
+			answers[i] = replies[j]
+		}
+		return answers, nil
+	})
+}
@@ -0,0 +1,402 @@
+package services
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"termunator/internal/models"
+)
+
+// ProxyStore resolves the saved proxies hosts connect through
+type ProxyStore interface {
+	GetProxy(id string) (*models.Proxy, error)
+}
+
+// proxySettings holds the proxy store and the proxy used by hosts without their own
+type proxySettings struct {
+	mutex        sync.RWMutex
+	store        ProxyStore
+	defaultProxy string
+}
+
+// SetProxyStore sets where saved proxies are read from
+func (s *SSHService) SetProxyStore(store ProxyStore) {
+	s.proxies.mutex.Lock()
+	s.proxies.store = store
+	s.proxies.mutex.Unlock()
+}
+
+// SetDefaultProxy sets the proxy of hosts that do not pick one, empty connects directly
+func (s *SSHService) SetDefaultProxy(id string) {
+	s.proxies.mutex.Lock()
+	s.proxies.defaultProxy = id
+	s.proxies.mutex.Unlock()
+}
+
+// hostProxy returns the proxy a host connects through, nil for a direct connection
+func (s *SSHService) hostProxy(host *models.Host) (*models.Proxy, error) {
+	s.proxies.mutex.RLock()
+	store, id := s.proxies.store, host.ProxyID
+	if id == "" {
+		id = s.proxies.defaultProxy
+	}
+	s.proxies.mutex.RUnlock()
+
+	if id == "" || id == models.ProxyDirect {
+		return nil, nil
+	}
+	if store == nil {
+		return nil, errors.New("proxy store is not available")
+	}
+	proxy, err := store.GetProxy(id)
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		return nil, fmt.Errorf("proxy %s not found", id)
+	}
+	return proxy, nil
+}
+
+// dialHop opens the connection to the first hop of a chain, directly or through the
+// proxy the hop uses. Later hops are tunnelled through the previous one.
+func (s *SSHService) dialHop(host *models.Host, address string, timeout time.Duration) (net.Conn, error) {
+	proxy, err := s.hostProxy(host)
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil {
+		return net.DialTimeout("tcp", address, timeout)
+	}
+
+	log.Printf("SSH SERVICE - Dialing %s through %s proxy %s", address, proxy.Type, proxy.Name)
+	switch proxy.Type {
+	case models.ProxySOCKS5:
+		return dialSOCKS5(proxy, address, timeout)
+	case models.ProxyHTTP:
+		return dialHTTPConnect(proxy, address, timeout)
+	case models.ProxyCommand:
+		return dialProxyCommand(proxy, host, address)
+	default:
+		return nil, fmt.Errorf("unknown proxy type: %s", proxy.Type)
+	}
+}
+
+// dialSOCKS5 performs the client side of a SOCKS5 CONNECT, with username and password
+// authentication when the proxy has a username. The proxy resolves the hostname.
+func dialSOCKS5(proxy *models.Proxy, address string, timeout time.Duration) (net.Conn, error) {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %s", address)
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(proxy.Host, strconv.Itoa(proxy.Port)), timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SOCKS proxy: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := socks5Connect(conn, proxy, host, port); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func socks5Connect(conn net.Conn, proxy *models.Proxy, host string, port int) error {
+	greeting := []byte{5, 1, 0} // no authentication
+	if proxy.Username != "" {
+		greeting = []byte{5, 2, 0, 2} // or username and password
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	choice := make([]byte, 2)
+	if _, err := io.ReadFull(conn, choice); err != nil {
+		return fmt.Errorf("failed to read SOCKS greeting: %w", err)
+	}
+	if choice[0] != 5 {
+		return fmt.Errorf("unsupported SOCKS version %d", choice[0])
+	}
+
+	switch choice[1] {
+	case 0:
+	case 2:
+		if proxy.Username == "" {
+			return errors.New("SOCKS proxy requires a username and password")
+		}
+		if len(proxy.Username) > 255 || len(proxy.Password) > 255 {
+			return errors.New("SOCKS username and password must be at most 255 bytes")
+		}
+		auth := []byte{1, byte(len(proxy.Username))}
+		auth = append(auth, proxy.Username...)
+		auth = append(auth, byte(len(proxy.Password)))
+		auth = append(auth, proxy.Password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		status := make([]byte, 2)
+		if _, err := io.ReadFull(conn, status); err != nil {
+			return fmt.Errorf("failed to read SOCKS authentication: %w", err)
+		}
+		if status[1] != 0 {
+			return errors.New("SOCKS proxy rejected the username or password")
+		}
+	default:
+		return errors.New("SOCKS proxy offers no supported authentication method")
+	}
+
+	request := []byte{5, 1, 0} // CONNECT
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		request = append(append(request, 1), ip.To4()...)
+	} else if ip != nil {
+		request = append(append(request, 4), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("hostname %s is too long for SOCKS", host)
+		}
+		request = append(append(request, 3, byte(len(host))), host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("failed to read SOCKS reply: %w", err)
+	}
+	if reply[1] != 0 {
+		return fmt.Errorf("SOCKS proxy failed to connect to %s:%d: %s", host, port, socks5ReplyText(reply[1]))
+	}
+
+	// Skip the bound address
+	var skip int
+	switch reply[3] {
+	case 1:
+		skip = 4
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	case 4:
+		skip = 16
+	default:
+		return fmt.Errorf("unsupported SOCKS address type %d", reply[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip+2)); err != nil {
+		return fmt.Errorf("failed to read SOCKS reply: %w", err)
+	}
+	return nil
+}
+
+func socks5ReplyText(code byte) string {
+	switch code {
+	case 1:
+		return "general failure"
+	case 2:
+		return "connection not allowed by ruleset"
+	case 3:
+		return "network unreachable"
+	case 4:
+		return "host unreachable"
+	case 5:
+		return "connection refused"
+	case 6:
+		return "TTL expired"
+	case 7:
+		return "command not supported"
+	case 8:
+		return "address type not supported"
+	default:
+		return fmt.Sprintf("error %d", code)
+	}
+}
+
+// dialHTTPConnect opens a tunnel with an HTTP CONNECT request, with basic authentication
+// when the proxy has a username
+func dialHTTPConnect(proxy *models.Proxy, address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(proxy.Host, strconv.Itoa(proxy.Port)), timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to HTTP proxy: %w", err)
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	request := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", address, address)
+	if proxy.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.Username + ":" + proxy.Password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	request += "\r\n"
+	if _, err := io.WriteString(conn, request); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read HTTP proxy response: %w", err)
+	}
+	response.Body.Close()
+
+	switch {
+	case response.StatusCode == http.StatusProxyAuthRequired:
+		conn.Close()
+		return nil, errors.New("HTTP proxy requires authentication or rejected the credentials")
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		conn.Close()
+		return nil, fmt.Errorf("HTTP proxy failed to connect to %s: %s", address, response.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	// The server banner may already sit in the reader's buffer
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn reads through a bufio.Reader that consumed the start of the stream
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// dialProxyCommand starts a ProxyCommand and speaks SSH over its stdin and stdout
+func dialProxyCommand(proxy *models.Proxy, host *models.Host, address string) (net.Conn, error) {
+	command := expandProxyCommand(proxy.Command, host)
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = proxyCommandLog{}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start proxy command: %w", err)
+	}
+	log.Printf("SSH SERVICE - Started proxy command for %s: %s", host.Label, command)
+
+	return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout, address: address}, nil
+}
+
+// expandProxyCommand replaces %h, %p, %r and %% like OpenSSH
+func expandProxyCommand(command string, host *models.Host) string {
+	var result strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] != '%' || i+1 == len(command) {
+			result.WriteByte(command[i])
+			continue
+		}
+		i++
+		switch command[i] {
+		case 'h':
+			result.WriteString(host.Hostname)
+		case 'p':
+			result.WriteString(strconv.Itoa(host.Port))
+		case 'r':
+			result.WriteString(host.Username)
+		case '%':
+			result.WriteByte('%')
+		default:
+			result.WriteByte('%')
+			result.WriteByte(command[i])
+		}
+	}
+	return result.String()
+}
+
+// proxyCommandLog logs what a proxy command writes to stderr
+type proxyCommandLog struct{}
+
+func (proxyCommandLog) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\r\n"), "\n") {
+		log.Printf("SSH SERVICE - Proxy command: %s", strings.TrimRight(line, "\r"))
+	}
+	return len(p), nil
+}
+
+// commandConn is a net.Conn over the stdin and stdout of a proxy command
+type commandConn struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  io.ReadCloser
+	address string
+
+	closeOnce sync.Once
+}
+
+func (c *commandConn) Read(p []byte) (int, error) {
+	return c.stdout.Read(p)
+}
+
+func (c *commandConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// Close stops the proxy command, it gets no chance to outlive the connection
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.stdin.Close()
+		if c.cmd.Process != nil {
+			c.cmd.Process.Kill()
+		}
+		c.cmd.Wait()
+	})
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr {
+	return proxyCommandAddr("localhost:0")
+}
+
+// RemoteAddr is the target address, known_hosts checks need a host and port
+func (c *commandConn) RemoteAddr() net.Addr {
+	return proxyCommandAddr(c.address)
+}
+
+// Deadlines are not supported on pipes to a process
+func (c *commandConn) SetDeadline(t time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type proxyCommandAddr string
+
+func (a proxyCommandAddr) Network() string { return "proxycommand" }
+func (a proxyCommandAddr) String() string  { return string(a) }
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"termunator/internal/models"
+)
+
+// Session states reported through EventSessionState
+const (
+	StateConnected    = "connected"
+	StateDisconnected = "disconnected"
+	StateReconnecting = "reconnecting"
+	StateClosed       = "closed"
+)
+
+const (
+	keepAliveInterval    = 15 * time.Second
+	keepAliveTimeout     = 10 * time.Second
+	keepAliveMaxMissed   = 3
+	reconnectMaxAttempts = 10
+	reconnectBaseDelay   = time.Second
+	reconnectMaxDelay    = time.Minute
+)
+
+// SessionStateEvent is pushed to the frontend whenever a session changes state
+type SessionStateEvent struct {
+	SessionID string `json:"sessionId"`
+	State     string `json:"state"`
+	Attempt   int    `json:"attempt,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (s *SSHService) setState(session *SSHSession, state string, err error) {
+	s.setStateAttempt(session, state, 0, err)
+}
+
+func (s *SSHService) setStateAttempt(session *SSHSession, state string, attempt int, err error) {
+	session.connMutex.Lock()
+	session.State = state
+	session.connMutex.Unlock()
+
+	event := SessionStateEvent{
+		SessionID: session.ID,
+		State:     state,
+		Attempt:   attempt,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	emitEvent(s.ctx, EventSessionState, event)
+}
+
+// pingClient sends a keepalive request and waits at most timeout for any reply
+func pingClient(client *ssh.Client, timeout time.Duration) error {
+	if client == nil {
+		return errors.New("no connection")
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("no response within %s", timeout)
+	}
+}
+
+// keepAlive pings the transport of a session and closes it after too many missed replies,
+// which makes streamOutput notice the drop and start reconnecting
+func (s *SSHService) keepAlive(session *SSHSession, client *ssh.Client) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-session.done:
+			return
+		case <-ticker.C:
+		}
+
+		session.connMutex.RLock()
+		current := session.Client
+		session.connMutex.RUnlock()
+		if current != client {
+			// reconnected, the new client has its own keepalive
+			return
+		}
+
+		if err := pingClient(client, keepAliveTimeout); err != nil {
+			missed++
+			log.Printf("KEEPALIVE - Session %s missed keepalive %d/%d: %v", session.ID, missed, keepAliveMaxMissed, err)
+			if missed >= keepAliveMaxMissed {
+				s.pool.Discard(client)
+				return
+			}
+			continue
+		}
+		missed = 0
+	}
+}
+
+// reconnect redials the session's host with exponential backoff and reattaches a new
+// shell to the same session ID, keeping its output buffer and port forwards
+func (s *SSHService) reconnect(session *SSHSession) {
+	// The transport is dead, drop it from the pool so the redial gets a fresh one
+	session.connMutex.Lock()
+	if session.Session != nil {
+		session.Session.Close()
+	}
+	s.pool.Discard(session.Client)
+	session.connMutex.Unlock()
+
+	s.setState(session, StateDisconnected, errors.New("connection lost"))
+	if !session.Host.AutoReconnect {
+		session.output.write(session.ID, "\r\n\x1b[31m[connection lost]\x1b[0m\r\n")
+		session.output.close()
+		return
+	}
+
+	session.output.write(session.ID, "\r\n\x1b[33m[connection lost, reconnecting...]\x1b[0m\r\n")
+
+	delay := reconnectBaseDelay
+	var lastErr error
+	for attempt := 1; attempt <= reconnectMaxAttempts; attempt++ {
+		select {
+		case <-session.done:
+			return
+		default:
+		}
+
+		s.setStateAttempt(session, StateReconnecting, attempt, lastErr)
+		log.Printf("RECONNECT - Session %s attempt %d/%d", session.ID, attempt, reconnectMaxAttempts)
+
+		lastErr = s.attachShell(session)
+		if lastErr == nil {
+			session.output.write(session.ID, "\x1b[32m[reconnected]\x1b[0m\r\n")
+			s.setState(session, StateConnected, nil)
+			log.Printf("RECONNECT - Session %s reconnected", session.ID)
+			return
+		}
+		log.Printf("RECONNECT - Session %s attempt %d failed: %v", session.ID, attempt, lastErr)
+
+		// A changed or unknown host key needs the user, retrying will not help
+		var hostKeyErr HostKeyVerificationNeeded
+		var mismatchErr HostKeyMismatch
+		if errors.As(lastErr, &hostKeyErr) || errors.As(lastErr, &mismatchErr) {
+			break
+		}
+
+		select {
+		case <-session.done:
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > reconnectMaxDelay {
+			delay = reconnectMaxDelay
+		}
+	}
+
+	s.setState(session, StateDisconnected, lastErr)
+	session.output.write(session.ID, fmt.Sprintf("\x1b[31m[reconnect failed: %v]\x1b[0m\r\n", lastErr))
+	session.output.close()
+}
+
+// attachShell dials the host again and swaps the new shell into the session
+func (s *SSHService) attachShell(session *SSHSession) error {
+	client, err := s.pool.Acquire(session.Host)
+	if err != nil {
+		return err
+	}
+
+	session.connMutex.RLock()
+	cols, rows := session.cols, session.rows
+	session.connMutex.RUnlock()
+
+	shell, err := s.openShell(client, session.Host, cols, rows)
+	if err != nil {
+		s.pool.Release(client)
+		return err
+	}
+
+	session.connMutex.Lock()
+	if !session.IsActive {
+		session.connMutex.Unlock()
+		shell.session.Close()
+		s.pool.Release(client)
+		return errors.New("session closed")
+	}
+	session.Client = client
+	session.Session = shell.session
+	session.stdin = shell.stdin
+	session.stdout = shell.stdout
+	session.stderr = shell.stderr
+	session.connMutex.Unlock()
+
+	if command := session.Host.ResumeCommand; command != "" {
+		if _, err := shell.stdin.Write([]byte(command + "\n")); err != nil {
+			log.Printf("RECONNECT - Failed to send resume command for session %s: %v", session.ID, err)
+		}
+	}
+
+	s.restartForwards(session)
+
+	go s.streamOutput(session, shell.stdout)
+	go s.keepAlive(session, client)
+	return nil
+}
+
+// restartForwards moves the running forwards of a session onto its new client
+func (s *SSHService) restartForwards(session *SSHSession) {
+	session.forwardMutex.Lock()
+	var rules []models.PortForward
+	for _, forward := range session.forwards {
+		rules = append(rules, forward.rule)
+	}
+	session.forwardMutex.Unlock()
+
+	session.stopForwards()
+
+	for _, rule := range rules {
+		if _, err := s.StartForward(session.ID, rule); err != nil {
+			log.Printf("RECONNECT - Failed to restart port forward %s: %v", rule.ID, err)
+		}
+	}
+}
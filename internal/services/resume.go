@@ -0,0 +1,221 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+const (
+	// Copies are written next to the destination under this suffix and renamed when complete,
+	// an interrupted copy is continued from the partial file
+	partialSuffix = ".termunator-part"
+	// How much of the end of a partial file is compared with the source before resuming
+	resumeCheckSize = 64 << 10
+)
+
+// transferProgress follows a resumable copy
+type transferProgress interface {
+	// Started is called once the source size and the offset the copy continues from are known
+	Started(size, offset int64)
+	Add(n int64)
+}
+
+type nopProgress struct{}
+
+func (nopProgress) Started(size, offset int64) {}
+func (nopProgress) Add(n int64)                {}
+
+// resumeOffset returns how much of a partial copy can be kept. The partial file must not be
+// longer than the source and, when verify is set, the hash of its tail must match the same
+// range of the source. Otherwise the copy starts over.
+func resumeOffset(partial io.ReaderAt, partialSize int64, source io.ReaderAt, sourceSize int64, verify bool) int64 {
+	if partialSize <= 0 || partialSize > sourceSize {
+		return 0
+	}
+	if !verify {
+		return partialSize
+	}
+
+	length := int64(resumeCheckSize)
+	if partialSize < length {
+		length = partialSize
+	}
+	start := partialSize - length
+
+	partialHash, err := hashRange(partial, start, length)
+	if err != nil {
+		return 0
+	}
+	sourceHash, err := hashRange(source, start, length)
+	if err != nil {
+		return 0
+	}
+	if !bytes.Equal(partialHash, sourceHash) {
+		return 0
+	}
+	return partialSize
+}
+
+func hashRange(r io.ReaderAt, offset, length int64) ([]byte, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(r, offset, length)); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// downloadResumable copies a remote file to localPath through a partial file, continuing a
+// partial file left by an earlier attempt
+func downloadResumable(ctx context.Context, client *sftp.Client, remotePath, localPath string, verify bool, progress transferProgress) error {
+	remoteFile, err := client.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("failed to open remote file %s: %w", remotePath, err)
+	}
+	defer remoteFile.Close()
+
+	info, err := remoteFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat remote file %s: %w", remotePath, err)
+	}
+
+	partialPath := localPath + partialSuffix
+	localFile, err := os.OpenFile(partialPath, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return fmt.Errorf("failed to create local file %s: %w", partialPath, err)
+	}
+
+	var partialSize int64
+	if partialInfo, err := localFile.Stat(); err == nil {
+		partialSize = partialInfo.Size()
+	}
+	offset := resumeOffset(localFile, partialSize, remoteFile, info.Size(), verify)
+	if offset > 0 {
+		log.Printf("TRANSFER - Resuming download of %s at %d of %d bytes", remotePath, offset, info.Size())
+	}
+
+	if err := seekBoth(localFile, remoteFile, offset); err != nil {
+		localFile.Close()
+		return err
+	}
+	progress.Started(info.Size(), offset)
+
+	if err := copyWithProgress(ctx, localFile, remoteFile, progress); err != nil {
+		localFile.Close()
+		return err
+	}
+	if err := localFile.Close(); err != nil {
+		return fmt.Errorf("failed to close local file %s: %w", partialPath, err)
+	}
+
+	// Keep the mode and modification time of the source, some systems do not support either
+	if err := os.Chmod(partialPath, info.Mode().Perm()); err != nil {
+		log.Printf("TRANSFER - Failed to set the mode of %s: %v", localPath, err)
+	}
+	if err := os.Chtimes(partialPath, time.Now(), info.ModTime()); err != nil {
+		log.Printf("TRANSFER - Failed to set the modification time of %s: %v", localPath, err)
+	}
+
+	if err := os.Rename(partialPath, localPath); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", partialPath, err)
+	}
+	return nil
+}
+
+// uploadResumable copies a local file to remotePath through a partial file, continuing a
+// partial file left by an earlier attempt
+func uploadResumable(ctx context.Context, client *sftp.Client, localPath, remotePath string, verify bool, progress transferProgress) error {
+	localFile, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open local file %s: %w", localPath, err)
+	}
+	defer localFile.Close()
+
+	info, err := localFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat local file %s: %w", localPath, err)
+	}
+
+	partialPath := remotePath + partialSuffix
+	remoteFile, err := client.OpenFile(partialPath, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return fmt.Errorf("failed to create remote file %s: %w", partialPath, err)
+	}
+
+	var partialSize int64
+	if partialInfo, err := remoteFile.Stat(); err == nil {
+		partialSize = partialInfo.Size()
+	}
+	offset := resumeOffset(remoteFile, partialSize, localFile, info.Size(), verify)
+	if offset > 0 {
+		log.Printf("TRANSFER - Resuming upload of %s at %d of %d bytes", localPath, offset, info.Size())
+	}
+
+	if err := seekBoth(remoteFile, localFile, offset); err != nil {
+		remoteFile.Close()
+		return err
+	}
+	progress.Started(info.Size(), offset)
+
+	if err := copyWithProgress(ctx, remoteFile, localFile, progress); err != nil {
+		remoteFile.Close()
+		return err
+	}
+	if err := remoteFile.Close(); err != nil {
+		return fmt.Errorf("failed to close remote file %s: %w", partialPath, err)
+	}
+
+	// Keep the mode and modification time of the source, some servers refuse either. Windows
+	// only knows read-only files, its modes mean nothing on the server.
+	if runtime.GOOS != "windows" {
+		if err := client.Chmod(partialPath, info.Mode().Perm()); err != nil {
+			log.Printf("TRANSFER - Failed to set the mode of %s: %v", remotePath, err)
+		}
+	}
+	if err := client.Chtimes(partialPath, time.Now(), info.ModTime()); err != nil {
+		log.Printf("TRANSFER - Failed to set the modification time of %s: %v", remotePath, err)
+	}
+
+	return renameRemote(client, partialPath, remotePath)
+}
+
+// seekBoth drops what the destination holds past offset and moves both files to it
+func seekBoth(destination interface {
+	io.Seeker
+	Truncate(size int64) error
+}, source io.Seeker, offset int64) error {
+	if err := destination.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate partial file: %w", err)
+	}
+	if _, err := destination.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek partial file: %w", err)
+	}
+	if _, err := source.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek source file: %w", err)
+	}
+	return nil
+}
+
+// renameRemote replaces newPath atomically where the server supports posix-rename, plain SFTP
+// rename refuses to overwrite so the old file is removed first otherwise
+func renameRemote(client *sftp.Client, oldPath, newPath string) error {
+	if err := client.PosixRename(oldPath, newPath); err == nil {
+		return nil
+	}
+	if err := client.Remove(newPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to replace remote file %s: %w", newPath, err)
+	}
+	if err := client.Rename(oldPath, newPath); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", oldPath, err)
+	}
+	return nil
+}
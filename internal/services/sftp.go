@@ -1,9 +1,8 @@
 package services
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"os"
 	"path/filepath"
 	"sync"
 
@@ -39,9 +38,10 @@ func (s *SFTPService) UploadFileFromBytes(hostID, remotePath string, data []byte
 
 type SFTPClient struct {
 	HostID    string
-	SSHClient *ssh.Client
+	SSHClient *ssh.Client // Shared with terminal sessions through the connection pool
 	Client    *sftp.Client
 	IsActive  bool
+	pool      *ConnectionPool
 }
 
 func NewSFTPService() *SFTPService {
@@ -51,22 +51,15 @@ func NewSFTPService() *SFTPService {
 }
 
 func (s *SFTPService) Connect(host *models.Host, sshService *SSHService) (*SFTPClient, error) {
-	// Build SSH client config
-	config, err := sshService.BuildSSHConfig(host)
+	// Reuse the transport of an open terminal session to the host if there is one
+	sshClient, err := sshService.pool.Acquire(host)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build SSH config: %w", err)
-	}
-
-	// Connect to the host
-	address := fmt.Sprintf("%s:%d", host.Hostname, host.Port)
-	sshClient, err := ssh.Dial("tcp", address, config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+		return nil, err
 	}
 
 	sftpClient, err := sftp.NewClient(sshClient)
 	if err != nil {
-		sshClient.Close()
+		sshService.pool.Release(sshClient)
 		return nil, fmt.Errorf("failed to create SFTP client: %w", err)
 	}
 
@@ -75,12 +68,18 @@ func (s *SFTPService) Connect(host *models.Host, sshService *SSHService) (*SFTPC
 		SSHClient: sshClient,
 		Client:    sftpClient,
 		IsActive:  true,
+		pool:      sshService.pool,
 	}
 
 	s.mutex.Lock()
+	previous := s.clients[host.ID]
 	s.clients[host.ID] = client
 	s.mutex.Unlock()
 
+	if previous != nil {
+		previous.close()
+	}
+
 	return client, nil
 }
 
@@ -124,27 +123,8 @@ func (s *SFTPService) DownloadFile(hostID, remotePath, localPath string) error {
 		return fmt.Errorf("SFTP client not found or inactive for host %s", hostID)
 	}
 
-	// Open remote file
-	remoteFile, err := client.Client.Open(remotePath)
-	if err != nil {
-		return fmt.Errorf("failed to open remote file %s: %w", remotePath, err)
-	}
-	defer remoteFile.Close()
-
-	// Create local file
-	localFile, err := os.Create(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to create local file %s: %w", localPath, err)
-	}
-	defer localFile.Close()
-
-	// Copy data
-	_, err = io.Copy(localFile, remoteFile)
-	if err != nil {
-		return fmt.Errorf("failed to copy file data: %w", err)
-	}
-
-	return nil
+	// Continues a partial download left by an earlier attempt
+	return downloadResumable(context.Background(), client.Client, remotePath, localPath, true, nopProgress{})
 }
 
 func (s *SFTPService) UploadFile(hostID, localPath, remotePath string) error {
@@ -156,27 +136,8 @@ func (s *SFTPService) UploadFile(hostID, localPath, remotePath string) error {
 		return fmt.Errorf("SFTP client not found or inactive for host %s", hostID)
 	}
 
-	// Open local file
-	localFile, err := os.Open(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to open local file %s: %w", localPath, err)
-	}
-	defer localFile.Close()
-
-	// Create remote file
-	remoteFile, err := client.Client.Create(remotePath)
-	if err != nil {
-		return fmt.Errorf("failed to create remote file %s: %w", remotePath, err)
-	}
-	defer remoteFile.Close()
-
-	// Copy data
-	_, err = io.Copy(remoteFile, localFile)
-	if err != nil {
-		return fmt.Errorf("failed to copy file data: %w", err)
-	}
-
-	return nil
+	// Continues a partial upload left by an earlier attempt
+	return uploadResumable(context.Background(), client.Client, localPath, remotePath, true, nopProgress{})
 }
 
 // Creates a directory on the remote server
@@ -205,7 +166,7 @@ func (s *SFTPService) DeleteFile(hostID, path string) error {
 	return client.Client.Remove(path)
 }
 
-// Deletes a directory on the remote server
+// Deletes a directory and everything in it on the remote server
 func (s *SFTPService) DeleteDirectory(hostID, path string) error {
 	s.mutex.RLock()
 	client, exists := s.clients[hostID]
@@ -215,7 +176,7 @@ func (s *SFTPService) DeleteDirectory(hostID, path string) error {
 		return fmt.Errorf("SFTP client not found or inactive for host %s", hostID)
 	}
 
-	return client.Client.RemoveDirectory(path)
+	return removeRemoteTree(client.Client, path)
 }
 
 func (s *SFTPService) GetWorkingDirectory(hostID string) (string, error) {
@@ -262,18 +223,25 @@ func (s *SFTPService) CloseConnection(hostID string) error {
 		return fmt.Errorf("SFTP client not found for host %s", hostID)
 	}
 
-	client.IsActive = false
-	if client.Client != nil {
-		client.Client.Close()
-	}
-	if client.SSHClient != nil {
-		client.SSHClient.Close()
-	}
+	client.close()
 
 	delete(s.clients, hostID)
 	return nil
 }
 
+// close ends the SFTP subsystem and releases the shared transport
+func (c *SFTPClient) close() {
+	c.IsActive = false
+	if c.Client != nil {
+		c.Client.Close()
+	}
+	if c.pool != nil {
+		c.pool.Release(c.SSHClient)
+	} else if c.SSHClient != nil {
+		c.SSHClient.Close()
+	}
+}
+
 func (s *SFTPService) GetActiveClients() map[string]*SFTPClient {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
@@ -2,12 +2,11 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
-	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -35,10 +34,50 @@ func (e HostKeyVerificationNeeded) Error() string {
 	return fmt.Sprintf("host key verification needed for %s", e.HostKeyInfo.Hostname)
 }
 
+// KnownHostKey is a key known_hosts holds for a host, with the line it is on
+type KnownHostKey struct {
+	Line        int    `json:"line"`
+	Algorithm   string `json:"algorithm"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// HostKeyMismatch means the server presented a different key than the one known_hosts
+// has for it, which may be a man-in-the-middle attack. Accepting does not resolve it,
+// only replacing the stale keys does, and never in strict mode.
+type HostKeyMismatch struct {
+	HostKeyInfo HostKeyInfo
+	Known       []KnownHostKey
+	Strict      bool
+}
+
+func (e HostKeyMismatch) Error() string {
+	known := make([]string, 0, len(e.Known))
+	for _, key := range e.Known {
+		known = append(known, fmt.Sprintf("%s %s (line %d)", key.Algorithm, key.Fingerprint, key.Line))
+	}
+	return fmt.Sprintf("host key for %s has changed, possible man-in-the-middle attack: server sent %s %s, known_hosts has %s",
+		e.HostKeyInfo.Hostname, e.HostKeyInfo.Algorithm, e.HostKeyInfo.Fingerprint, strings.Join(known, ", "))
+}
+
 type SSHService struct {
 	sessions map[string]*SSHSession
 	mutex    sync.RWMutex
 	ctx      context.Context
+	pool     *ConnectionPool
+	prompts  *promptBroker
+	keys     *keyCache
+	known    *KnownHostsService
+	// stored keys and remembered passphrases, nil until the database is available
+	keyStore KeyStore
+	// trusted host CAs, nil until the database is available
+	hostCAs HostCAStore
+	// built-in agent, offered with the system agent for agent authentication
+	agent *KeyAgent
+	// X11 forwarding of the clients dialed for hosts that forward X11
+	x11      map[*ssh.Client]*x11Forwarding
+	x11Mutex sync.Mutex
+	// saved proxies and the default one, used to reach the first hop
+	proxies proxySettings
 }
 
 type SSHSession struct {
@@ -47,18 +86,42 @@ type SSHSession struct {
 	Client         *ssh.Client
 	Session        *ssh.Session
 	IsActive       bool
+	State          string // connected, disconnected, reconnecting or closed
 	stdin          io.WriteCloser
 	stdout         io.Reader
 	stderr         io.Reader
+	output         *outputBuffer
 	ctx            context.Context
 	Ping           int64     // last measured ping in ms
 	lastPingSentAt time.Time // last time a ping or command was sent
+	forwards       map[string]*activeForward
+	forwardMutex   sync.Mutex
+	cols, rows     int           // last known terminal size, reused when reconnecting
+	done           chan struct{} // closed when the session is closed by the user
+	connMutex      sync.RWMutex  // guards Client, Session, pipes and State across reconnects
 }
 
 func NewSSHService() *SSHService {
-	return &SSHService{
+	s := &SSHService{
 		sessions: make(map[string]*SSHSession),
-	}
+		prompts:  newPromptBroker(),
+		keys:     newKeyCache(),
+		known:    NewKnownHostsService(),
+		x11:      make(map[*ssh.Client]*x11Forwarding),
+	}
+	s.agent = newKeyAgent(s)
+	s.pool = NewConnectionPool(s.dialHost)
+	return s
+}
+
+// KnownHosts returns the known_hosts file used to verify host keys
+func (s *SSHService) KnownHosts() *KnownHostsService {
+	return s.known
+}
+
+// Connections lists the SSH transports currently shared between sessions and SFTP
+func (s *SSHService) Connections() []PooledConnectionInfo {
+	return s.pool.Connections()
 }
 
 func (s *SSHService) SetContext(ctx context.Context) {
@@ -74,34 +137,85 @@ func (s *SSHService) Connect(host *models.Host) (*SSHSession, error) {
 func (s *SSHService) ConnectWithDimensions(host *models.Host, cols, rows int) (*SSHSession, error) {
 	log.Printf("SSH SERVICE - ConnectWithDimensions called: host=%s@%s:%d, dims=%dx%d", host.Username, host.Hostname, host.Port, cols, rows)
 
-	// Build SSH client config
-	config, err := s.BuildSSHConfig(host)
-	if err != nil {
-		log.Printf("SSH SERVICE - Failed to build SSH config: %v", err)
-		return nil, fmt.Errorf("failed to build SSH config: %w", err)
-	}
-	log.Printf("SSH SERVICE - SSH config built successfully")
-
-	// Connect to the host
+	// Connect to the host through the pool, reusing an open transport if there is one
 	address := fmt.Sprintf("%s:%d", host.Hostname, host.Port)
-	log.Printf("SSH SERVICE - Attempting to dial %s", address)
-	client, err := ssh.Dial("tcp", address, config)
+	log.Printf("SSH SERVICE - Attempting to connect to %s (%d jump hosts)", address, len(host.JumpHosts))
+	client, err := s.pool.Acquire(host)
 	if err != nil {
 		log.Printf("SSH SERVICE - Failed to dial %s: %v", address, err)
-		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+		return nil, err
 	}
 	log.Printf("SSH SERVICE - Successfully connected to %s", address)
 
+	shell, err := s.openShell(client, host, cols, rows)
+	if err != nil {
+		s.pool.Release(client)
+		return nil, err
+	}
+
+	sshSession := &SSHSession{
+		ID:       fmt.Sprintf("session_%d", time.Now().UnixNano()),
+		Host:     host,
+		Client:   client,
+		Session:  shell.session,
+		IsActive: true,
+		State:    StateConnected,
+		stdin:    shell.stdin,
+		stdout:   shell.stdout,
+		stderr:   shell.stderr,
+		output:   newOutputBuffer(),
+		ctx:      s.ctx,
+		cols:     cols,
+		rows:     rows,
+		done:     make(chan struct{}),
+		forwards: make(map[string]*activeForward),
+	}
+
+	log.Printf("SSH SERVICE - Created session with ID: %s", sshSession.ID)
+
+	s.mutex.Lock()
+	s.sessions[sshSession.ID] = sshSession
+	s.mutex.Unlock()
+
+	go s.streamOutput(sshSession, shell.stdout)
+	go s.emitOutput(sshSession)
+	go s.keepAlive(sshSession, client)
+
+	log.Printf("SSH SERVICE - Connection successful, returning session %s", sshSession.ID)
+	return sshSession, nil
+}
+
+// shellChannel is an interactive shell with its pipes
+type shellChannel struct {
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  io.Reader
+	stderr  io.Reader
+}
+
+// openShell starts an interactive shell with a pty on an established client
+func (s *SSHService) openShell(client *ssh.Client, host *models.Host, cols, rows int) (*shellChannel, error) {
 	// Create a new session
 	log.Printf("SSH SERVICE - Creating new SSH session")
 	session, err := client.NewSession()
 	if err != nil {
 		log.Printf("SSH SERVICE - Failed to create session: %v", err)
-		client.Close()
 		return nil, fmt.Errorf("failed to create SSH session: %w", err)
 	}
 	log.Printf("SSH SERVICE - SSH session created successfully")
 
+	// The agent channels are served by the client, see dialHost
+	if host.ForwardAgent != models.ForwardAgentOff {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			log.Printf("SSH SERVICE - Server refused agent forwarding: %v", err)
+		}
+	}
+	if host.ForwardX11 {
+		if err := s.requestX11(client, session); err != nil {
+			log.Printf("SSH SERVICE - X11 forwarding unavailable: %v", err)
+		}
+	}
+
 	// Set up terminal modes
 	modes := ssh.TerminalModes{
 		ssh.ECHO:          1,
@@ -114,7 +228,6 @@ func (s *SSHService) ConnectWithDimensions(host *models.Host, cols, rows int) (*
 	if err := session.RequestPty("xterm-256color", cols, rows, modes); err != nil {
 		log.Printf("SSH SERVICE - Failed to request PTY: %v", err)
 		session.Close()
-		client.Close()
 		return nil, fmt.Errorf("failed to request pty: %w", err)
 	}
 	log.Printf("SSH SERVICE - PTY requested successfully")
@@ -125,7 +238,6 @@ func (s *SSHService) ConnectWithDimensions(host *models.Host, cols, rows int) (*
 	if err != nil {
 		log.Printf("SSH SERVICE - Failed to create stdin pipe: %v", err)
 		session.Close()
-		client.Close()
 		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
 	}
 
@@ -134,7 +246,6 @@ func (s *SSHService) ConnectWithDimensions(host *models.Host, cols, rows int) (*
 	if err != nil {
 		log.Printf("SSH SERVICE - Failed to create stdout pipe: %v", err)
 		session.Close()
-		client.Close()
 		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
 
@@ -143,7 +254,6 @@ func (s *SSHService) ConnectWithDimensions(host *models.Host, cols, rows int) (*
 	if err != nil {
 		log.Printf("SSH SERVICE - Failed to create stderr pipe: %v", err)
 		session.Close()
-		client.Close()
 		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
 
@@ -152,40 +262,26 @@ func (s *SSHService) ConnectWithDimensions(host *models.Host, cols, rows int) (*
 	if err := session.Shell(); err != nil {
 		log.Printf("SSH SERVICE - Failed to start shell: %v", err)
 		session.Close()
-		client.Close()
 		return nil, fmt.Errorf("failed to start shell: %w", err)
 	}
 	log.Printf("SSH SERVICE - Shell started successfully")
 
-	sshSession := &SSHSession{
-		ID:       fmt.Sprintf("session_%d", time.Now().UnixNano()),
-		Host:     host,
-		Client:   client,
-		Session:  session,
-		IsActive: true,
-		stdin:    stdin,
-		stdout:   stdout,
-		stderr:   stderr,
-		ctx:      s.ctx,
-	}
-
-	log.Printf("SSH SERVICE - Created session with ID: %s", sshSession.ID)
-
-	s.mutex.Lock()
-	s.sessions[sshSession.ID] = sshSession
-	s.mutex.Unlock()
-
-	go s.streamOutput(sshSession)
-
-	log.Printf("SSH SERVICE - Connection successful, returning session %s", sshSession.ID)
-	return sshSession, nil
+	return &shellChannel{
+		session: session,
+		stdin:   stdin,
+		stdout:  stdout,
+		stderr:  stderr,
+	}, nil
 }
 
-func (s *SSHService) BuildSSHConfig(host *models.Host) (*ssh.ClientConfig, error) {
-	config := &ssh.ClientConfig{
+// BuildSSHConfig returns the client config of host. release closes what its auth methods hold
+// open, like the system agent connection, and is called once the handshake is done.
+func (s *SSHService) BuildSSHConfig(host *models.Host) (config *ssh.ClientConfig, release func(), err error) {
+	release = func() {}
+	config = &ssh.ClientConfig{
 		User:            host.Username,
 		Timeout:         30 * time.Second,
-		HostKeyCallback: s.createHostKeyCallback(),
+		HostKeyCallback: s.createHostKeyCallback(host),
 	}
 
 	log.Printf("SSH SERVICE - Building SSH config for %s@%s with auth method: %s", host.Username, host.Hostname, host.AuthMethod)
@@ -193,7 +289,7 @@ func (s *SSHService) BuildSSHConfig(host *models.Host) (*ssh.ClientConfig, error
 	switch host.AuthMethod {
 	case models.AuthPassword:
 		if host.Password == "" {
-			return nil, fmt.Errorf("password is required for password authentication")
+			return nil, nil, fmt.Errorf("password is required for password authentication")
 		}
 		log.Printf("SSH SERVICE - Using password authentication")
 		config.Auth = []ssh.AuthMethod{
@@ -201,43 +297,64 @@ func (s *SSHService) BuildSSHConfig(host *models.Host) (*ssh.ClientConfig, error
 		}
 
 	case models.AuthPrivateKey:
-		if host.PrivateKey == "" {
-			return nil, fmt.Errorf("private key is required for key authentication")
-		}
-
 		log.Printf("SSH SERVICE - Using private key authentication")
-		// Try to parse the private key
-		signer, err := ssh.ParsePrivateKey([]byte(host.PrivateKey))
+		// Stored keys are resolved here, encrypted ones unlocked from the cache, the vault or a passphrase prompt
+		signers, err := s.privateKeySigners(host)
 		if err != nil {
-			// Check if it's an encrypted key that needs a passphrase
-			if _, ok := err.(*ssh.PassphraseMissingError); ok {
-				return nil, fmt.Errorf("private key is encrypted and requires a passphrase (not yet supported)")
-			}
-			return nil, fmt.Errorf("failed to parse private key (key may be invalid or corrupted): %w", err)
+			return nil, nil, err
 		}
 
 		config.Auth = []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
+			ssh.PublicKeys(signers...),
 		}
 
 	case models.AuthAgent:
 		log.Printf("SSH SERVICE - Using SSH agent authentication")
-		authMethods, err := s.getSSHAgentAuth()
+		// One method for the keys of both agents, the client tries each auth method only once
+		signers, closeAgent, err := s.agentSigners()
 		if err != nil {
-			return nil, fmt.Errorf("failed to get SSH agent auth: %w", err)
+			return nil, nil, fmt.Errorf("failed to get SSH agent auth: %w", err)
 		}
-		config.Auth = authMethods
+		release = closeAgent
+		config.Auth = []ssh.AuthMethod{ssh.PublicKeys(signers...)}
+
+	case models.AuthKeyboardInteractive:
+		log.Printf("SSH SERVICE - Using keyboard-interactive authentication")
 
 	default:
-		return nil, fmt.Errorf("unsupported authentication method: %s", host.AuthMethod)
+		return nil, nil, fmt.Errorf("unsupported authentication method: %s", host.AuthMethod)
 	}
 
+	// Servers with PAM 2FA ask for the OTP through keyboard-interactive, either alone
+	// or as a second step after the primary method succeeded partially
+	config.Auth = append(config.Auth, s.keyboardInteractive(host))
+
 	log.Printf("SSH SERVICE - SSH config built with %d auth methods", len(config.Auth))
-	return config, nil
+	return config, release, nil
 }
 
-func (s *SSHService) createHostKeyCallback() ssh.HostKeyCallback {
+func (s *SSHService) createHostKeyCallback(host *models.Host) ssh.HostKeyCallback {
 	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		// Host certificates signed by a trusted CA need no prompt, others fall back to their plain key
+		if cert, ok := key.(*ssh.Certificate); ok && cert.CertType == ssh.HostCert {
+			if s.checkHostCertificate(host, hostname, remote, cert) {
+				return nil
+			}
+
+			err := s.known.Check(hostname, remote, cert)
+			if err == nil {
+				log.Printf("SSH SERVICE - Host certificate of %s signed by a known_hosts @cert-authority", hostname)
+				return nil
+			}
+			var revokedErr *knownhosts.RevokedError
+			if errors.As(err, &revokedErr) {
+				return fmt.Errorf("host certificate authority for %s is marked @revoked in known_hosts", hostname)
+			}
+
+			log.Printf("SSH SERVICE - Host certificate of %s not trusted, checking its plain key", hostname)
+			key = cert.Key
+		}
+
 		// Get fingerprint, algorithm and marshaled public key
 		fingerprint := ssh.FingerprintSHA256(key)
 		algorithm := key.Type()
@@ -245,36 +362,18 @@ func (s *SSHService) createHostKeyCallback() ssh.HostKeyCallback {
 
 		log.Printf("SSH SERVICE - Host key received: %s %s for %s", algorithm, fingerprint, hostname)
 
-		// Try to load known_hosts file to check if host is known
-		homeDir, err := os.UserHomeDir()
-		var isKnownHost bool = false
-
+		err := s.known.Check(hostname, remote, key)
 		if err == nil {
-			knownHostsPath := filepath.Join(homeDir, ".ssh", "known_hosts")
-			log.Printf("SSH SERVICE - Checking known_hosts file: %s", knownHostsPath)
-
-			if callback, err := knownhosts.New(knownHostsPath); err == nil {
-				// Check if the host key is already known
-				err := callback(hostname, remote, key)
-				if err == nil {
-					// Host key is known and valid
-					log.Printf("SSH SERVICE - Host key is known and valid for %s", hostname)
-					return nil
-				}
-
-				// Check if it's a key mismatch or unknown host
-				if keyErr, ok := err.(*knownhosts.KeyError); ok {
-					log.Printf("SSH SERVICE - Host key mismatch for %s: %v", hostname, keyErr)
-					isKnownHost = true
-				} else {
-					log.Printf("SSH SERVICE - Unknown host or other error for %s: %v", hostname, err)
-				}
-				// If not a KeyError, it's probably an unknown host
-			} else {
-				log.Printf("SSH SERVICE - Failed to load known_hosts: %v", err)
-			}
-		} else {
-			log.Printf("SSH SERVICE - Failed to get home directory: %v", err)
+			// Host key is known and valid, or signed by a trusted @cert-authority
+			log.Printf("SSH SERVICE - Host key is known and valid for %s", hostname)
+			return nil
+		}
+
+		// A revoked key must never be offered for acceptance
+		var revokedErr *knownhosts.RevokedError
+		if errors.As(err, &revokedErr) {
+			log.Printf("SSH SERVICE - Host key for %s is revoked", hostname)
+			return fmt.Errorf("host key %s for %s is marked @revoked in known_hosts", fingerprint, hostname)
 		}
 
 		hostKeyInfo := HostKeyInfo{
@@ -282,27 +381,33 @@ func (s *SSHService) createHostKeyCallback() ssh.HostKeyCallback {
 			Algorithm:   algorithm,
 			Fingerprint: fingerprint,
 			PublicKey:   publicKey,
-			IsNewHost:   !isKnownHost,
+			IsNewHost:   true,
 		}
 
-		log.Printf("SSH SERVICE - Host key verification needed for %s (isNewHost: %v)", hostname, !isKnownHost)
-		return HostKeyVerificationNeeded{HostKeyInfo: hostKeyInfo}
-	}
-}
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			log.Printf("SSH SERVICE - Failed to check known_hosts for %s: %v", hostname, err)
+			return fmt.Errorf("failed to verify host key for %s: %w", hostname, err)
+		}
 
-func (s *SSHService) getSSHAgentAuth() ([]ssh.AuthMethod, error) {
-	socket := os.Getenv("SSH_AUTH_SOCK")
-	if socket == "" {
-		return nil, fmt.Errorf("SSH_AUTH_SOCK environment variable not set")
-	}
+		// Known keys that do not match are a changed key, never treated like an unknown host
+		if len(keyErr.Want) > 0 {
+			hostKeyInfo.IsNewHost = false
+			mismatch := HostKeyMismatch{HostKeyInfo: hostKeyInfo, Strict: s.known.Strict()}
+			for _, want := range keyErr.Want {
+				mismatch.Known = append(mismatch.Known, KnownHostKey{
+					Line:        want.Line,
+					Algorithm:   want.Key.Type(),
+					Fingerprint: ssh.FingerprintSHA256(want.Key),
+				})
+			}
+			log.Printf("SSH SERVICE - Host key mismatch for %s: %v", hostname, mismatch)
+			return mismatch
+		}
 
-	conn, err := net.Dial("unix", socket)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+		log.Printf("SSH SERVICE - Host key verification needed for unknown host %s", hostname)
+		return HostKeyVerificationNeeded{HostKeyInfo: hostKeyInfo}
 	}
-
-	agentClient := agent.NewClient(conn)
-	return []ssh.AuthMethod{ssh.PublicKeysCallback(agentClient.Signers)}, nil
 }
 
 func (s *SSHService) SendInput(sessionID, input string) error {
@@ -318,6 +423,13 @@ func (s *SSHService) SendInput(sessionID, input string) error {
 		return fmt.Errorf("session %s is not active", sessionID)
 	}
 
+	session.connMutex.RLock()
+	defer session.connMutex.RUnlock()
+
+	if session.State != StateConnected {
+		return fmt.Errorf("session %s is %s", sessionID, session.State)
+	}
+
 	// Track ping send time for interactive input
 	session.lastPingSentAt = time.Now()
 
@@ -338,9 +450,20 @@ func (s *SSHService) ResizeTerminal(sessionID string, width, height int) error {
 		return fmt.Errorf("session %s is not active", sessionID)
 	}
 
+	session.connMutex.Lock()
+	defer session.connMutex.Unlock()
+
+	session.cols, session.rows = width, height
+	if session.State != StateConnected {
+		// applied when the shell is reattached
+		return nil
+	}
+
 	return session.Session.WindowChange(height, width)
 }
 
+// ReadOutput returns all output the frontend has not acknowledged yet and acknowledges it.
+// Output is normally pushed through EventSessionOutput, this is a fallback for polling.
 func (s *SSHService) ReadOutput(sessionID string) (string, error) {
 
 	s.mutex.RLock()
@@ -357,11 +480,38 @@ func (s *SSHService) ReadOutput(sessionID string) (string, error) {
 		return "", fmt.Errorf("session %s is not active", sessionID)
 	}
 
-	output := s.getAndClearSessionBuffer(sessionID)
+	output := session.output.drain()
 
 	return output, nil
 }
 
+// AckOutput acknowledges every output chunk up to seq so more can be pushed
+func (s *SSHService) AckOutput(sessionID string, seq uint64) error {
+	s.mutex.RLock()
+	session, exists := s.sessions[sessionID]
+	s.mutex.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+
+	session.output.ack(seq)
+	return nil
+}
+
+// ReplayOutput returns the buffered output chunks newer than seq, e.g. after a frontend reload
+func (s *SSHService) ReplayOutput(sessionID string, seq uint64) ([]OutputChunk, error) {
+	s.mutex.RLock()
+	session, exists := s.sessions[sessionID]
+	s.mutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+
+	return session.output.since(seq), nil
+}
+
 func (s *SSHService) CheckSessionHealth(sessionID string) error {
 	s.mutex.RLock()
 	session, exists := s.sessions[sessionID]
@@ -375,11 +525,18 @@ func (s *SSHService) CheckSessionHealth(sessionID string) error {
 		return fmt.Errorf("session %s is not active", sessionID)
 	}
 
+	session.connMutex.RLock()
+	client, state := session.Client, session.State
+	session.connMutex.RUnlock()
+
+	if state != StateConnected {
+		return fmt.Errorf("session %s is %s", sessionID, state)
+	}
+
 	// Track ping send time for keepalive
 	session.lastPingSentAt = time.Now()
 
-	_, _, err := session.Client.SendRequest("ping", true, nil)
-	if err != nil {
+	if err := pingClient(client, keepAliveTimeout); err != nil {
 		return fmt.Errorf("ping failed for session %s: %w", sessionID, err)
 	}
 	return nil
@@ -395,12 +552,17 @@ func (s *SSHService) CloseSession(sessionID string) error {
 	}
 
 	session.IsActive = false
+	close(session.done)
+	session.output.close()
+	session.stopForwards()
+
+	session.connMutex.Lock()
+	session.State = StateClosed
 	if session.Session != nil {
 		session.Session.Close()
 	}
-	if session.Client != nil {
-		session.Client.Close()
-	}
+	s.pool.Release(session.Client)
+	session.connMutex.Unlock()
 
 	delete(s.sessions, sessionID)
 	return nil
@@ -420,17 +582,11 @@ func (s *SSHService) GetActiveSessions() map[string]*SSHSession {
 }
 
 func (s *SSHService) ExecuteCommand(host *models.Host, command string) (string, error) {
-	config, err := s.BuildSSHConfig(host)
+	client, err := s.pool.Acquire(host)
 	if err != nil {
-		return "", fmt.Errorf("failed to build SSH config: %w", err)
+		return "", err
 	}
-
-	address := fmt.Sprintf("%s:%d", host.Hostname, host.Port)
-	client, err := ssh.Dial("tcp", address, config)
-	if err != nil {
-		return "", fmt.Errorf("failed to connect to %s: %w", address, err)
-	}
-	defer client.Close()
+	defer s.pool.Release(client)
 
 	session, err := client.NewSession()
 	if err != nil {
@@ -446,49 +602,84 @@ func (s *SSHService) ExecuteCommand(host *models.Host, command string) (string,
 	return strings.TrimSpace(string(output)), nil
 }
 
-func (s *SSHService) streamOutput(session *SSHSession) {
+// streamOutput reads the remote shell output into the session buffer.
+// When the read fails while the transport is dead the session is reconnected.
+func (s *SSHService) streamOutput(session *SSHSession, stdout io.Reader) {
 	log.Printf("STREAM - Starting output streaming for session %s", session.ID)
 
 	buffer := make([]byte, 4096)
+	// start of a UTF-8 sequence split across reads, held back until the rest arrives
+	var partial []byte
 
 	for session.IsActive {
-		// Set a read timeout to make this non-blocking
-		if conn, ok := session.stdout.(interface{ SetReadDeadline(time.Time) error }); ok {
-			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
-		}
-
-		n, err := session.stdout.Read(buffer)
-		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				continue
-			}
-			if err == io.EOF {
-				log.Printf("STREAM - EOF reached for session %s", session.ID)
-				break
-			}
-			log.Printf("STREAM - Error reading from session %s: %v", session.ID, err)
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
+		n, err := stdout.Read(buffer)
 
 		if n > 0 {
-			output := string(buffer[:n])
-
 			// If a ping was sent, calculate round-trip time
 			if !session.lastPingSentAt.IsZero() {
 				session.Ping = time.Since(session.lastPingSentAt).Milliseconds()
 				session.lastPingSentAt = time.Time{}
 			}
 
-			// Store in session buffer for immediate retrieval
-			s.addToSessionBuffer(session.ID, output)
+			data := append(partial, buffer[:n]...)
+			complete := completeUTF8(data)
+			if complete > 0 {
+				session.output.write(session.ID, string(data[:complete]))
+			}
+			partial = append([]byte(nil), data[complete:]...)
 		}
 
-		// Small delay to prevent excessive CPU usage
-		time.Sleep(10 * time.Millisecond)
+		if err != nil {
+			if err == io.EOF {
+				log.Printf("STREAM - EOF reached for session %s", session.ID)
+			} else {
+				log.Printf("STREAM - Error reading from session %s: %v", session.ID, err)
+			}
+			break
+		}
+	}
+	if len(partial) > 0 {
+		session.output.write(session.ID, string(partial))
 	}
 
 	log.Printf("STREAM - Stopped streaming for session %s", session.ID)
+
+	if !session.IsActive {
+		// closed by the user
+		return
+	}
+
+	session.connMutex.RLock()
+	client := session.Client
+	session.connMutex.RUnlock()
+
+	// The shell exiting (e.g. "exit") leaves the transport alive, a network drop does not
+	if pingClient(client, keepAliveTimeout) == nil {
+		log.Printf("STREAM - Shell exited for session %s", session.ID)
+		s.setState(session, StateClosed, nil)
+		session.output.close()
+		return
+	}
+
+	s.reconnect(session)
+}
+
+// emitOutput pushes buffered output to the frontend while it keeps acknowledging
+func (s *SSHService) emitOutput(session *SSHSession) {
+	for {
+		if chunk := session.output.next(); chunk != nil {
+			emitEvent(s.ctx, EventSessionOutput, chunk)
+			continue
+		}
+		if session.output.isClosed() {
+			// Nothing is written anymore, the last output goes out without waiting for acks
+			for _, chunk := range session.output.rest() {
+				emitEvent(s.ctx, EventSessionOutput, chunk)
+			}
+			return
+		}
+		<-session.output.notify
+	}
 }
 
 func (s *SSHService) GetSessionPing(sessionID string) (int64, error) {
@@ -500,20 +691,3 @@ func (s *SSHService) GetSessionPing(sessionID string) (int64, error) {
 	}
 	return session.Ping, nil
 }
-
-var sessionBuffers = make(map[string]string)
-var bufferMutex sync.RWMutex
-
-func (s *SSHService) addToSessionBuffer(sessionID, output string) {
-	bufferMutex.Lock()
-	defer bufferMutex.Unlock()
-	sessionBuffers[sessionID] += output
-}
-
-func (s *SSHService) getAndClearSessionBuffer(sessionID string) string {
-	bufferMutex.Lock()
-	defer bufferMutex.Unlock()
-	output := sessionBuffers[sessionID]
-	sessionBuffers[sessionID] = ""
-	return output
-}
@@ -0,0 +1,645 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"termunator/internal/models"
+)
+
+const sshConfigMaxIncludeDepth = 16
+
+// SSHConfigEntry is a concrete host alias from an OpenSSH config file with its
+// effective settings, as shown in the import preview
+type SSHConfigEntry struct {
+	Alias         string   `json:"alias"`
+	HostName      string   `json:"hostname"`
+	Port          int      `json:"port"`
+	User          string   `json:"user"`
+	IdentityFiles []string `json:"identityFiles"`
+	ProxyJump     []string `json:"proxyJump"` // [user@]host[:port] in dial order
+	Source        string   `json:"source"`    // file that declared the alias
+	ForwardAgent  bool     `json:"forwardAgent"`
+	ForwardX11    bool     `json:"forwardX11"`
+	DuplicateOf   string   `json:"duplicateOf,omitempty"`
+	Warnings      []string `json:"warnings,omitempty"`
+}
+
+// SSHConfigPreview lists the aliases of a config with the warnings about the files themselves
+type SSHConfigPreview struct {
+	Entries  []SSHConfigEntry `json:"entries"`
+	Warnings []string         `json:"warnings"`
+}
+
+// SSHConfigImportResult reports the hosts created by an import and the aliases that failed
+type SSHConfigImportResult struct {
+	Imported []*models.Host    `json:"imported"`
+	Errors   map[string]string `json:"errors"`
+	Warnings []string          `json:"warnings"`
+}
+
+// SSHConfigHostStore saves the hosts and keys created by an import
+type SSHConfigHostStore interface {
+	GetHosts() ([]*models.Host, error)
+	CreateHost(req models.HostCreateRequest) (*models.Host, error)
+	FindOrCreatePrivateKey(name, keyData string) (string, error)
+}
+
+// sshConfigBlock is a Host or Match section with its options, the first value of an option wins
+type sshConfigBlock struct {
+	hostPatterns []string // nil for Match blocks
+	match        []string // Match criteria, nil for Host blocks
+	source       string
+	options      map[string][]string
+}
+
+type sshConfigParser struct {
+	blocks   []*sshConfigBlock
+	aliases  []string
+	sources  map[string]string
+	warnings []string
+	sshDir   string
+}
+
+// ParseSSHConfig reads an OpenSSH client config and returns every concrete host alias.
+// Wildcard Host blocks and statically resolvable Match blocks are applied to them,
+// Match blocks that need runtime information (exec, canonical, ...) are ignored with a warning.
+func ParseSSHConfig(configPath string) ([]SSHConfigEntry, []string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+	if configPath == "" {
+		configPath = filepath.Join(homeDir, ".ssh", "config")
+	}
+
+	p := &sshConfigParser{
+		sources: make(map[string]string),
+		sshDir:  filepath.Join(homeDir, ".ssh"),
+	}
+	// Options before the first Host line apply to every host
+	global := &sshConfigBlock{hostPatterns: []string{"*"}, source: configPath, options: make(map[string][]string)}
+	p.blocks = append(p.blocks, global)
+
+	if err := p.parseFile(expandHome(configPath), global, 0); err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]SSHConfigEntry, 0, len(p.aliases))
+	for _, alias := range p.aliases {
+		entries = append(entries, p.resolve(alias))
+	}
+	return entries, p.warnings, nil
+}
+
+func (p *sshConfigParser) parseFile(filename string, current *sshConfigBlock, depth int) error {
+	if depth > sshConfigMaxIncludeDepth {
+		return fmt.Errorf("too many nested includes at %s", filename)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		keyword, args := splitConfigLine(scanner.Text())
+		if keyword == "" {
+			continue
+		}
+
+		switch keyword {
+		case "host":
+			current = &sshConfigBlock{hostPatterns: args, source: filename, options: make(map[string][]string)}
+			p.blocks = append(p.blocks, current)
+			for _, pattern := range args {
+				if strings.ContainsAny(pattern, "*?!") {
+					continue
+				}
+				if _, seen := p.sources[pattern]; !seen {
+					p.aliases = append(p.aliases, pattern)
+					p.sources[pattern] = filename
+				}
+			}
+
+		case "match":
+			current = &sshConfigBlock{match: args, source: filename, options: make(map[string][]string)}
+			p.blocks = append(p.blocks, current)
+
+		case "include":
+			// Included lines belong to the block the Include appears in
+			for _, pattern := range args {
+				pattern = expandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(p.sshDir, pattern)
+				}
+				matches, err := filepath.Glob(pattern)
+				if err != nil {
+					return fmt.Errorf("%s:%d: invalid include pattern %q: %w", filename, lineNumber, pattern, err)
+				}
+				if len(matches) == 0 {
+					p.warnings = append(p.warnings, fmt.Sprintf("%s:%d: include %q matched no files", filename, lineNumber, pattern))
+				}
+				for _, match := range matches {
+					before := len(p.blocks)
+					if err := p.parseFile(match, current, depth+1); err != nil {
+						return err
+					}
+					// A Host or Match inside the included file ends our block for the rest of that file only
+					if len(p.blocks) != before {
+						current = &sshConfigBlock{hostPatterns: current.hostPatterns, match: current.match,
+							source: filename, options: make(map[string][]string)}
+						p.blocks = append(p.blocks, current)
+					}
+				}
+			}
+
+		case "identityfile":
+			current.options[keyword] = append(current.options[keyword], args...)
+
+		default:
+			if _, set := current.options[keyword]; !set {
+				current.options[keyword] = args
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	return nil
+}
+
+// resolve computes the effective options of an alias the way ssh -G would, as far as possible statically
+func (p *sshConfigParser) resolve(alias string) SSHConfigEntry {
+	entry := SSHConfigEntry{
+		Alias:  alias,
+		Source: p.sources[alias],
+	}
+	options := make(map[string][]string)
+	var identityFiles []string
+
+	for _, block := range p.blocks {
+		applies := false
+		if block.match != nil {
+			hostname := alias
+			if value, ok := options["hostname"]; ok && len(value) > 0 {
+				hostname = value[0]
+			}
+			username := ""
+			if value, ok := options["user"]; ok && len(value) > 0 {
+				username = value[0]
+			}
+			var resolvable bool
+			applies, resolvable = matchCriteria(block.match, alias, hostname, username)
+			if !resolvable {
+				entry.Warnings = appendUnique(entry.Warnings,
+					fmt.Sprintf("ignored \"Match %s\" in %s, it cannot be resolved without connecting", strings.Join(block.match, " "), block.source))
+				continue
+			}
+		} else {
+			applies = matchHostPatterns(block.hostPatterns, alias)
+		}
+		if !applies {
+			continue
+		}
+
+		for keyword, args := range block.options {
+			// IdentityFile accumulates, everything else keeps its first value
+			if keyword == "identityfile" {
+				identityFiles = append(identityFiles, args...)
+				continue
+			}
+			if _, set := options[keyword]; !set {
+				options[keyword] = args
+			}
+		}
+	}
+
+	entry.HostName = alias
+	if value := options["hostname"]; len(value) > 0 {
+		entry.HostName = strings.ReplaceAll(value[0], "%h", alias)
+	}
+
+	entry.Port = 22
+	if value := options["port"]; len(value) > 0 {
+		if port, err := strconv.Atoi(value[0]); err == nil && port > 0 && port < 65536 {
+			entry.Port = port
+		} else {
+			entry.Warnings = append(entry.Warnings, fmt.Sprintf("invalid port %q, using 22", value[0]))
+		}
+	}
+
+	if value := options["user"]; len(value) > 0 {
+		entry.User = value[0]
+	} else if current, err := user.Current(); err == nil {
+		entry.User = current.Username
+	}
+
+	for _, identityFile := range identityFiles {
+		if strings.EqualFold(identityFile, "none") {
+			continue
+		}
+		expanded := expandSSHTokens(identityFile, alias, entry.HostName, entry.User, entry.Port)
+		entry.IdentityFiles = appendUnique(entry.IdentityFiles, expandHome(expanded))
+	}
+
+	if value := options["proxyjump"]; len(value) > 0 && !strings.EqualFold(value[0], "none") {
+		for _, hop := range strings.Split(strings.Join(value, ","), ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				entry.ProxyJump = append(entry.ProxyJump, strings.TrimPrefix(hop, "ssh://"))
+			}
+		}
+	}
+	if value := options["forwardagent"]; len(value) > 0 {
+		switch strings.ToLower(value[0]) {
+		case "yes":
+			entry.ForwardAgent = true
+		case "no":
+		default:
+			entry.Warnings = append(entry.Warnings, fmt.Sprintf("ForwardAgent %s is not imported, only yes uses the system agent", value[0]))
+		}
+	}
+	if value := options["forwardx11"]; len(value) > 0 {
+		entry.ForwardX11 = strings.EqualFold(value[0], "yes")
+	}
+	if value := options["proxycommand"]; len(value) > 0 && !strings.EqualFold(value[0], "none") {
+		entry.Warnings = append(entry.Warnings, "ProxyCommand is not imported")
+	}
+
+	return entry
+}
+
+// matchCriteria evaluates Match criteria, resolvable is false if a criterion needs runtime state
+func matchCriteria(criteria []string, alias, hostname, username string) (matches bool, resolvable bool) {
+	matches = true
+	for i := 0; i < len(criteria); i++ {
+		criterion := strings.ToLower(criteria[i])
+		negate := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+
+		var result bool
+		switch criterion {
+		case "all":
+			result = true
+		case "host", "originalhost", "user", "localuser":
+			if i+1 >= len(criteria) {
+				return false, false
+			}
+			i++
+			patterns := strings.Split(criteria[i], ",")
+			switch criterion {
+			case "host":
+				result = matchHostPatterns(patterns, hostname)
+			case "originalhost":
+				result = matchHostPatterns(patterns, alias)
+			case "user":
+				result = matchHostPatterns(patterns, username)
+			case "localuser":
+				current, err := user.Current()
+				if err != nil {
+					return false, false
+				}
+				result = matchHostPatterns(patterns, current.Username)
+			}
+		default:
+			// exec, canonical, final, localnetwork, tagged, ...
+			return false, false
+		}
+
+		if negate {
+			result = !result
+		}
+		if !result {
+			matches = false
+		}
+	}
+	return matches, true
+}
+
+// matchHostPatterns implements ssh_config pattern lists: any positive match and no negated match
+func matchHostPatterns(patterns []string, value string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		for _, single := range strings.Split(pattern, ",") {
+			negate := strings.HasPrefix(single, "!")
+			single = strings.TrimPrefix(single, "!")
+			ok, err := path.Match(strings.ToLower(single), strings.ToLower(value))
+			if err != nil || !ok {
+				continue
+			}
+			if negate {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
+// splitConfigLine returns the lowercased keyword and arguments of a config line,
+// handling "Key=Value", quoted arguments and comments
+func splitConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	keyword := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	var args []string
+	var current strings.Builder
+	inQuotes, hasArg := false, false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		case r == '#' && !inQuotes && !hasArg:
+			return keyword, args
+		default:
+			current.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	return keyword, args
+}
+
+// expandSSHTokens replaces the percent tokens ssh accepts in IdentityFile
+func expandSSHTokens(value, alias, hostname, username string, port int) string {
+	homeDir, _ := os.UserHomeDir()
+	localUser := ""
+	if current, err := user.Current(); err == nil {
+		localUser = current.Username
+	}
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%d", homeDir,
+		"%h", hostname,
+		"%n", alias,
+		"%p", strconv.Itoa(port),
+		"%r", username,
+		"%u", localUser,
+	)
+	return replacer.Replace(value)
+}
+
+func expandHome(value string) string {
+	if value == "~" || strings.HasPrefix(value, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(homeDir, strings.TrimPrefix(value, "~"))
+		}
+	}
+	return value
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
+
+// PreviewSSHConfigImport parses an OpenSSH config, ~/.ssh/config if path is empty, and marks
+// the aliases that match a saved host
+func PreviewSSHConfigImport(store SSHConfigHostStore, configPath string) (*SSHConfigPreview, error) {
+	entries, warnings, err := ParseSSHConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := store.GetHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		if existing := findDuplicateHost(hosts, entries[i].Alias, entries[i].HostName, entries[i].Port, entries[i].User); existing != nil {
+			entries[i].DuplicateOf = existing.Label
+		}
+	}
+	if warnings == nil {
+		warnings = []string{}
+	}
+	return &SSHConfigPreview{Entries: entries, Warnings: warnings}, nil
+}
+
+// ImportSSHConfig creates hosts for the selected aliases. Identity files are added to the
+// key store and ProxyJump hops are linked to saved hosts, importing them first when needed.
+func ImportSSHConfig(store SSHConfigHostStore, configPath string, aliases []string) (*SSHConfigImportResult, error) {
+	entries, warnings, err := ParseSSHConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := store.GetHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	importer := &sshConfigImporter{
+		store:    store,
+		entries:  make(map[string]SSHConfigEntry),
+		existing: hosts,
+		imported: make(map[string]string),
+		result: &SSHConfigImportResult{
+			Imported: []*models.Host{},
+			Errors:   make(map[string]string),
+			Warnings: warnings,
+		},
+	}
+	for _, entry := range entries {
+		importer.entries[entry.Alias] = entry
+	}
+
+	for _, alias := range aliases {
+		if _, exists := importer.entries[alias]; !exists {
+			importer.result.Errors[alias] = "not found in config"
+			continue
+		}
+		if _, err := importer.importAlias(alias, true, nil); err != nil {
+			importer.result.Errors[alias] = err.Error()
+		}
+	}
+
+	log.Printf("SSH CONFIG - Imported %d hosts, %d failed", len(importer.result.Imported), len(importer.result.Errors))
+	return importer.result, nil
+}
+
+type sshConfigImporter struct {
+	store    SSHConfigHostStore
+	entries  map[string]SSHConfigEntry
+	existing []*models.Host
+	imported map[string]string // alias to created host ID
+	result   *SSHConfigImportResult
+}
+
+// importAlias creates the host of an alias and returns its ID. Aliases only needed as jump
+// hosts reuse a matching saved host instead of creating a duplicate.
+func (im *sshConfigImporter) importAlias(alias string, selected bool, visiting map[string]bool) (string, error) {
+	if id, done := im.imported[alias]; done {
+		return id, nil
+	}
+	if visiting[alias] {
+		return "", fmt.Errorf("ProxyJump loop through %s", alias)
+	}
+	if visiting == nil {
+		visiting = make(map[string]bool)
+	}
+	visiting[alias] = true
+	defer delete(visiting, alias)
+
+	entry := im.entries[alias]
+	if !selected {
+		if existing := findDuplicateHost(im.existing, entry.Alias, entry.HostName, entry.Port, entry.User); existing != nil {
+			return existing.ID, nil
+		}
+	}
+
+	req := models.HostCreateRequest{
+		Label:         entry.Alias,
+		Hostname:      entry.HostName,
+		Port:          entry.Port,
+		Username:      entry.User,
+		AuthMethod:    models.AuthAgent,
+		Tags:          []string{"ssh-config"},
+		AutoReconnect: true,
+		ForwardX11:    entry.ForwardX11,
+	}
+	if entry.ForwardAgent {
+		req.ForwardAgent = models.ForwardAgentSystem
+	}
+
+	for _, hop := range entry.ProxyJump {
+		jumpID, err := im.jumpHost(hop, visiting)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve jump host %s: %w", hop, err)
+		}
+		req.JumpHostIDs = append(req.JumpHostIDs, jumpID)
+	}
+
+	// The first identity file we can read and store becomes the host's key, otherwise the agent is used
+	for _, identityFile := range entry.IdentityFiles {
+		keyData, err := os.ReadFile(identityFile)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				im.result.Warnings = append(im.result.Warnings, fmt.Sprintf("%s: failed to read %s: %v", alias, identityFile, err))
+			}
+			continue
+		}
+		keyID, err := im.store.FindOrCreatePrivateKey(filepath.Base(identityFile), string(keyData))
+		if err != nil {
+			im.result.Warnings = append(im.result.Warnings, fmt.Sprintf("%s: failed to import %s: %v", alias, identityFile, err))
+			continue
+		}
+		req.AuthMethod = models.AuthPrivateKey
+		req.PrivateKeyID = keyID
+		break
+	}
+
+	host, err := im.store.CreateHost(req)
+	if err != nil {
+		return "", err
+	}
+	im.imported[alias] = host.ID
+	im.existing = append(im.existing, host)
+	im.result.Imported = append(im.result.Imported, host)
+	return host.ID, nil
+}
+
+// jumpHost resolves a [user@]host[:port] ProxyJump hop to a saved host ID
+func (im *sshConfigImporter) jumpHost(hop string, visiting map[string]bool) (string, error) {
+	username := ""
+	if at := strings.LastIndex(hop, "@"); at >= 0 {
+		username, hop = hop[:at], hop[at+1:]
+	}
+	port := 0
+	if host, portText, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+		port, _ = strconv.Atoi(portText)
+	}
+
+	if _, exists := im.entries[hop]; exists && username == "" && port == 0 {
+		return im.importAlias(hop, false, visiting)
+	}
+
+	hostname := hop
+	if entry, exists := im.entries[hop]; exists {
+		hostname = entry.HostName
+		if port == 0 {
+			port = entry.Port
+		}
+		if username == "" {
+			username = entry.User
+		}
+	}
+	if port == 0 {
+		port = 22
+	}
+
+	for _, host := range im.existing {
+		if host.Hostname == hostname && host.Port == port && (username == "" || host.Username == username) {
+			return host.ID, nil
+		}
+	}
+
+	if username == "" {
+		if current, err := user.Current(); err == nil {
+			username = current.Username
+		}
+	}
+	host, err := im.store.CreateHost(models.HostCreateRequest{
+		Label:         hop,
+		Hostname:      hostname,
+		Port:          port,
+		Username:      username,
+		AuthMethod:    models.AuthAgent,
+		Tags:          []string{"ssh-config"},
+		AutoReconnect: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	im.existing = append(im.existing, host)
+	im.result.Imported = append(im.result.Imported, host)
+	return host.ID, nil
+}
+
+// findDuplicateHost returns a saved host with the same label or the same address and user
+func findDuplicateHost(hosts []*models.Host, label, hostname string, port int, username string) *models.Host {
+	for _, host := range hosts {
+		if host.Label == label || (host.Hostname == hostname && host.Port == port && host.Username == username) {
+			return host
+		}
+	}
+	return nil
+}
@@ -0,0 +1,642 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"termunator/internal/models"
+)
+
+// SyncStore keeps sync profiles and what their last run found in sync
+type SyncStore interface {
+	GetSyncProfile(id string) (*models.SyncProfile, error)
+	GetSyncState(profileID string) ([]models.SyncFileState, error)
+	SaveSyncState(profileID string, files []models.SyncFileState) error
+	UpdateSyncProfileLastRun(id string, lastRun time.Time) error
+}
+
+// SyncService brings the local and remote directory of a sync profile in line. Both trees are
+// listed over an SFTP subsystem of the transfer manager, deletions are done right away and the
+// copies go through the transfer queue so they can be followed, paused and retried there.
+// Symlinks are left alone on both sides.
+type SyncService struct {
+	transfers *TransferManager
+	mutex     sync.Mutex
+	store     SyncStore
+	running   map[string]bool // profiles being compared or run
+}
+
+func NewSyncService(transfers *TransferManager) *SyncService {
+	return &SyncService{
+		transfers: transfers,
+		running:   make(map[string]bool),
+	}
+}
+
+func (s *SyncService) SetStore(store SyncStore) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.store = store
+}
+
+// ValidateSyncFilters checks the include and exclude globs of a profile
+func ValidateSyncFilters(include, exclude []string) error {
+	_, err := newSyncFilter(include, exclude)
+	return err
+}
+
+// Plan compares the trees of a profile without changing anything
+func (s *SyncService) Plan(profileID string) (*models.SyncPlan, error) {
+	profile, store, err := s.begin(profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.end(profileID)
+
+	client, closeClient, err := s.transfers.connect(profile.HostID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient()
+
+	plan, _, err := s.plan(client, store, profile)
+	if err != nil {
+		return nil, err
+	}
+	plan.DryRun = true
+	return plan, nil
+}
+
+// Run compares the trees of a profile, creates missing directories, deletes what the plan
+// deletes and queues the copies
+func (s *SyncService) Run(profileID string) (*models.SyncPlan, error) {
+	profile, store, err := s.begin(profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer s.end(profileID)
+
+	client, closeClient, err := s.transfers.connect(profile.HostID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient()
+
+	plan, inSync, err := s.plan(client, store, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	var uploads, downloads []models.TransferItem
+	var failed []error
+	created := make(map[string]bool)
+	mkdirRemote := func(dir string) {
+		if created["remote:"+dir] {
+			return
+		}
+		created["remote:"+dir] = true
+		if err := client.MkdirAll(dir); err != nil {
+			failed = append(failed, fmt.Errorf("failed to create remote directory %s: %w", dir, err))
+		}
+	}
+	mkdirLocal := func(dir string) {
+		if created["local:"+dir] {
+			return
+		}
+		created["local:"+dir] = true
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			failed = append(failed, fmt.Errorf("failed to create local directory %s: %w", dir, err))
+		}
+	}
+
+	// Entries are sorted by path, so directories are created before what goes into them
+	for _, entry := range plan.Entries {
+		localPath := filepath.Join(profile.LocalPath, filepath.FromSlash(entry.Path))
+		remotePath := path.Join(profile.RemotePath, entry.Path)
+		item := models.TransferItem{LocalPath: localPath, RemotePath: remotePath}
+
+		switch entry.Action {
+		case models.SyncActionUpload:
+			if entry.IsDir {
+				mkdirRemote(remotePath)
+				continue
+			}
+			mkdirRemote(path.Dir(remotePath))
+			uploads = append(uploads, item)
+		case models.SyncActionDownload:
+			if entry.IsDir {
+				mkdirLocal(localPath)
+				continue
+			}
+			mkdirLocal(filepath.Dir(localPath))
+			downloads = append(downloads, item)
+		}
+	}
+
+	// Deletions go deepest first, so directories are empty by the time they are removed
+	for i := len(plan.Entries) - 1; i >= 0; i-- {
+		entry := plan.Entries[i]
+		var err error
+		switch entry.Action {
+		case models.SyncActionDeleteRemote:
+			remotePath := path.Join(profile.RemotePath, entry.Path)
+			if entry.IsDir {
+				err = client.RemoveDirectory(remotePath)
+			} else {
+				err = client.Remove(remotePath)
+			}
+		case models.SyncActionDeleteLocal:
+			err = os.Remove(filepath.Join(profile.LocalPath, filepath.FromSlash(entry.Path)))
+		default:
+			continue
+		}
+		if err != nil {
+			failed = append(failed, fmt.Errorf("failed to delete %s: %w", entry.Path, err))
+		}
+	}
+
+	for direction, items := range map[models.TransferDirection][]models.TransferItem{
+		models.TransferUpload:   uploads,
+		models.TransferDownload: downloads,
+	} {
+		if len(items) == 0 {
+			continue
+		}
+		_, err := s.transfers.QueueBatch(models.TransferBatchRequest{
+			HostID:    profile.HostID,
+			Direction: direction,
+			Items:     items,
+			Conflict:  models.ConflictOverwrite,
+		})
+		if err != nil {
+			failed = append(failed, fmt.Errorf("failed to queue %ss: %w", direction, err))
+		}
+	}
+
+	// Files copied now are remembered once a later run finds them in sync
+	if err := store.SaveSyncState(profile.ID, inSync); err != nil {
+		log.Printf("SYNC - Failed to save state of %s: %v", profile.Name, err)
+	}
+	if err := store.UpdateSyncProfileLastRun(profile.ID, time.Now()); err != nil {
+		log.Printf("SYNC - Failed to save last run of %s: %v", profile.Name, err)
+	}
+
+	log.Printf("SYNC - Ran %s: %d uploads, %d downloads, %d deletions, %d in sync",
+		profile.Name, plan.Uploads, plan.Downloads, plan.Deletes, plan.InSync)
+	if len(failed) > 0 {
+		return plan, fmt.Errorf("failed to apply %d changes: %w", len(failed), errors.Join(failed...))
+	}
+	return plan, nil
+}
+
+// begin loads a profile and marks it busy, a profile is compared or run once at a time
+func (s *SyncService) begin(profileID string) (*models.SyncProfile, SyncStore, error) {
+	s.mutex.Lock()
+	store := s.store
+	if store == nil {
+		s.mutex.Unlock()
+		return nil, nil, errors.New("sync store is not available")
+	}
+	if s.running[profileID] {
+		s.mutex.Unlock()
+		return nil, nil, errors.New("sync profile is already running")
+	}
+	s.running[profileID] = true
+	s.mutex.Unlock()
+
+	profile, err := store.GetSyncProfile(profileID)
+	if err == nil && profile == nil {
+		err = errors.New("sync profile not found")
+	}
+	if err != nil {
+		s.end(profileID)
+		return nil, nil, err
+	}
+	return profile, store, nil
+}
+
+func (s *SyncService) end(profileID string) {
+	s.mutex.Lock()
+	delete(s.running, profileID)
+	s.mutex.Unlock()
+}
+
+// plan lists both trees and decides what to do with each difference. It also returns the
+// files that are in sync, which become the state of the next run.
+func (s *SyncService) plan(client *sftp.Client, store SyncStore, profile *models.SyncProfile) (*models.SyncPlan, []models.SyncFileState, error) {
+	filter, err := newSyncFilter(profile.Include, profile.Exclude)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	states, err := store.GetSyncState(profile.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	state := make(map[string]models.SyncFileState, len(states))
+	for _, file := range states {
+		state[file.Path] = file
+	}
+
+	local, err := listSyncTree(profile.LocalPath, profile.Direction != models.SyncDownload, len(states) > 0, filter,
+		func() (bool, error) { return exists(os.Stat(profile.LocalPath)) },
+		func(walk *treeWalk) error { return walk.walkLocal(profile.LocalPath, "") })
+	if err != nil {
+		return nil, nil, fmt.Errorf("local directory %s: %w", profile.LocalPath, err)
+	}
+	remote, err := listSyncTree(profile.RemotePath, profile.Direction != models.SyncUpload, len(states) > 0, filter,
+		func() (bool, error) { return exists(client.Stat(profile.RemotePath)) },
+		func(walk *treeWalk) error { return walk.walkRemote(client, profile.RemotePath, "") })
+	if err != nil {
+		return nil, nil, fmt.Errorf("remote directory %s: %w", profile.RemotePath, err)
+	}
+
+	paths := make([]string, 0, len(local)+len(remote))
+	for p := range local {
+		paths = append(paths, p)
+	}
+	for p := range remote {
+		if _, ok := local[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	plan := &models.SyncPlan{ProfileID: profile.ID, Entries: []models.SyncEntry{}}
+	var inSync []models.SyncFileState
+	actions := make(map[string]models.SyncAction)
+
+	// Files first, what happens to a directory that only one side has depends on its files
+	for _, p := range paths {
+		l, inLocal := local[p]
+		r, inRemote := remote[p]
+		entry := newSyncEntry(p, l, inLocal, r, inRemote)
+
+		if inLocal && inRemote && l.info.IsDir() != r.info.IsDir() {
+			entry.Change = models.SyncChanged
+			entry.Action = models.SyncActionNone
+			entry.Conflict = true
+			plan.Entries = append(plan.Entries, entry)
+			continue
+		}
+		if entry.IsDir {
+			continue
+		}
+
+		switch {
+		case inLocal && inRemote:
+			same, err := sameFile(client, profile.Compare, l, r)
+			if err != nil {
+				return nil, nil, err
+			}
+			if same {
+				plan.InSync++
+				inSync = append(inSync, models.SyncFileState{
+					Path:          p,
+					Size:          l.info.Size(),
+					LocalModTime:  l.info.ModTime(),
+					RemoteModTime: r.info.ModTime(),
+				})
+				continue
+			}
+			entry.Change = models.SyncChanged
+			switch profile.Direction {
+			case models.SyncUpload:
+				entry.Action = models.SyncActionUpload
+			case models.SyncDownload:
+				entry.Action = models.SyncActionDownload
+			default:
+				previous, known := state[p]
+				entry.Action, entry.Conflict = twoWayAction(l, r, previous, known)
+			}
+		case inLocal:
+			previous, known := state[p]
+			entry.Change, entry.Action = oneSidedAction(profile, true, known && unchanged(l, previous.Size, previous.LocalModTime))
+		default:
+			previous, known := state[p]
+			entry.Change, entry.Action = oneSidedAction(profile, false, known && unchanged(r, previous.Size, previous.RemoteModTime))
+		}
+		if entry.Action == "" {
+			continue
+		}
+		actions[p] = entry.Action
+		plan.Entries = append(plan.Entries, entry)
+	}
+
+	for _, p := range paths {
+		l, inLocal := local[p]
+		r, inRemote := remote[p]
+		if inLocal == inRemote || !(l.info != nil && l.info.IsDir() || r.info != nil && r.info.IsDir()) {
+			continue
+		}
+		entry := newSyncEntry(p, l, inLocal, r, inRemote)
+
+		// A two-way directory is gone on the other side if all its files were deleted there
+		deleted := profile.DeleteExtraneous
+		if profile.Direction == models.SyncTwoWay {
+			deleteAction := models.SyncActionDeleteRemote
+			if inLocal {
+				deleteAction = models.SyncActionDeleteLocal
+			}
+			files := 0
+			for file, action := range actions {
+				if strings.HasPrefix(file, p+"/") {
+					files++
+					deleted = deleted && action == deleteAction
+				}
+			}
+			deleted = deleted && files > 0
+		}
+		entry.Change, entry.Action = oneSidedAction(profile, inLocal, deleted)
+		if entry.Action == "" {
+			continue
+		}
+		plan.Entries = append(plan.Entries, entry)
+	}
+
+	sort.SliceStable(plan.Entries, func(i, j int) bool {
+		return plan.Entries[i].Path < plan.Entries[j].Path
+	})
+	for _, entry := range plan.Entries {
+		switch entry.Action {
+		case models.SyncActionUpload:
+			plan.Uploads++
+			plan.Bytes += entry.LocalSize
+		case models.SyncActionDownload:
+			plan.Downloads++
+			plan.Bytes += entry.RemoteSize
+		case models.SyncActionDeleteLocal, models.SyncActionDeleteRemote:
+			plan.Deletes++
+		}
+	}
+	return plan, inSync, nil
+}
+
+// listSyncTree walks one side of a profile into a map by relative path. A missing source
+// is an error, a missing destination is empty unless two-way sync knows files were there,
+// which would otherwise read as everything having been deleted.
+func listSyncTree(root string, source, known bool, filter *syncFilter, stat func() (bool, error), walkTree func(*treeWalk) error) (map[string]treeEntry, error) {
+	found, err := stat()
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		if source || known {
+			return nil, os.ErrNotExist
+		}
+		return map[string]treeEntry{}, nil
+	}
+
+	walk := newTreeWalk(context.Background(), models.SymlinksSkip)
+	if err := walkTree(walk); err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]treeEntry, len(walk.entries))
+	for _, entry := range walk.entries {
+		if filter.wants(entry.rel, entry.info.IsDir()) {
+			entries[entry.rel] = entry
+		}
+	}
+	return entries, nil
+}
+
+// exists turns the result of a stat into whether the path exists
+func exists(_ os.FileInfo, err error) (bool, error) {
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func newSyncEntry(p string, l treeEntry, inLocal bool, r treeEntry, inRemote bool) models.SyncEntry {
+	entry := models.SyncEntry{Path: p}
+	if inLocal {
+		entry.IsDir = l.info.IsDir()
+		entry.LocalSize = l.info.Size()
+		entry.LocalModTime = l.info.ModTime()
+	}
+	if inRemote {
+		entry.IsDir = entry.IsDir || r.info.IsDir()
+		entry.RemoteSize = r.info.Size()
+		entry.RemoteModTime = r.info.ModTime()
+	}
+	if entry.IsDir {
+		entry.LocalSize, entry.RemoteSize = 0, 0
+	}
+	return entry
+}
+
+// oneSidedAction decides about an entry only one side has. gone says the other side deleted
+// it, which one-way sync assumes for everything the source lacks.
+func oneSidedAction(profile *models.SyncProfile, inLocal, gone bool) (models.SyncChange, models.SyncAction) {
+	copyAction, deleteAction := models.SyncActionUpload, models.SyncActionDeleteLocal
+	if !inLocal {
+		copyAction, deleteAction = models.SyncActionDownload, models.SyncActionDeleteRemote
+	}
+
+	switch profile.Direction {
+	case models.SyncUpload, models.SyncDownload:
+		if (profile.Direction == models.SyncUpload) == inLocal {
+			return models.SyncNew, copyAction
+		}
+		if profile.DeleteExtraneous {
+			return models.SyncDeleted, deleteAction
+		}
+		return "", ""
+	}
+
+	if gone && profile.DeleteExtraneous {
+		return models.SyncDeleted, deleteAction
+	}
+	return models.SyncNew, copyAction
+}
+
+// twoWayAction copies a file from the side that changed it since the last run. When both
+// changed it, or it was never in sync, the newer file wins and the entry is a conflict.
+func twoWayAction(l, r treeEntry, previous models.SyncFileState, known bool) (models.SyncAction, bool) {
+	localChanged := !known || !unchanged(l, previous.Size, previous.LocalModTime)
+	remoteChanged := !known || !unchanged(r, previous.Size, previous.RemoteModTime)
+	switch {
+	case localChanged && !remoteChanged:
+		return models.SyncActionUpload, false
+	case remoteChanged && !localChanged:
+		return models.SyncActionDownload, false
+	}
+
+	if truncateTime(r.info.ModTime()).After(truncateTime(l.info.ModTime())) {
+		return models.SyncActionDownload, true
+	}
+	return models.SyncActionUpload, true
+}
+
+func unchanged(entry treeEntry, size int64, modTime time.Time) bool {
+	return entry.info.Size() == size && truncateTime(entry.info.ModTime()).Equal(truncateTime(modTime))
+}
+
+// truncateTime drops what SFTP does not carry
+func truncateTime(t time.Time) time.Time {
+	return t.Truncate(time.Second)
+}
+
+// sameFile compares a local and a remote file by size and modification time or by checksum
+func sameFile(client *sftp.Client, compare models.SyncCompare, l, r treeEntry) (bool, error) {
+	if l.info.Size() != r.info.Size() {
+		return false, nil
+	}
+	if compare != models.SyncCompareChecksum {
+		return truncateTime(l.info.ModTime()).Equal(truncateTime(r.info.ModTime())), nil
+	}
+
+	localFile, err := os.Open(l.source)
+	if err != nil {
+		return false, fmt.Errorf("failed to open local file %s: %w", l.source, err)
+	}
+	defer localFile.Close()
+	remoteFile, err := client.Open(r.source)
+	if err != nil {
+		return false, fmt.Errorf("failed to open remote file %s: %w", r.source, err)
+	}
+	defer remoteFile.Close()
+
+	localHash, err := hashRange(localFile, 0, l.info.Size())
+	if err != nil {
+		return false, fmt.Errorf("failed to hash local file %s: %w", l.source, err)
+	}
+	remoteHash, err := hashRange(remoteFile, 0, r.info.Size())
+	if err != nil {
+		return false, fmt.Errorf("failed to hash remote file %s: %w", r.source, err)
+	}
+	return bytes.Equal(localHash, remoteHash), nil
+}
+
+// syncGlob is an include or exclude pattern. Patterns with a slash match the path below the
+// roots, others match any name in it, so "node_modules" excludes the whole directory.
+type syncGlob struct {
+	re   *regexp.Regexp
+	path bool
+}
+
+type syncFilter struct {
+	include []syncGlob
+	exclude []syncGlob
+}
+
+func newSyncFilter(include, exclude []string) (*syncFilter, error) {
+	filter := &syncFilter{}
+	var err error
+	if filter.include, err = compileGlobs(include); err != nil {
+		return nil, err
+	}
+	if filter.exclude, err = compileGlobs(exclude); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+func compileGlobs(patterns []string) ([]syncGlob, error) {
+	var globs []syncGlob
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.TrimSpace(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+		glob := syncGlob{path: strings.Contains(pattern, "/")}
+		re, err := globRegexp(strings.TrimPrefix(pattern, "/"))
+		if err != nil {
+			return nil, err
+		}
+		glob.re = re
+		globs = append(globs, glob)
+	}
+	return globs, nil
+}
+
+// globRegexp translates a glob, "*" and "?" stop at slashes, "**" does not
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(pattern[i:], "**/"):
+				// Also matches no directory at all
+				expr.WriteString("(?:.*/)?")
+				i += 2
+			case strings.HasPrefix(pattern[i:], "**"):
+				expr.WriteString(".*")
+				i++
+			default:
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid pattern %q: missing ]", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return re, nil
+}
+
+// wants tells whether an entry takes part in the sync. With includes, directories are only
+// synced as the parents of included files.
+func (f *syncFilter) wants(rel string, isDir bool) bool {
+	if matchGlobs(f.exclude, rel) {
+		return false
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	return !isDir && matchGlobs(f.include, rel)
+}
+
+// matchGlobs matches a path and each of its parents
+func matchGlobs(globs []syncGlob, rel string) bool {
+	for p := rel; ; {
+		for _, glob := range globs {
+			subject := p
+			if !glob.path {
+				subject = path.Base(p)
+			}
+			if glob.re.MatchString(subject) {
+				return true
+			}
+		}
+		slash := strings.LastIndexByte(p, '/')
+		if slash < 0 {
+			return false
+		}
+		p = p[:slash]
+	}
+}
@@ -0,0 +1,802 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"termunator/internal/models"
+)
+
+const (
+	DefaultTransferConcurrency = 3
+	MaxTransferConcurrency     = 10
+
+	transferBufferSize       = 1 << 20 // large reads and writes are pipelined by the sftp client
+	transferProgressInterval = 250 * time.Millisecond
+	transferSaveInterval     = 5 * time.Second
+)
+
+// TransferStore keeps the transfer queue and resolves the hosts transfers connect to
+type TransferStore interface {
+	CreateTransfer(req models.TransferCreateRequest) (*models.Transfer, error)
+	UpdateTransfer(transfer *models.Transfer) error
+	GetTransfers() ([]*models.Transfer, error)
+	DeleteTransfer(id string) error
+	GetHost(id string) (*models.Host, error)
+	GetJumpHosts(host *models.Host) ([]*models.Host, error)
+}
+
+// TransferManager runs queued SFTP transfers, a limited number at a time per host, and
+// reports their progress to the frontend. Each transfer opens its own SFTP subsystem on
+// the pooled connection of its host.
+type TransferManager struct {
+	ssh         *SSHService
+	ctx         context.Context
+	store       TransferStore
+	concurrency int
+	verify      bool // compare the tail of partial files before resuming them
+	held        bool // the vault is locked, nothing starts without the host secrets
+
+	mutex sync.Mutex
+	jobs  map[string]*transferJob
+	order []string // queue order
+}
+
+type transferJob struct {
+	transfer models.Transfer
+	cancel   context.CancelFunc
+	// status a running transfer ends in when it is stopped on purpose
+	stopAs models.TransferStatus
+	done   atomic.Int64
+	offset int64 // where the running attempt resumed, guarded by the manager mutex
+
+	// A directory transfer is expanded into its children once its tree has been walked
+	expanded bool
+	children []string
+	dirs     []treeEntry // directories whose mode and times are set once all files are in
+}
+
+func NewTransferManager(sshService *SSHService) *TransferManager {
+	return &TransferManager{
+		ssh:         sshService,
+		concurrency: DefaultTransferConcurrency,
+		verify:      true,
+		held:        true,
+		jobs:        make(map[string]*transferJob),
+	}
+}
+
+func (m *TransferManager) SetContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+func (m *TransferManager) SetStore(store TransferStore) {
+	m.mutex.Lock()
+	m.store = store
+	m.mutex.Unlock()
+}
+
+// SetConcurrency sets how many transfers run at once per host
+func (m *TransferManager) SetConcurrency(concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > MaxTransferConcurrency {
+		concurrency = MaxTransferConcurrency
+	}
+
+	m.mutex.Lock()
+	m.concurrency = concurrency
+	m.mutex.Unlock()
+	m.schedule()
+}
+
+// SetVerifyResume sets whether the tail of a partial file is checked against the source
+// before a transfer continues it, otherwise only its size is checked
+func (m *TransferManager) SetVerifyResume(verify bool) {
+	m.mutex.Lock()
+	m.verify = verify
+	m.mutex.Unlock()
+}
+
+// SetHeld stops starting transfers while the vault is locked and starts the queue again once
+// it is unlocked. Transfers already running carry on.
+func (m *TransferManager) SetHeld(held bool) {
+	m.mutex.Lock()
+	m.held = held
+	m.mutex.Unlock()
+	if !held {
+		m.schedule()
+	}
+}
+
+// Restore loads the stored queue, transfers that were running when the app quit are queued again
+func (m *TransferManager) Restore() error {
+	m.mutex.Lock()
+	store := m.store
+	m.mutex.Unlock()
+	if store == nil {
+		return errors.New("transfer store is not available")
+	}
+
+	transfers, err := store.GetTransfers()
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	var restored []*transferJob
+	for _, transfer := range transfers {
+		if _, exists := m.jobs[transfer.ID]; exists {
+			continue
+		}
+		if transfer.Status == models.TransferRunning {
+			transfer.Status = models.TransferQueued
+			if err := store.UpdateTransfer(transfer); err != nil {
+				log.Printf("TRANSFER - Failed to requeue transfer %s: %v", transfer.ID, err)
+			}
+		}
+		job := &transferJob{transfer: *transfer}
+		m.jobs[transfer.ID] = job
+		m.order = append(m.order, transfer.ID)
+		restored = append(restored, job)
+	}
+
+	// Directory transfers with files were expanded before the restart
+	var parents []string
+	for _, job := range restored {
+		parent, exists := m.jobs[job.transfer.ParentID]
+		if job.transfer.ParentID == "" || !exists {
+			continue
+		}
+		if !parent.expanded {
+			parents = append(parents, parent.transfer.ID)
+		}
+		parent.expanded = true
+		parent.children = append(parent.children, job.transfer.ID)
+	}
+	m.mutex.Unlock()
+
+	for _, id := range parents {
+		m.updateParent(id, true)
+	}
+
+	log.Printf("TRANSFER - Restored %d transfers", len(restored))
+	m.schedule()
+	return nil
+}
+
+// Queue adds an upload or download to the queue
+func (m *TransferManager) Queue(req models.TransferCreateRequest) (*models.Transfer, error) {
+	m.mutex.Lock()
+	store := m.store
+	m.mutex.Unlock()
+	if store == nil {
+		return nil, errors.New("transfer store is not available")
+	}
+
+	transfer, err := store.CreateTransfer(req)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	m.jobs[transfer.ID] = &transferJob{transfer: *transfer}
+	m.order = append(m.order, transfer.ID)
+	m.mutex.Unlock()
+
+	log.Printf("TRANSFER - Queued %s of %s <-> %s on host %s", transfer.Direction, transfer.LocalPath, transfer.RemotePath, transfer.HostID)
+	emitEvent(m.ctx, EventTransferProgress, *transfer)
+	m.schedule()
+	return transfer, nil
+}
+
+// List returns all transfers in queue order
+func (m *TransferManager) List() []models.Transfer {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	result := make([]models.Transfer, 0, len(m.order))
+	for _, id := range m.order {
+		job := m.jobs[id]
+		result = append(result, job.snapshot())
+	}
+	return result
+}
+
+// Pause holds a queued transfer or stops a running one, Resume continues it from the partial file
+func (m *TransferManager) Pause(id string) error {
+	return m.stop(id, models.TransferPaused)
+}
+
+// Cancel stops a transfer for good and removes its partial file, Retry queues it again
+func (m *TransferManager) Cancel(id string) error {
+	return m.stop(id, models.TransferCanceled)
+}
+
+func (m *TransferManager) stop(id string, status models.TransferStatus) error {
+	m.mutex.Lock()
+	job, exists := m.jobs[id]
+	if !exists {
+		m.mutex.Unlock()
+		return fmt.Errorf("transfer %s not found", id)
+	}
+
+	// A directory transfer stops the files that are not done yet
+	if job.expanded {
+		var stopped []*transferJob
+		applied := false
+		for _, childID := range job.children {
+			child := m.jobs[childID]
+			changed, err := m.stopJob(child, status)
+			applied = applied || err == nil
+			if changed {
+				stopped = append(stopped, child)
+			}
+		}
+		current := job.transfer.Status
+		canceled := m.canceledTransfers(stopped, status)
+		m.mutex.Unlock()
+
+		for _, child := range stopped {
+			m.save(child)
+		}
+		m.removePartials(canceled)
+		if !applied {
+			return fmt.Errorf("transfer is already %s", current)
+		}
+		return nil
+	}
+
+	changed, err := m.stopJob(job, status)
+	var canceled []models.Transfer
+	if changed {
+		canceled = m.canceledTransfers([]*transferJob{job}, status)
+	}
+	m.mutex.Unlock()
+	if changed {
+		m.save(job)
+	}
+	m.removePartials(canceled)
+	return err
+}
+
+// canceledTransfers returns the transfers of jobs stopped without running when status is
+// canceled, a running job removes its partial file itself. It must be called with the mutex held.
+func (m *TransferManager) canceledTransfers(jobs []*transferJob, status models.TransferStatus) []models.Transfer {
+	if status != models.TransferCanceled {
+		return nil
+	}
+	transfers := make([]models.Transfer, 0, len(jobs))
+	for _, job := range jobs {
+		transfers = append(transfers, job.transfer)
+	}
+	return transfers
+}
+
+// removePartials deletes the partial files left by transfers that will not continue. Remote
+// ones are removed over one SFTP subsystem per host, failures are only logged.
+func (m *TransferManager) removePartials(transfers []models.Transfer) {
+	remote := make(map[string][]string)
+	for _, transfer := range transfers {
+		// A transfer that never started has no partial file
+		if transfer.IsDir || transfer.Attempts == 0 || transfer.Status == models.TransferCompleted || transfer.Status == models.TransferSkipped {
+			continue
+		}
+		if transfer.Direction == models.TransferDownload {
+			if err := os.Remove(transfer.LocalPath + partialSuffix); err != nil && !os.IsNotExist(err) {
+				log.Printf("TRANSFER - Failed to remove partial file of %s: %v", transfer.LocalPath, err)
+			}
+			continue
+		}
+		remote[transfer.HostID] = append(remote[transfer.HostID], transfer.RemotePath+partialSuffix)
+	}
+
+	for hostID, paths := range remote {
+		client, closeClient, err := m.connect(hostID)
+		if err != nil {
+			log.Printf("TRANSFER - Failed to remove %d partial uploads: %v", len(paths), err)
+			continue
+		}
+		for _, p := range paths {
+			if err := client.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("TRANSFER - Failed to remove partial file %s: %v", p, err)
+			}
+		}
+		closeClient()
+	}
+}
+
+// stopJob must be called with the mutex held, it tells whether the job needs saving
+func (m *TransferManager) stopJob(job *transferJob, status models.TransferStatus) (bool, error) {
+	switch job.transfer.Status {
+	case models.TransferRunning:
+		// run() stores the new status once the copy has stopped
+		job.stopAs = status
+		job.cancel()
+		return false, nil
+	case models.TransferQueued:
+	case models.TransferPaused:
+		if status == models.TransferPaused {
+			return false, nil
+		}
+	default:
+		return false, fmt.Errorf("transfer is already %s", job.transfer.Status)
+	}
+
+	job.transfer.Status = status
+	return true, nil
+}
+
+// Resume queues a paused transfer again
+func (m *TransferManager) Resume(id string) error {
+	return m.requeue(id, models.TransferPaused)
+}
+
+// Retry queues a failed or canceled transfer again
+func (m *TransferManager) Retry(id string) error {
+	return m.requeue(id, models.TransferFailed, models.TransferCanceled)
+}
+
+func (m *TransferManager) requeue(id string, from ...models.TransferStatus) error {
+	m.mutex.Lock()
+	job, exists := m.jobs[id]
+	if !exists {
+		m.mutex.Unlock()
+		return fmt.Errorf("transfer %s not found", id)
+	}
+
+	jobs := []*transferJob{job}
+	if job.expanded {
+		jobs = nil
+		for _, childID := range job.children {
+			jobs = append(jobs, m.jobs[childID])
+		}
+	}
+
+	var requeued []*transferJob
+	var err error
+	for _, candidate := range jobs {
+		allowed := false
+		for _, status := range from {
+			allowed = allowed || candidate.transfer.Status == status
+		}
+		if !allowed {
+			err = fmt.Errorf("transfer is %s", candidate.transfer.Status)
+			continue
+		}
+		candidate.transfer.Status = models.TransferQueued
+		candidate.transfer.Error = ""
+		requeued = append(requeued, candidate)
+	}
+	m.mutex.Unlock()
+
+	for _, candidate := range requeued {
+		m.save(candidate)
+	}
+	m.schedule()
+	// Files of a directory transfer that were not stopped are fine as they are
+	if len(requeued) > 0 {
+		return nil
+	}
+	return err
+}
+
+// Remove drops a transfer that is not running from the queue
+func (m *TransferManager) Remove(id string) error {
+	m.mutex.Lock()
+	job, exists := m.jobs[id]
+	if !exists {
+		m.mutex.Unlock()
+		return fmt.Errorf("transfer %s not found", id)
+	}
+	running := job.transfer.Status == models.TransferRunning
+	for _, childID := range job.children {
+		running = running || m.jobs[childID].transfer.Status == models.TransferRunning
+	}
+	if running {
+		m.mutex.Unlock()
+		return errors.New("cancel the transfer before removing it")
+	}
+	if job.transfer.ParentID != "" {
+		m.mutex.Unlock()
+		return errors.New("files are removed with their directory transfer")
+	}
+	// Canceling removed the partial files already
+	var unfinished []models.Transfer
+	for _, removedID := range append([]string{id}, job.children...) {
+		if transfer := m.jobs[removedID].transfer; transfer.Status != models.TransferCanceled {
+			unfinished = append(unfinished, transfer)
+		}
+	}
+	removed := m.forget(id)
+	store := m.store
+	m.mutex.Unlock()
+
+	m.removePartials(unfinished)
+	for _, removedID := range removed {
+		if err := store.DeleteTransfer(removedID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClearFinished removes completed, canceled and skipped transfers and returns how many were removed
+func (m *TransferManager) ClearFinished() (int, error) {
+	m.mutex.Lock()
+	var finished []string
+	for _, id := range m.order {
+		transfer := m.jobs[id].transfer
+		if transfer.ParentID != "" {
+			continue
+		}
+		switch transfer.Status {
+		case models.TransferCompleted, models.TransferCanceled, models.TransferSkipped:
+			finished = append(finished, id)
+		}
+	}
+	var removed []string
+	for _, id := range finished {
+		removed = append(removed, m.forget(id)...)
+	}
+	store := m.store
+	m.mutex.Unlock()
+
+	for _, id := range removed {
+		if err := store.DeleteTransfer(id); err != nil {
+			return 0, err
+		}
+	}
+	return len(finished), nil
+}
+
+// forget drops a transfer and the files of a directory transfer, it must be called with the
+// mutex held and returns the IDs of the dropped transfers
+func (m *TransferManager) forget(id string) []string {
+	removed := []string{id}
+	if job, exists := m.jobs[id]; exists {
+		removed = append(removed, job.children...)
+	}
+
+	drop := make(map[string]bool, len(removed))
+	for _, removedID := range removed {
+		drop[removedID] = true
+		delete(m.jobs, removedID)
+	}
+	order := m.order[:0]
+	for _, queued := range m.order {
+		if !drop[queued] {
+			order = append(order, queued)
+		}
+	}
+	m.order = order
+	return removed
+}
+
+// schedule starts queued transfers in order while their host has a free slot
+func (m *TransferManager) schedule() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.store == nil || m.held {
+		return
+	}
+
+	// Directory transfers do not take a slot, only their files do
+	running := make(map[string]int)
+	for _, job := range m.jobs {
+		if job.transfer.Status == models.TransferRunning && !job.transfer.IsDir {
+			running[job.transfer.HostID]++
+		}
+	}
+
+	for _, id := range m.order {
+		job := m.jobs[id]
+		if job.transfer.Status != models.TransferQueued || job.expanded {
+			continue
+		}
+		if job.transfer.IsDir {
+			ctx, cancel := context.WithCancel(context.Background())
+			job.cancel = cancel
+			job.stopAs = ""
+			job.transfer.Status = models.TransferRunning
+			job.transfer.Attempts++
+			job.transfer.Error = ""
+			go m.expand(ctx, job)
+			continue
+		}
+		if running[job.transfer.HostID] >= m.concurrency {
+			continue
+		}
+		running[job.transfer.HostID]++
+
+		ctx, cancel := context.WithCancel(context.Background())
+		job.cancel = cancel
+		job.stopAs = ""
+		job.offset = 0
+		job.done.Store(0)
+		job.transfer.Status = models.TransferRunning
+		job.transfer.Attempts++
+		job.transfer.Transferred = 0
+		job.transfer.Error = ""
+		go m.run(ctx, job)
+	}
+}
+
+// run performs a transfer and stores how it ended
+func (m *TransferManager) run(ctx context.Context, job *transferJob) {
+	m.save(job)
+
+	m.mutex.Lock()
+	transfer := job.transfer
+	m.mutex.Unlock()
+
+	stopProgress := make(chan struct{})
+	progressDone := make(chan struct{})
+	go func() {
+		m.reportProgress(job, stopProgress)
+		close(progressDone)
+	}()
+
+	err := m.transfer(ctx, job, transfer)
+
+	close(stopProgress)
+	<-progressDone
+
+	m.mutex.Lock()
+	job.cancel()
+	job.transfer.Transferred = job.done.Load()
+	job.transfer.BytesPerSecond = 0
+	job.transfer.ETASeconds = -1
+	switch {
+	case err == nil:
+		job.transfer.Status = models.TransferCompleted
+		log.Printf("TRANSFER - Completed %s of %s (%d bytes)", transfer.Direction, transfer.RemotePath, job.transfer.Transferred)
+	case errors.Is(err, errSkipped):
+		job.transfer.Status = models.TransferSkipped
+		log.Printf("TRANSFER - Skipped %s of %s, the destination exists", transfer.Direction, transfer.RemotePath)
+	case job.stopAs != "":
+		job.transfer.Status = job.stopAs
+		log.Printf("TRANSFER - Stopped %s of %s, now %s", transfer.Direction, transfer.RemotePath, job.stopAs)
+	case m.held:
+		// The vault locked before the host was read, the transfer starts over once it is unlocked
+		job.transfer.Status = models.TransferQueued
+		log.Printf("TRANSFER - Vault locked, %s of %s stays queued: %v", transfer.Direction, transfer.RemotePath, err)
+	default:
+		job.transfer.Status = models.TransferFailed
+		job.transfer.Error = err.Error()
+		log.Printf("TRANSFER - Failed %s of %s: %v", transfer.Direction, transfer.RemotePath, err)
+	}
+	m.mutex.Unlock()
+
+	m.save(job)
+	m.schedule()
+}
+
+// connect opens a fresh SFTP subsystem on the pooled connection of a host, close releases both
+func (m *TransferManager) connect(hostID string) (client *sftp.Client, close func(), err error) {
+	host, err := m.store.GetHost(hostID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get host: %w", err)
+	}
+	if host == nil {
+		return nil, nil, errors.New("host not found")
+	}
+	if host.JumpHosts, err = m.store.GetJumpHosts(host); err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve jump hosts: %w", err)
+	}
+
+	sshClient, err := m.ssh.pool.Acquire(host)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err = sftp.NewClient(sshClient)
+	if err != nil {
+		m.ssh.pool.Release(sshClient)
+		return nil, nil, fmt.Errorf("failed to create SFTP client: %w", err)
+	}
+
+	return client, func() {
+		client.Close()
+		m.ssh.pool.Release(sshClient)
+	}, nil
+}
+
+// transfer copies the file over a fresh SFTP subsystem on the host's pooled connection
+func (m *TransferManager) transfer(ctx context.Context, job *transferJob, transfer models.Transfer) error {
+	client, closeClient, err := m.connect(transfer.HostID)
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	// Every attempt looks at the destination again, it may have changed in the meantime
+	if err := m.resolveConflict(client, job, &transfer); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	verify := m.verify
+	m.mutex.Unlock()
+	progress := jobProgress{manager: m, job: job}
+
+	switch transfer.Direction {
+	case models.TransferUpload:
+		err = uploadResumable(ctx, client, transfer.LocalPath, transfer.RemotePath, verify, progress)
+		if err != nil && m.canceled(job) {
+			client.Remove(transfer.RemotePath + partialSuffix)
+		}
+	case models.TransferDownload:
+		err = downloadResumable(ctx, client, transfer.RemotePath, transfer.LocalPath, verify, progress)
+		if err != nil && m.canceled(job) {
+			os.Remove(transfer.LocalPath + partialSuffix)
+		}
+	default:
+		err = fmt.Errorf("unknown transfer direction: %s", transfer.Direction)
+	}
+	return err
+}
+
+// resolveConflict applies the conflict policy of a job and stores where keep both moved it
+func (m *TransferManager) resolveConflict(client *sftp.Client, job *transferJob, transfer *models.Transfer) error {
+	resolved := *transfer
+	if err := resolveConflict(client, &resolved); err != nil {
+		return err
+	}
+	if resolved.LocalPath == transfer.LocalPath && resolved.RemotePath == transfer.RemotePath {
+		return nil
+	}
+
+	_, existing, _ := transferEnds(*transfer)
+	_, destination, _ := transferEnds(resolved)
+	log.Printf("TRANSFER - %s exists, keeping both as %s", existing, destination)
+	transfer.LocalPath, transfer.RemotePath = resolved.LocalPath, resolved.RemotePath
+	m.mutex.Lock()
+	job.transfer.LocalPath, job.transfer.RemotePath = resolved.LocalPath, resolved.RemotePath
+	m.mutex.Unlock()
+	m.save(job)
+	return nil
+}
+
+func (m *TransferManager) canceled(job *transferJob) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return job.stopAs == models.TransferCanceled
+}
+
+// jobProgress feeds a resumable copy into a transfer
+type jobProgress struct {
+	manager *TransferManager
+	job     *transferJob
+}
+
+func (p jobProgress) Started(size, offset int64) {
+	p.manager.mutex.Lock()
+	p.job.transfer.Size = size
+	p.job.offset = offset
+	p.job.done.Store(offset)
+	p.manager.mutex.Unlock()
+}
+
+func (p jobProgress) Add(n int64) {
+	p.job.done.Add(n)
+}
+
+// copyWithProgress copies until src is drained or ctx is canceled, counting written bytes
+func copyWithProgress(ctx context.Context, dst io.Writer, src io.Reader, progress transferProgress) error {
+	buffer := make([]byte, transferBufferSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, readErr := src.Read(buffer)
+		if n > 0 {
+			written, err := dst.Write(buffer[:n])
+			progress.Add(int64(written))
+			if err != nil {
+				return fmt.Errorf("failed to write: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return fmt.Errorf("failed to read: %w", readErr)
+		}
+	}
+}
+
+// reportProgress emits the progress, throughput and ETA of a running transfer until stop is closed
+func (m *TransferManager) reportProgress(job *transferJob, stop <-chan struct{}) {
+	ticker := time.NewTicker(transferProgressInterval)
+	defer ticker.Stop()
+
+	var rate float64
+	var last, lastOffset int64
+	lastTime := time.Now()
+	lastSave := lastTime
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			m.mutex.Lock()
+			done, offset := job.done.Load(), job.offset
+			m.mutex.Unlock()
+			// Bytes kept from an earlier attempt do not count towards the rate
+			last += offset - lastOffset
+			lastOffset = offset
+
+			instant := float64(done-last) / now.Sub(lastTime).Seconds()
+			if rate == 0 {
+				rate = instant
+			} else {
+				// Smooth the rate so the ETA does not jump around
+				rate = 0.7*rate + 0.3*instant
+			}
+			last, lastTime = done, now
+
+			m.mutex.Lock()
+			job.transfer.Transferred = done
+			job.transfer.BytesPerSecond = int64(rate)
+			job.transfer.ETASeconds = -1
+			if rate > 0 && job.transfer.Size >= done {
+				job.transfer.ETASeconds = int64(float64(job.transfer.Size-done) / rate)
+			}
+			transfer := job.transfer
+			store := m.store
+			m.mutex.Unlock()
+
+			emitEvent(m.ctx, EventTransferProgress, transfer)
+			saveNow := now.Sub(lastSave) >= transferSaveInterval
+			if saveNow {
+				lastSave = now
+				if err := store.UpdateTransfer(&transfer); err != nil {
+					log.Printf("TRANSFER - Failed to save progress of %s: %v", transfer.ID, err)
+				}
+			}
+			if transfer.ParentID != "" {
+				m.updateParent(transfer.ParentID, saveNow)
+			}
+		}
+	}
+}
+
+// save stores a transfer and tells the frontend about its new state
+func (m *TransferManager) save(job *transferJob) {
+	m.mutex.Lock()
+	transfer := job.transfer
+	store := m.store
+	m.mutex.Unlock()
+
+	if err := store.UpdateTransfer(&transfer); err != nil {
+		log.Printf("TRANSFER - Failed to save transfer %s: %v", transfer.ID, err)
+	}
+	emitEvent(m.ctx, EventTransferProgress, transfer)
+
+	if transfer.ParentID != "" {
+		m.updateParent(transfer.ParentID, true)
+	}
+}
+
+// snapshot must be called with the manager mutex held
+func (j *transferJob) snapshot() models.Transfer {
+	transfer := j.transfer
+	if transfer.Status == models.TransferRunning {
+		transfer.Transferred = j.done.Load()
+	}
+	return transfer
+}
@@ -0,0 +1,583 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"termunator/internal/models"
+)
+
+// treeEntry is a file, directory or preserved symlink found under the root of a directory transfer
+type treeEntry struct {
+	rel    string // slash separated path below the root
+	source string // full path to read, inside a followed symlink it is not root + rel
+	info   os.FileInfo
+	link   string // target of a preserved symlink
+}
+
+func (e treeEntry) isLink() bool {
+	return e.link != ""
+}
+
+// treeWalk collects the entries of a tree. Followed symlinks to directories are walked where
+// they point to, each real directory only once so link loops end.
+type treeWalk struct {
+	ctx     context.Context
+	policy  models.SymlinkPolicy
+	visited map[string]bool
+	entries []treeEntry
+}
+
+func newTreeWalk(ctx context.Context, policy models.SymlinkPolicy) *treeWalk {
+	if policy == "" {
+		policy = models.SymlinksFollow
+	}
+	return &treeWalk{ctx: ctx, policy: policy, visited: make(map[string]bool)}
+}
+
+// walkLocal walks a local directory with filepath.WalkDir
+func (w *treeWalk) walkLocal(root, prefix string) error {
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	if w.visited[real] {
+		log.Printf("TRANSFER - Skipping %s, it links back into the tree", root)
+		return nil
+	}
+	w.visited[real] = true
+
+	return filepath.WalkDir(real, func(p string, d fs.DirEntry, err error) error {
+		if ctxErr := w.ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if err != nil {
+			if p == real {
+				return err
+			}
+			log.Printf("TRANSFER - Skipping %s: %v", p, err)
+			return nil
+		}
+		if p == real {
+			return nil
+		}
+
+		relative, err := filepath.Rel(real, p)
+		if err != nil {
+			return err
+		}
+		rel := path.Join(prefix, filepath.ToSlash(relative))
+
+		info, err := d.Info()
+		if err != nil {
+			log.Printf("TRANSFER - Skipping %s: %v", p, err)
+			return nil
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			switch w.policy {
+			case models.SymlinksSkip:
+				return nil
+			case models.SymlinksPreserve:
+				target, err := os.Readlink(p)
+				if err != nil {
+					log.Printf("TRANSFER - Skipping symlink %s: %v", p, err)
+					return nil
+				}
+				w.entries = append(w.entries, treeEntry{rel: rel, source: p, info: info, link: target})
+				return nil
+			}
+
+			target, err := os.Stat(p)
+			if err != nil {
+				log.Printf("TRANSFER - Skipping dangling symlink %s: %v", p, err)
+				return nil
+			}
+			if target.IsDir() {
+				if real, err := filepath.EvalSymlinks(p); err == nil && w.visited[real] {
+					log.Printf("TRANSFER - Skipping %s, it links back into the tree", p)
+					return nil
+				}
+				w.entries = append(w.entries, treeEntry{rel: rel, source: p, info: target})
+				return w.walkLocal(p, rel)
+			}
+			info = target
+		}
+
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			log.Printf("TRANSFER - Skipping special file %s", p)
+			return nil
+		}
+		w.entries = append(w.entries, treeEntry{rel: rel, source: p, info: info})
+		return nil
+	})
+}
+
+// walkRemote walks a remote directory with sftp.Client.Walk
+func (w *treeWalk) walkRemote(client *sftp.Client, root, prefix string) error {
+	real, err := client.RealPath(root)
+	if err != nil {
+		return err
+	}
+	if w.visited[real] {
+		log.Printf("TRANSFER - Skipping %s, it links back into the tree", root)
+		return nil
+	}
+	w.visited[real] = true
+
+	walker := client.Walk(real)
+	for walker.Step() {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+		p := walker.Path()
+		if err := walker.Err(); err != nil {
+			if p == real {
+				return err
+			}
+			log.Printf("TRANSFER - Skipping %s: %v", p, err)
+			continue
+		}
+		if p == real {
+			continue
+		}
+
+		rel := path.Join(prefix, strings.TrimPrefix(strings.TrimPrefix(p, real), "/"))
+		info := walker.Stat()
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			switch w.policy {
+			case models.SymlinksSkip:
+				continue
+			case models.SymlinksPreserve:
+				target, err := client.ReadLink(p)
+				if err != nil {
+					log.Printf("TRANSFER - Skipping symlink %s: %v", p, err)
+					continue
+				}
+				w.entries = append(w.entries, treeEntry{rel: rel, source: p, info: info, link: target})
+				continue
+			}
+
+			target, err := client.Stat(p)
+			if err != nil {
+				log.Printf("TRANSFER - Skipping dangling symlink %s: %v", p, err)
+				continue
+			}
+			if target.IsDir() {
+				if real, err := client.RealPath(p); err == nil && w.visited[real] {
+					log.Printf("TRANSFER - Skipping %s, it links back into the tree", p)
+					continue
+				}
+				w.entries = append(w.entries, treeEntry{rel: rel, source: p, info: target})
+				if err := w.walkRemote(client, p, rel); err != nil {
+					return err
+				}
+				continue
+			}
+			info = target
+		}
+
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			log.Printf("TRANSFER - Skipping special file %s", p)
+			continue
+		}
+		w.entries = append(w.entries, treeEntry{rel: rel, source: p, info: info})
+	}
+	return nil
+}
+
+// directories returns the directory entries, deepest first so setting their times is not undone
+// by changes to their subdirectories
+func directories(entries []treeEntry) []treeEntry {
+	var dirs []treeEntry
+	for _, entry := range entries {
+		if entry.info.IsDir() {
+			dirs = append(dirs, entry)
+		}
+	}
+	sort.SliceStable(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i].rel, "/") > strings.Count(dirs[j].rel, "/")
+	})
+	return dirs
+}
+
+// removeRemoteTree deletes a remote directory and everything below it. Symlinks are removed,
+// never followed.
+func removeRemoteTree(client *sftp.Client, root string) error {
+	info, err := client.Lstat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return client.Remove(root)
+	}
+
+	var paths []string
+	var dirs []bool
+	walker := client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			return fmt.Errorf("failed to list %s: %w", walker.Path(), err)
+		}
+		paths = append(paths, walker.Path())
+		dirs = append(dirs, walker.Stat().IsDir())
+	}
+
+	// Walk lists a directory before its contents
+	var failed []error
+	for i := len(paths) - 1; i >= 0; i-- {
+		if dirs[i] {
+			err = client.RemoveDirectory(paths[i])
+		} else {
+			err = client.Remove(paths[i])
+		}
+		if err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", paths[i], err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to delete %d entries: %w", len(failed), errors.Join(failed...))
+	}
+	return nil
+}
+
+// expand walks the source of a directory transfer, creates its directories and preserved
+// symlinks at the destination and queues a transfer for each file
+func (m *TransferManager) expand(ctx context.Context, job *transferJob) {
+	m.save(job)
+
+	m.mutex.Lock()
+	transfer := job.transfer
+	store := m.store
+	m.mutex.Unlock()
+
+	entries, err := m.prepareTree(ctx, job, &transfer)
+
+	var children []*transferJob
+	if err == nil {
+		for _, entry := range entries {
+			if entry.info.IsDir() || entry.isLink() {
+				continue
+			}
+			req := models.TransferCreateRequest{
+				HostID:     transfer.HostID,
+				ParentID:   transfer.ID,
+				Direction:  transfer.Direction,
+				LocalPath:  transfer.LocalPath,
+				RemotePath: transfer.RemotePath,
+				Conflict:   transfer.Conflict,
+				Size:       entry.info.Size(),
+			}
+			if transfer.Direction == models.TransferUpload {
+				req.LocalPath = entry.source
+				req.RemotePath = path.Join(transfer.RemotePath, entry.rel)
+			} else {
+				req.RemotePath = entry.source
+				req.LocalPath = filepath.Join(transfer.LocalPath, filepath.FromSlash(entry.rel))
+			}
+
+			child, createErr := store.CreateTransfer(req)
+			if createErr != nil {
+				err = fmt.Errorf("failed to queue %s: %w", entry.rel, createErr)
+				break
+			}
+			children = append(children, &transferJob{transfer: *child})
+		}
+	}
+
+	m.mutex.Lock()
+	job.cancel()
+	if err != nil {
+		for _, child := range children {
+			if deleteErr := store.DeleteTransfer(child.transfer.ID); deleteErr != nil {
+				log.Printf("TRANSFER - Failed to delete transfer %s: %v", child.transfer.ID, deleteErr)
+			}
+		}
+		if errors.Is(err, errSkipped) {
+			job.transfer.Status = models.TransferSkipped
+			log.Printf("TRANSFER - Skipped %s of %s, the destination exists", transfer.Direction, transfer.RemotePath)
+		} else if job.stopAs != "" {
+			job.transfer.Status = job.stopAs
+			log.Printf("TRANSFER - Stopped %s of %s, now %s", transfer.Direction, transfer.RemotePath, job.stopAs)
+		} else if m.held {
+			job.transfer.Status = models.TransferQueued
+			log.Printf("TRANSFER - Vault locked, %s of %s stays queued: %v", transfer.Direction, transfer.RemotePath, err)
+		} else {
+			job.transfer.Status = models.TransferFailed
+			job.transfer.Error = err.Error()
+			log.Printf("TRANSFER - Failed %s of %s: %v", transfer.Direction, transfer.RemotePath, err)
+		}
+		m.mutex.Unlock()
+
+		m.save(job)
+		m.schedule()
+		return
+	}
+
+	// Pausing or canceling while the tree was walked applies to its files
+	for _, child := range children {
+		if job.stopAs != "" {
+			child.transfer.Status = job.stopAs
+		}
+		m.jobs[child.transfer.ID] = child
+		m.order = append(m.order, child.transfer.ID)
+		job.children = append(job.children, child.transfer.ID)
+	}
+	job.expanded = true
+	job.dirs = directories(entries)
+	stopped := job.stopAs != ""
+	m.mutex.Unlock()
+
+	if stopped {
+		for _, child := range children {
+			m.save(child)
+		}
+	}
+
+	log.Printf("TRANSFER - Queued %d files of %s", len(children), transfer.RemotePath)
+	m.updateParent(transfer.ID, true)
+	m.schedule()
+}
+
+// prepareTree walks the source of a directory transfer and creates the directories and
+// preserved symlinks, the first entry is the root itself. Keeping both moves the transfer to
+// a new root.
+func (m *TransferManager) prepareTree(ctx context.Context, job *transferJob, transfer *models.Transfer) ([]treeEntry, error) {
+	client, closeClient, err := m.connect(transfer.HostID)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient()
+
+	if err := m.resolveConflict(client, job, transfer); err != nil {
+		return nil, err
+	}
+
+	walk := newTreeWalk(ctx, transfer.Symlinks)
+	if transfer.Direction == models.TransferUpload {
+		info, err := os.Stat(transfer.LocalPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat local directory %s: %w", transfer.LocalPath, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", transfer.LocalPath)
+		}
+		walk.entries = append(walk.entries, treeEntry{source: transfer.LocalPath, info: info})
+		if err := walk.walkLocal(transfer.LocalPath, ""); err != nil {
+			return nil, fmt.Errorf("failed to list local directory %s: %w", transfer.LocalPath, err)
+		}
+	} else {
+		info, err := client.Stat(transfer.RemotePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat remote directory %s: %w", transfer.RemotePath, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", transfer.RemotePath)
+		}
+		walk.entries = append(walk.entries, treeEntry{source: transfer.RemotePath, info: info})
+		if err := walk.walkRemote(client, transfer.RemotePath, ""); err != nil {
+			return nil, fmt.Errorf("failed to list remote directory %s: %w", transfer.RemotePath, err)
+		}
+	}
+
+	// Entries are listed parents first
+	for _, entry := range walk.entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if transfer.Direction == models.TransferUpload {
+			destination := path.Join(transfer.RemotePath, entry.rel)
+			switch {
+			case entry.info.IsDir():
+				if err := client.MkdirAll(destination); err != nil {
+					return nil, fmt.Errorf("failed to create remote directory %s: %w", destination, err)
+				}
+			case entry.isLink():
+				placeLink(client, models.Transfer{
+					Direction:  transfer.Direction,
+					LocalPath:  entry.source,
+					RemotePath: destination,
+					Conflict:   transfer.Conflict,
+				}, entry.link)
+			}
+			continue
+		}
+
+		destination := filepath.Join(transfer.LocalPath, filepath.FromSlash(entry.rel))
+		switch {
+		case entry.info.IsDir():
+			if err := os.MkdirAll(destination, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create local directory %s: %w", destination, err)
+			}
+		case entry.isLink():
+			placeLink(client, models.Transfer{
+				Direction:  transfer.Direction,
+				LocalPath:  destination,
+				RemotePath: entry.source,
+				Conflict:   transfer.Conflict,
+			}, entry.link)
+		}
+	}
+	return walk.entries, nil
+}
+
+// placeLink recreates a preserved symlink at the destination of link, which is replaced or
+// kept by the conflict policy like a file would be. Failures are logged, the files of the
+// tree are copied either way.
+func placeLink(client *sftp.Client, link models.Transfer, target string) {
+	_, original, _ := transferEnds(link)
+	if err := resolveConflict(client, &link); err != nil {
+		if errors.Is(err, errSkipped) {
+			log.Printf("TRANSFER - Skipped symlink %s, the destination exists", original)
+		} else {
+			log.Printf("TRANSFER - Failed to create symlink %s: %v", original, err)
+		}
+		return
+	}
+
+	_, destination, remote := transferEnds(link)
+	var err error
+	if remote {
+		// A symlink whose target is gone does not stat as existing, it is replaced too
+		client.Remove(destination)
+		err = client.Symlink(target, destination)
+	} else {
+		os.Remove(destination)
+		err = os.Symlink(target, destination)
+	}
+	if err != nil {
+		log.Printf("TRANSFER - Failed to create symlink %s: %v", destination, err)
+	}
+}
+
+// updateParent sums the files of a directory transfer into its progress and status. The
+// directory is saved when persist is set or its status changed.
+func (m *TransferManager) updateParent(id string, persist bool) {
+	m.mutex.Lock()
+	job, exists := m.jobs[id]
+	if !exists || !job.expanded {
+		m.mutex.Unlock()
+		return
+	}
+
+	var size, transferred, rate int64
+	counts := make(map[models.TransferStatus]int)
+	for _, childID := range job.children {
+		child := m.jobs[childID].snapshot()
+		counts[child.Status]++
+		if child.Status == models.TransferSkipped {
+			continue
+		}
+		size += child.Size
+		transferred += child.Transferred
+		if child.Status == models.TransferRunning {
+			rate += child.BytesPerSecond
+		}
+	}
+
+	status := models.TransferCompleted
+	switch {
+	case counts[models.TransferRunning] > 0:
+		status = models.TransferRunning
+	case counts[models.TransferQueued] > 0:
+		status = models.TransferQueued
+	case counts[models.TransferPaused] > 0:
+		status = models.TransferPaused
+	case counts[models.TransferFailed] > 0:
+		status = models.TransferFailed
+	case counts[models.TransferCanceled] > 0:
+		status = models.TransferCanceled
+	}
+
+	changed := job.transfer.Status != status
+	job.transfer.Status = status
+	job.transfer.Size = size
+	job.transfer.Transferred = transferred
+	job.transfer.BytesPerSecond = rate
+	job.transfer.ETASeconds = -1
+	if rate > 0 && size >= transferred {
+		job.transfer.ETASeconds = (size - transferred) / rate
+	}
+	job.transfer.Files = len(job.children)
+	job.transfer.FilesDone = counts[models.TransferCompleted]
+	job.transfer.FilesFailed = counts[models.TransferFailed]
+	job.transfer.FilesSkipped = counts[models.TransferSkipped]
+	job.transfer.Error = ""
+	if status == models.TransferFailed {
+		job.transfer.Error = fmt.Sprintf("%d of %d files failed", job.transfer.FilesFailed, job.transfer.Files)
+	}
+
+	// Directory times are set last, copying files into them changes them
+	var dirs []treeEntry
+	if status == models.TransferCompleted {
+		dirs, job.dirs = job.dirs, nil
+	}
+	transfer := job.transfer
+	store := m.store
+	m.mutex.Unlock()
+
+	if persist || changed {
+		if err := store.UpdateTransfer(&transfer); err != nil {
+			log.Printf("TRANSFER - Failed to save transfer %s: %v", transfer.ID, err)
+		}
+	}
+	if changed && status == models.TransferCompleted {
+		log.Printf("TRANSFER - Completed %s of %s (%d files, %d bytes)", transfer.Direction, transfer.RemotePath, transfer.Files, transfer.Transferred)
+	}
+	emitEvent(m.ctx, EventTransferProgress, transfer)
+
+	if len(dirs) > 0 {
+		go m.finishTree(transfer, dirs)
+	}
+}
+
+// finishTree gives the directories of a completed directory transfer the mode and
+// modification time of their source. Directories of a transfer restored after a restart are
+// left as they are, the tree is not walked again.
+func (m *TransferManager) finishTree(transfer models.Transfer, dirs []treeEntry) {
+	if transfer.Direction == models.TransferDownload {
+		for _, dir := range dirs {
+			destination := filepath.Join(transfer.LocalPath, filepath.FromSlash(dir.rel))
+			if err := os.Chmod(destination, dir.info.Mode().Perm()); err != nil {
+				log.Printf("TRANSFER - Failed to set the mode of %s: %v", destination, err)
+			}
+			if err := os.Chtimes(destination, time.Now(), dir.info.ModTime()); err != nil {
+				log.Printf("TRANSFER - Failed to set the modification time of %s: %v", destination, err)
+			}
+		}
+		return
+	}
+
+	client, closeClient, err := m.connect(transfer.HostID)
+	if err != nil {
+		log.Printf("TRANSFER - Failed to set directory times of %s: %v", transfer.RemotePath, err)
+		return
+	}
+	defer closeClient()
+
+	for _, dir := range dirs {
+		destination := path.Join(transfer.RemotePath, dir.rel)
+		if runtime.GOOS != "windows" {
+			if err := client.Chmod(destination, dir.info.Mode().Perm()); err != nil {
+				log.Printf("TRANSFER - Failed to set the mode of %s: %v", destination, err)
+			}
+		}
+		if err := client.Chtimes(destination, time.Now(), dir.info.ModTime()); err != nil {
+			log.Printf("TRANSFER - Failed to set the modification time of %s: %v", destination, err)
+		}
+	}
+}
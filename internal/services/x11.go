@@ -0,0 +1,267 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"termunator/internal/models"
+)
+
+const (
+	x11AuthProtocol = "MIT-MAGIC-COOKIE-1"
+	x11BaseTCPPort  = 6000
+	x11UnixSocket   = "/tmp/.X11-unix/X%d"
+	xauthTimeout    = 2 * time.Second
+)
+
+// x11Forwarding proxies the X11 channels of one client to the local display. Remote X clients
+// authenticate with a generated fake cookie, which is swapped for the real cookie of the local
+// display, if it has one, so the real cookie never reaches the server.
+type x11Forwarding struct {
+	display    string
+	screen     int
+	fakeCookie []byte
+	realProto  string
+	realCookie []byte
+}
+
+// x11RequestMsg is the payload of an x11-req, RFC 4254 section 6.3.1
+type x11RequestMsg struct {
+	SingleConnection bool
+	AuthProtocol     string
+	AuthCookie       string
+	ScreenNumber     uint32
+}
+
+// serveX11 accepts the x11 channels the host opens on a freshly dialed client. Like agent
+// forwarding it is registered once per transport, sessions only send the x11-req.
+func (s *SSHService) serveX11(client *ssh.Client, host *models.Host) error {
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		return errors.New("DISPLAY environment variable not set")
+	}
+	_, _, screen, err := parseDisplay(display)
+	if err != nil {
+		return err
+	}
+
+	forwarding := &x11Forwarding{
+		display:    display,
+		screen:     screen,
+		fakeCookie: make([]byte, 16),
+	}
+	if _, err := rand.Read(forwarding.fakeCookie); err != nil {
+		return fmt.Errorf("failed to generate X11 cookie: %w", err)
+	}
+	forwarding.realProto, forwarding.realCookie = localX11Cookie(display)
+
+	channels := client.HandleChannelOpen("x11")
+	if channels == nil {
+		return errors.New("x11 channels are already handled")
+	}
+
+	s.x11Mutex.Lock()
+	s.x11[client] = forwarding
+	s.x11Mutex.Unlock()
+
+	go func() {
+		for newChannel := range channels {
+			go forwarding.accept(newChannel, host.Label)
+		}
+		// The channel is closed with the connection
+		s.x11Mutex.Lock()
+		delete(s.x11, client)
+		s.x11Mutex.Unlock()
+	}()
+
+	log.Printf("SSH SERVICE - Forwarding X11 of %s to %s", host.Label, display)
+	return nil
+}
+
+// requestX11 asks the server to forward X11 connections of a session to us
+func (s *SSHService) requestX11(client *ssh.Client, session *ssh.Session) error {
+	s.x11Mutex.Lock()
+	forwarding, exists := s.x11[client]
+	s.x11Mutex.Unlock()
+	if !exists {
+		return errors.New("X11 forwarding is not available on this connection")
+	}
+
+	ok, err := session.SendRequest("x11-req", true, ssh.Marshal(x11RequestMsg{
+		AuthProtocol: x11AuthProtocol,
+		AuthCookie:   hex.EncodeToString(forwarding.fakeCookie),
+		ScreenNumber: uint32(forwarding.screen),
+	}))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("server refused X11 forwarding")
+	}
+	return nil
+}
+
+// accept connects an x11 channel to the local display
+func (f *x11Forwarding) accept(newChannel ssh.NewChannel, label string) {
+	local, err := dialDisplay(f.display)
+	if err != nil {
+		log.Printf("SSH SERVICE - Failed to connect X11 client of %s to %s: %v", label, f.display, err)
+		newChannel.Reject(ssh.ConnectionFailed, "failed to connect to the local X server")
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		local.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	if err := f.replaceCookie(channel, local); err != nil {
+		log.Printf("SSH SERVICE - Rejected X11 client of %s: %v", label, err)
+		channel.Close()
+		local.Close()
+		return
+	}
+
+	pipeConns(local, channel, nil, nil)
+}
+
+// replaceCookie reads the connection setup of the X client, checks it carries the fake
+// cookie and sends it on with the real one
+func (f *x11Forwarding) replaceCookie(channel io.Reader, local io.Writer) error {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(channel, header); err != nil {
+		return fmt.Errorf("failed to read X11 setup: %w", err)
+	}
+
+	var order binary.ByteOrder
+	switch header[0] {
+	case 'B':
+		order = binary.BigEndian
+	case 'l':
+		order = binary.LittleEndian
+	default:
+		return fmt.Errorf("invalid X11 byte order %#x", header[0])
+	}
+
+	nameLength := int(order.Uint16(header[6:8]))
+	dataLength := int(order.Uint16(header[8:10]))
+	auth := make([]byte, x11Pad(nameLength)+x11Pad(dataLength))
+	if _, err := io.ReadFull(channel, auth); err != nil {
+		return fmt.Errorf("failed to read X11 authentication: %w", err)
+	}
+	name := string(auth[:nameLength])
+	data := auth[x11Pad(nameLength) : x11Pad(nameLength)+dataLength]
+
+	if name != x11AuthProtocol || subtle.ConstantTimeCompare(data, f.fakeCookie) != 1 {
+		return errors.New("X11 authentication does not match the forwarded cookie")
+	}
+
+	var setup bytes.Buffer
+	setup.Write(header[:6])
+	lengths := make([]byte, 4)
+	order.PutUint16(lengths[0:2], uint16(len(f.realProto)))
+	order.PutUint16(lengths[2:4], uint16(len(f.realCookie)))
+	setup.Write(lengths)
+	setup.Write(header[10:12])
+	setup.WriteString(f.realProto)
+	setup.Write(make([]byte, x11Pad(len(f.realProto))-len(f.realProto)))
+	setup.Write(f.realCookie)
+	setup.Write(make([]byte, x11Pad(len(f.realCookie))-len(f.realCookie)))
+
+	_, err := local.Write(setup.Bytes())
+	return err
+}
+
+// x11Pad rounds up to the 4 byte alignment of the X11 protocol
+func x11Pad(n int) int {
+	return (n + 3) &^ 3
+}
+
+// parseDisplay splits $DISPLAY into where to reach the X server and the screen number.
+// ":0", "unix:0" and launchd paths are Unix sockets, "host:0" is TCP port 6000+0.
+func parseDisplay(display string) (host string, number, screen int, err error) {
+	colon := strings.LastIndex(display, ":")
+	if colon < 0 {
+		return "", 0, 0, fmt.Errorf("invalid DISPLAY %q", display)
+	}
+	host = display[:colon]
+
+	numbers := display[colon+1:]
+	if dot := strings.Index(numbers, "."); dot >= 0 {
+		if screen, err = strconv.Atoi(numbers[dot+1:]); err != nil {
+			return "", 0, 0, fmt.Errorf("invalid screen in DISPLAY %q", display)
+		}
+		numbers = numbers[:dot]
+	}
+	if number, err = strconv.Atoi(numbers); err != nil {
+		return "", 0, 0, fmt.Errorf("invalid display number in DISPLAY %q", display)
+	}
+	return host, number, screen, nil
+}
+
+// dialDisplay connects to the local X server of a display
+func dialDisplay(display string) (net.Conn, error) {
+	host, number, _, err := parseDisplay(display)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasPrefix(host, "/"):
+		// XQuartz puts a launchd socket path in DISPLAY, the socket is the whole value
+		return net.Dial("unix", display)
+	case host == "" || host == "unix":
+		conn, err := net.Dial("unix", fmt.Sprintf(x11UnixSocket, number))
+		if err == nil || host == "unix" {
+			return conn, err
+		}
+		// X servers on Windows only listen on TCP
+		return net.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(x11BaseTCPPort+number)))
+	default:
+		return net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(x11BaseTCPPort+number)))
+	}
+}
+
+// localX11Cookie asks xauth for the cookie of the local display. Without one the X client is
+// sent on without authentication, which local servers usually accept.
+func localX11Cookie(display string) (string, []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), xauthTimeout)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "xauth", "list", display).Output()
+	if err != nil {
+		log.Printf("SSH SERVICE - No xauth cookie for %s, X11 clients connect without one: %v", display, err)
+		return "", nil
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[1] != x11AuthProtocol {
+			continue
+		}
+		cookie, err := hex.DecodeString(fields[2])
+		if err != nil {
+			continue
+		}
+		return x11AuthProtocol, cookie
+	}
+	return "", nil
+}
@@ -1,11 +1,16 @@
 package storage
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -70,6 +75,15 @@ func (d *Database) migrate() error {
 			created_at DATETIME NOT NULL,
 			updated_at DATETIME NOT NULL
 		)`,
+		`CREATE TABLE IF NOT EXISTS key_passphrases (
+			key_id TEXT PRIMARY KEY,
+			passphrase TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)`,
 		`CREATE TABLE IF NOT EXISTS macros (
 			id TEXT PRIMARY KEY,
 			label TEXT NOT NULL,
@@ -88,9 +102,104 @@ func (d *Database) migrate() error {
 			timestamp DATETIME NOT NULL,
 			FOREIGN KEY (host_id) REFERENCES hosts (id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS port_forwards (
+			id TEXT PRIMARY KEY,
+			host_id TEXT NOT NULL,
+			label TEXT,
+			type TEXT NOT NULL,
+			bind_address TEXT,
+			bind_port INTEGER NOT NULL,
+			target_host TEXT,
+			target_port INTEGER,
+			auto_start INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY (host_id) REFERENCES hosts (id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS known_hosts (
+			id TEXT PRIMARY KEY,
+			marker TEXT,
+			hosts TEXT NOT NULL,
+			algorithm TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			comment TEXT,
+			accepted_by TEXT,
+			first_seen DATETIME NOT NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS host_cas (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			tags TEXT,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS proxies (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			host TEXT,
+			port INTEGER,
+			username TEXT,
+			password TEXT,
+			command TEXT,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS transfers (
+			id TEXT PRIMARY KEY,
+			host_id TEXT NOT NULL,
+			direction TEXT NOT NULL,
+			local_path TEXT NOT NULL,
+			remote_path TEXT NOT NULL,
+			status TEXT NOT NULL,
+			size INTEGER NOT NULL DEFAULT 0,
+			transferred INTEGER NOT NULL DEFAULT 0,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			error TEXT,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY (host_id) REFERENCES hosts (id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS sync_profiles (
+			id TEXT PRIMARY KEY,
+			host_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			local_path TEXT NOT NULL,
+			remote_path TEXT NOT NULL,
+			direction TEXT NOT NULL,
+			compare TEXT NOT NULL,
+			include TEXT,
+			exclude TEXT,
+			delete_extraneous INTEGER NOT NULL DEFAULT 0,
+			last_run_at DATETIME,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			FOREIGN KEY (host_id) REFERENCES hosts (id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS sync_state (
+			profile_id TEXT NOT NULL,
+			path TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			local_mod_time DATETIME NOT NULL,
+			remote_mod_time DATETIME NOT NULL,
+			PRIMARY KEY (profile_id, path),
+			FOREIGN KEY (profile_id) REFERENCES sync_profiles (id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS vault (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			salt BLOB,
+			verifier TEXT,
+			auto_lock_minutes INTEGER NOT NULL
+		)`,
 		`CREATE INDEX IF NOT EXISTS idx_hosts_last_used ON hosts(last_used DESC)`,
 		`CREATE INDEX IF NOT EXISTS idx_history_host_id ON history(host_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_history_timestamp ON history(timestamp DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_port_forwards_host_id ON port_forwards(host_id)`,
 	}
 
 	for _, query := range queries {
@@ -99,6 +208,62 @@ func (d *Database) migrate() error {
 		}
 	}
 
+	// Columns added after the initial schema, older databases get them through ALTER TABLE
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"hosts", "jump_host_ids", "TEXT"},
+		{"hosts", "auto_reconnect", "INTEGER NOT NULL DEFAULT 1"},
+		{"hosts", "resume_command", "TEXT"},
+		{"hosts", "totp_secret", "TEXT"},
+		{"hosts", "forward_agent", "TEXT"},
+		{"hosts", "forward_agent_confirm", "INTEGER NOT NULL DEFAULT 0"},
+		{"hosts", "forward_x11", "INTEGER NOT NULL DEFAULT 0"},
+		{"hosts", "proxy_id", "TEXT"},
+		{"private_keys", "encrypted", "INTEGER NOT NULL DEFAULT 0"},
+		{"private_keys", "certificate", "TEXT"},
+		{"private_keys", "public_key", "TEXT"},
+		{"transfers", "parent_id", "TEXT"},
+		{"transfers", "is_dir", "INTEGER NOT NULL DEFAULT 0"},
+		{"transfers", "symlinks", "TEXT"},
+		{"transfers", "conflict", "TEXT"},
+	}
+
+	for _, c := range columns {
+		if err := d.addColumnIfMissing(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Database) addColumnIfMissing(table, column, definition string) error {
+	rows, err := d.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to read columns of %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("failed to scan column of %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	rows.Close()
+
+	query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)
+	if _, err := d.db.Exec(query); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
 	return nil
 }
 
@@ -110,15 +275,23 @@ func (d *Database) Close() error {
 
 func (d *Database) CreateHost(req models.HostCreateRequest) (*models.Host, error) {
 	host := &models.Host{
-		ID:         uuid.New().String(),
-		Label:      req.Label,
-		Hostname:   req.Hostname,
-		Port:       req.Port,
-		Username:   req.Username,
-		AuthMethod: req.AuthMethod,
-		Tags:       req.Tags,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		ID:            uuid.New().String(),
+		Label:         req.Label,
+		Hostname:      req.Hostname,
+		Port:          req.Port,
+		Username:      req.Username,
+		AuthMethod:    req.AuthMethod,
+		Tags:          req.Tags,
+		JumpHostIDs:   req.JumpHostIDs,
+		AutoReconnect: req.AutoReconnect,
+		ResumeCommand: req.ResumeCommand,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+
+		ForwardAgent:        req.ForwardAgent,
+		ForwardAgentConfirm: req.ForwardAgentConfirm,
+		ForwardX11:          req.ForwardX11,
+		ProxyID:             req.ProxyID,
 	}
 
 	// Encrypt sensitive data
@@ -130,21 +303,40 @@ func (d *Database) CreateHost(req models.HostCreateRequest) (*models.Host, error
 		host.Password = encrypted
 	}
 
-	if req.PrivateKey != "" {
-		encrypted, err := d.encryption.Encrypt(req.PrivateKey)
+	// Keys live in the key store, hosts only reference them
+	keyID, err := d.hostPrivateKeyID(req.PrivateKeyID, req.PrivateKey, req.Label)
+	if err != nil {
+		return nil, err
+	}
+	host.PrivateKeyID = keyID
+
+	if req.TOTPSecret != "" {
+		encrypted, err := d.encryption.Encrypt(req.TOTPSecret)
 		if err != nil {
-			return nil, fmt.Errorf("failed to encrypt private key: %w", err)
+			return nil, fmt.Errorf("failed to encrypt TOTP secret: %w", err)
 		}
-		host.PrivateKey = encrypted
+		host.TOTPSecret = encrypted
+		host.HasTOTPSecret = true
+	}
+
+	if err := d.validateHostProxy(host.ProxyID); err != nil {
+		return nil, err
+	}
+
+	if err := d.validateJumpHosts(host.ID, host.JumpHostIDs); err != nil {
+		return nil, err
 	}
 
 	tagsJSON, _ := json.Marshal(host.Tags)
+	jumpHostsJSON, _ := json.Marshal(host.JumpHostIDs)
 
-	query := `INSERT INTO hosts (id, label, hostname, port, username, auth_method, password, private_key, tags, created_at, updated_at)
-			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO hosts (id, label, hostname, port, username, auth_method, password, private_key_id, totp_secret, tags, jump_host_ids,
+			  auto_reconnect, resume_command, forward_agent, forward_agent_confirm, forward_x11, proxy_id, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err := d.db.Exec(query, host.ID, host.Label, host.Hostname, host.Port, host.Username,
-		host.AuthMethod, host.Password, host.PrivateKey, string(tagsJSON), host.CreatedAt, host.UpdatedAt)
+	_, err = d.db.Exec(query, host.ID, host.Label, host.Hostname, host.Port, host.Username,
+		host.AuthMethod, host.Password, nullIfEmpty(host.PrivateKeyID), host.TOTPSecret, string(tagsJSON), string(jumpHostsJSON),
+		host.AutoReconnect, host.ResumeCommand, nullIfEmpty(string(host.ForwardAgent)), host.ForwardAgentConfirm, host.ForwardX11, nullIfEmpty(host.ProxyID), host.CreatedAt, host.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create host: %w", err)
 	}
@@ -152,12 +344,51 @@ func (d *Database) CreateHost(req models.HostCreateRequest) (*models.Host, error
 	// Don't return encrypted data in response
 	host.Password = ""
 	host.PrivateKey = ""
+	host.TOTPSecret = ""
+
+	return host, nil
+}
+
+// hostColumns are selected by every host query, credentials are added by GetHost only
+const hostColumns = `id, label, hostname, port, username, auth_method, private_key_id, tags, jump_host_ids,
+			  auto_reconnect, resume_command, forward_agent, forward_agent_confirm, forward_x11, proxy_id, last_used, created_at, updated_at,
+			  COALESCE(totp_secret, '') != ''`
+
+// scanHost scans hostColumns followed by any extra destinations
+func scanHost(row interface{ Scan(...any) error }, extra ...any) (*models.Host, error) {
+	host := &models.Host{}
+	var privateKeyID, tagsJSON, jumpHostsJSON, resumeCommand, forwardAgent, proxyID sql.NullString
+	var lastUsed sql.NullTime
+
+	dest := []any{&host.ID, &host.Label, &host.Hostname, &host.Port, &host.Username, &host.AuthMethod, &privateKeyID,
+		&tagsJSON, &jumpHostsJSON, &host.AutoReconnect, &resumeCommand, &forwardAgent, &host.ForwardAgentConfirm, &host.ForwardX11, &proxyID,
+		&lastUsed, &host.CreatedAt, &host.UpdatedAt, &host.HasTOTPSecret}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	if tagsJSON.Valid {
+		json.Unmarshal([]byte(tagsJSON.String), &host.Tags)
+	}
+
+	if jumpHostsJSON.Valid {
+		json.Unmarshal([]byte(jumpHostsJSON.String), &host.JumpHostIDs)
+	}
+
+	host.PrivateKeyID = privateKeyID.String
+	host.ResumeCommand = resumeCommand.String
+	host.ForwardAgent = models.AgentForwarding(forwardAgent.String)
+	host.ProxyID = proxyID.String
+
+	if lastUsed.Valid {
+		host.LastUsed = &lastUsed.Time
+	}
 
 	return host, nil
 }
 
 func (d *Database) GetHosts() ([]*models.Host, error) {
-	query := `SELECT id, label, hostname, port, username, auth_method, tags, last_used, created_at, updated_at
+	query := `SELECT ` + hostColumns + `
 			  FROM hosts ORDER BY last_used DESC, created_at DESC`
 
 	rows, err := d.db.Query(query)
@@ -168,24 +399,11 @@ func (d *Database) GetHosts() ([]*models.Host, error) {
 
 	var hosts []*models.Host
 	for rows.Next() {
-		host := &models.Host{}
-		var tagsJSON sql.NullString
-		var lastUsed sql.NullTime
-
-		err := rows.Scan(&host.ID, &host.Label, &host.Hostname, &host.Port, &host.Username,
-			&host.AuthMethod, &tagsJSON, &lastUsed, &host.CreatedAt, &host.UpdatedAt)
+		host, err := scanHost(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan host: %w", err)
 		}
 
-		if tagsJSON.Valid {
-			json.Unmarshal([]byte(tagsJSON.String), &host.Tags)
-		}
-
-		if lastUsed.Valid {
-			host.LastUsed = &lastUsed.Time
-		}
-
 		hosts = append(hosts, host)
 	}
 
@@ -194,16 +412,12 @@ func (d *Database) GetHosts() ([]*models.Host, error) {
 
 // GetHost retrieves a host by ID with decrypted credentials
 func (d *Database) GetHost(id string) (*models.Host, error) {
-	query := `SELECT id, label, hostname, port, username, auth_method, password, private_key, tags, last_used, created_at, updated_at
+	query := `SELECT ` + hostColumns + `, password, private_key, totp_secret
 			  FROM hosts WHERE id = ?`
 
-	host := &models.Host{}
-	var tagsJSON sql.NullString
-	var lastUsed sql.NullTime
-	var password, privateKey sql.NullString
+	var password, privateKey, totpSecret sql.NullString
 
-	err := d.db.QueryRow(query, id).Scan(&host.ID, &host.Label, &host.Hostname, &host.Port, &host.Username,
-		&host.AuthMethod, &password, &privateKey, &tagsJSON, &lastUsed, &host.CreatedAt, &host.UpdatedAt)
+	host, err := scanHost(d.db.QueryRow(query, id), &password, &privateKey, &totpSecret)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -211,14 +425,6 @@ func (d *Database) GetHost(id string) (*models.Host, error) {
 		return nil, fmt.Errorf("failed to get host: %w", err)
 	}
 
-	if tagsJSON.Valid {
-		json.Unmarshal([]byte(tagsJSON.String), &host.Tags)
-	}
-
-	if lastUsed.Valid {
-		host.LastUsed = &lastUsed.Time
-	}
-
 	// Decrypt sensitive data
 	if password.Valid && password.String != "" {
 		decrypted, err := d.encryption.Decrypt(password.String)
@@ -236,6 +442,14 @@ func (d *Database) GetHost(id string) (*models.Host, error) {
 		host.PrivateKey = decrypted
 	}
 
+	if totpSecret.Valid && totpSecret.String != "" {
+		decrypted, err := d.encryption.Decrypt(totpSecret.String)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+		}
+		host.TOTPSecret = decrypted
+	}
+
 	return host, nil
 }
 
@@ -247,8 +461,38 @@ func (d *Database) UpdateHostLastUsed(id string) error {
 }
 
 func (d *Database) DeleteHost(id string) error {
+	// Refuse to break the jump chain of other hosts
+	rows, err := d.db.Query(`SELECT label FROM hosts WHERE jump_host_ids LIKE ?`, "%\""+id+"\"%")
+	if err != nil {
+		return fmt.Errorf("failed to check jump host usage: %w", err)
+	}
+	var users []string
+	for rows.Next() {
+		var label string
+		if err := rows.Scan(&label); err == nil {
+			users = append(users, label)
+		}
+	}
+	rows.Close()
+	if len(users) > 0 {
+		return fmt.Errorf("host is used as a jump host by: %s", strings.Join(users, ", "))
+	}
+
+	if _, err := d.db.Exec(`DELETE FROM port_forwards WHERE host_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete port forwards: %w", err)
+	}
+	if _, err := d.db.Exec(`DELETE FROM transfers WHERE host_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete transfers: %w", err)
+	}
+	if _, err := d.db.Exec(`DELETE FROM sync_state WHERE profile_id IN (SELECT id FROM sync_profiles WHERE host_id = ?)`, id); err != nil {
+		return fmt.Errorf("failed to delete sync state: %w", err)
+	}
+	if _, err := d.db.Exec(`DELETE FROM sync_profiles WHERE host_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete sync profiles: %w", err)
+	}
+
 	query := `DELETE FROM hosts WHERE id = ?`
-	_, err := d.db.Exec(query, id)
+	_, err = d.db.Exec(query, id)
 	return err
 }
 
@@ -262,16 +506,25 @@ func (d *Database) UpdateHost(id string, req models.HostCreateRequest) (*models.
 	}
 
 	host := &models.Host{
-		ID:         id,
-		Label:      req.Label,
-		Hostname:   req.Hostname,
-		Port:       req.Port,
-		Username:   req.Username,
-		AuthMethod: req.AuthMethod,
-		Tags:       req.Tags,
-		CreatedAt:  existingHost.CreatedAt, // Keep original creation time
-		UpdatedAt:  time.Now(),
-		LastUsed:   existingHost.LastUsed, // Keep last used time
+		ID:            id,
+		Label:         req.Label,
+		Hostname:      req.Hostname,
+		Port:          req.Port,
+		Username:      req.Username,
+		AuthMethod:    req.AuthMethod,
+		Tags:          req.Tags,
+		JumpHostIDs:   req.JumpHostIDs,
+		AutoReconnect: req.AutoReconnect,
+		ResumeCommand: req.ResumeCommand,
+		CreatedAt:     existingHost.CreatedAt, // Keep original creation time
+		UpdatedAt:     time.Now(),
+		LastUsed:      existingHost.LastUsed, // Keep last used time
+		HasTOTPSecret: existingHost.HasTOTPSecret && !req.ClearTOTPSecret,
+
+		ForwardAgent:        req.ForwardAgent,
+		ForwardAgentConfirm: req.ForwardAgentConfirm,
+		ForwardX11:          req.ForwardX11,
+		ProxyID:             req.ProxyID,
 	}
 
 	// Encrypt sensitive data
@@ -283,33 +536,262 @@ func (d *Database) UpdateHost(id string, req models.HostCreateRequest) (*models.
 		host.Password = encrypted
 	}
 
-	if req.PrivateKey != "" {
-		encrypted, err := d.encryption.Encrypt(req.PrivateKey)
+	// Keys live in the key store, hosts only reference them
+	keyID, err := d.hostPrivateKeyID(req.PrivateKeyID, req.PrivateKey, req.Label)
+	if err != nil {
+		return nil, err
+	}
+	host.PrivateKeyID = keyID
+
+	if req.TOTPSecret != "" {
+		encrypted, err := d.encryption.Encrypt(req.TOTPSecret)
 		if err != nil {
-			return nil, fmt.Errorf("failed to encrypt private key: %w", err)
+			return nil, fmt.Errorf("failed to encrypt TOTP secret: %w", err)
 		}
-		host.PrivateKey = encrypted
+		host.TOTPSecret = encrypted
+		host.HasTOTPSecret = true
+	}
+
+	if err := d.validateHostProxy(host.ProxyID); err != nil {
+		return nil, err
+	}
+
+	if err := d.validateJumpHosts(id, host.JumpHostIDs); err != nil {
+		return nil, err
 	}
 
 	tagsJSON, _ := json.Marshal(host.Tags)
+	jumpHostsJSON, _ := json.Marshal(host.JumpHostIDs)
 
 	query := `UPDATE hosts SET label = ?, hostname = ?, port = ?, username = ?, auth_method = ?, 
-			  password = ?, private_key = ?, tags = ?, updated_at = ?
+			  password = ?, private_key = CASE WHEN ? IS NULL THEN private_key ELSE NULL END, private_key_id = ?, totp_secret = CASE WHEN ? THEN ? ELSE totp_secret END, tags = ?, jump_host_ids = ?, auto_reconnect = ?, resume_command = ?,
+			  forward_agent = ?, forward_agent_confirm = ?, forward_x11 = ?, proxy_id = ?, updated_at = ?
 			  WHERE id = ?`
 
+	// An empty secret keeps the stored one, the form never gets it back to send again
+	replaceTOTP := req.TOTPSecret != "" || req.ClearTOTPSecret
+
 	_, err = d.db.Exec(query, host.Label, host.Hostname, host.Port, host.Username,
-		host.AuthMethod, host.Password, host.PrivateKey, string(tagsJSON),
-		host.UpdatedAt, id)
+		host.AuthMethod, host.Password, nullIfEmpty(host.PrivateKeyID), nullIfEmpty(host.PrivateKeyID), replaceTOTP, host.TOTPSecret, string(tagsJSON), string(jumpHostsJSON),
+		host.AutoReconnect, host.ResumeCommand, nullIfEmpty(string(host.ForwardAgent)), host.ForwardAgentConfirm, host.ForwardX11, nullIfEmpty(host.ProxyID), host.UpdatedAt, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update host: %w", err)
 	}
 
 	host.Password = ""
 	host.PrivateKey = ""
+	host.TOTPSecret = ""
 
 	return host, nil
 }
 
+// validateJumpHosts makes sure every jump host exists and the chain never loops back to hostID
+func (d *Database) validateJumpHosts(hostID string, jumpHostIDs []string) error {
+	for _, jumpID := range jumpHostIDs {
+		if jumpID == hostID {
+			return fmt.Errorf("host cannot be its own jump host")
+		}
+		jumpHost, err := d.GetHost(jumpID)
+		if err != nil {
+			return fmt.Errorf("failed to get jump host %s: %w", jumpID, err)
+		}
+		if jumpHost == nil {
+			return fmt.Errorf("jump host %s not found", jumpID)
+		}
+		if _, err := d.resolveJumpHosts(jumpHost, map[string]bool{hostID: true}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetJumpHosts returns the flattened jump chain of a host with decrypted credentials,
+// jump hosts that have their own jump hosts are expanded in place
+func (d *Database) GetJumpHosts(host *models.Host) ([]*models.Host, error) {
+	return d.resolveJumpHosts(host, map[string]bool{})
+}
+
+func (d *Database) resolveJumpHosts(host *models.Host, visiting map[string]bool) ([]*models.Host, error) {
+	if visiting[host.ID] {
+		return nil, fmt.Errorf("jump host chain of %s loops back on itself", host.Label)
+	}
+	visiting[host.ID] = true
+	defer delete(visiting, host.ID)
+
+	var chain []*models.Host
+	for _, jumpID := range host.JumpHostIDs {
+		jumpHost, err := d.GetHost(jumpID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get jump host %s: %w", jumpID, err)
+		}
+		if jumpHost == nil {
+			return nil, fmt.Errorf("jump host %s not found", jumpID)
+		}
+
+		nested, err := d.resolveJumpHosts(jumpHost, visiting)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, nested...)
+		chain = append(chain, jumpHost)
+	}
+	return chain, nil
+}
+
+// Port forward operations
+
+func validatePortForward(req models.PortForwardCreateRequest) error {
+	switch req.Type {
+	case models.ForwardLocal, models.ForwardRemote:
+		if req.TargetHost == "" {
+			return fmt.Errorf("target host is required for %s forwards", req.Type)
+		}
+		if req.TargetPort < 1 || req.TargetPort > 65535 {
+			return fmt.Errorf("target port must be between 1 and 65535")
+		}
+	case models.ForwardDynamic:
+	default:
+		return fmt.Errorf("unsupported forward type: %s", req.Type)
+	}
+	if req.BindPort < 0 || req.BindPort > 65535 {
+		return fmt.Errorf("bind port must be between 0 and 65535")
+	}
+	return nil
+}
+
+func (d *Database) CreatePortForward(req models.PortForwardCreateRequest) (*models.PortForward, error) {
+	if err := validatePortForward(req); err != nil {
+		return nil, err
+	}
+
+	forward := &models.PortForward{
+		ID:          uuid.New().String(),
+		HostID:      req.HostID,
+		Label:       req.Label,
+		Type:        req.Type,
+		BindAddress: req.BindAddress,
+		BindPort:    req.BindPort,
+		TargetHost:  req.TargetHost,
+		TargetPort:  req.TargetPort,
+		AutoStart:   req.AutoStart,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	query := `INSERT INTO port_forwards (id, host_id, label, type, bind_address, bind_port, target_host, target_port, auto_start, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := d.db.Exec(query, forward.ID, forward.HostID, forward.Label, forward.Type, forward.BindAddress, forward.BindPort,
+		forward.TargetHost, forward.TargetPort, forward.AutoStart, forward.CreatedAt, forward.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create port forward: %w", err)
+	}
+
+	return forward, nil
+}
+
+func (d *Database) GetPortForwards(hostID string) ([]*models.PortForward, error) {
+	query := `SELECT id, host_id, label, type, bind_address, bind_port, target_host, target_port, auto_start, created_at, updated_at
+			  FROM port_forwards WHERE host_id = ? ORDER BY created_at`
+
+	rows, err := d.db.Query(query, hostID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query port forwards: %w", err)
+	}
+	defer rows.Close()
+
+	var forwards []*models.PortForward
+	for rows.Next() {
+		forward, err := scanPortForward(rows)
+		if err != nil {
+			return nil, err
+		}
+		forwards = append(forwards, forward)
+	}
+
+	return forwards, nil
+}
+
+func (d *Database) GetPortForward(id string) (*models.PortForward, error) {
+	query := `SELECT id, host_id, label, type, bind_address, bind_port, target_host, target_port, auto_start, created_at, updated_at
+			  FROM port_forwards WHERE id = ?`
+
+	forward, err := scanPortForward(d.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return forward, nil
+}
+
+func scanPortForward(row interface{ Scan(...any) error }) (*models.PortForward, error) {
+	forward := &models.PortForward{}
+	var label, bindAddress, targetHost sql.NullString
+	var targetPort sql.NullInt64
+
+	err := row.Scan(&forward.ID, &forward.HostID, &label, &forward.Type, &bindAddress, &forward.BindPort,
+		&targetHost, &targetPort, &forward.AutoStart, &forward.CreatedAt, &forward.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan port forward: %w", err)
+	}
+
+	forward.Label = label.String
+	forward.BindAddress = bindAddress.String
+	forward.TargetHost = targetHost.String
+	forward.TargetPort = int(targetPort.Int64)
+	return forward, nil
+}
+
+func (d *Database) UpdatePortForward(id string, req models.PortForwardCreateRequest) (*models.PortForward, error) {
+	if err := validatePortForward(req); err != nil {
+		return nil, err
+	}
+
+	existing, err := d.GetPortForward(id)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("port forward not found")
+	}
+
+	forward := &models.PortForward{
+		ID:          id,
+		HostID:      existing.HostID,
+		Label:       req.Label,
+		Type:        req.Type,
+		BindAddress: req.BindAddress,
+		BindPort:    req.BindPort,
+		TargetHost:  req.TargetHost,
+		TargetPort:  req.TargetPort,
+		AutoStart:   req.AutoStart,
+		CreatedAt:   existing.CreatedAt,
+		UpdatedAt:   time.Now(),
+	}
+
+	query := `UPDATE port_forwards SET label = ?, type = ?, bind_address = ?, bind_port = ?, target_host = ?, target_port = ?,
+			  auto_start = ?, updated_at = ? WHERE id = ?`
+
+	_, err = d.db.Exec(query, forward.Label, forward.Type, forward.BindAddress, forward.BindPort, forward.TargetHost,
+		forward.TargetPort, forward.AutoStart, forward.UpdatedAt, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update port forward: %w", err)
+	}
+
+	return forward, nil
+}
+
+func (d *Database) DeletePortForward(id string) error {
+	query := `DELETE FROM port_forwards WHERE id = ?`
+	_, err := d.db.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete port forward: %w", err)
+	}
+	return nil
+}
+
 // Macro operations
 
 func (d *Database) CreateMacro(req models.MacroCreateRequest) (*models.Macro, error) {
@@ -403,96 +885,1377 @@ func (d *Database) GetHistory(hostID string, limit int) ([]*models.HistoryEntry,
 // Private Key Management Methods
 
 func (d *Database) CreatePrivateKey(req models.PrivateKeyCreateRequest) (*models.PrivateKey, error) {
-	// Parse the key to get fingerprint and type
-	signer, err := ssh.ParsePrivateKey([]byte(req.KeyData))
+	// Parse the key to get fingerprint and type, encrypted keys are stored as they are
+	publicKey, encrypted, err := parsePrivateKeyInfo(req.KeyData, req.Passphrase)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
+		return nil, err
+	}
+
+	fingerprint := ssh.FingerprintSHA256(publicKey)
+	keyType := publicKey.Type()
+
+	certificate := strings.TrimSpace(req.Certificate)
+	if certificate != "" {
+		if _, err := parseUserCertificate(certificate, publicKey); err != nil {
+			return nil, err
+		}
 	}
 
-	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
-	keyType := signer.PublicKey().Type()
+	authorizedKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))
+	if comment := strings.TrimSpace(req.Comment); comment != "" {
+		authorizedKey += " " + comment
+	}
 
 	privateKey := &models.PrivateKey{
 		ID:          uuid.New().String(),
 		Name:        req.Name,
 		Fingerprint: fingerprint,
 		KeyType:     keyType,
+		Encrypted:   encrypted,
+		Certificate: certificate,
+		PublicKey:   authorizedKey,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
-	encrypted, err := d.encryption.Encrypt(req.KeyData)
+	encryptedData, err := d.encryption.Encrypt(req.KeyData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to encrypt private key: %w", err)
 	}
-	privateKey.KeyData = encrypted
+	privateKey.KeyData = encryptedData
 
-	query := `INSERT INTO private_keys (id, name, fingerprint, key_type, key_data, created_at, updated_at)
-			  VALUES (?, ?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO private_keys (id, name, fingerprint, key_type, key_data, encrypted, certificate, public_key, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err = d.db.Exec(query, privateKey.ID, privateKey.Name, privateKey.Fingerprint,
-		privateKey.KeyType, privateKey.KeyData, privateKey.CreatedAt, privateKey.UpdatedAt)
+	_, err = d.db.Exec(query, privateKey.ID, privateKey.Name, privateKey.Fingerprint, privateKey.KeyType, privateKey.KeyData,
+		privateKey.Encrypted, nullIfEmpty(privateKey.Certificate), privateKey.PublicKey, privateKey.CreatedAt, privateKey.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create private key: %w", err)
 	}
 
+	if encrypted && req.RememberPassphrase && req.Passphrase != "" {
+		if err := d.SaveKeyPassphrase(req.KeyData, req.Passphrase); err != nil {
+			return nil, err
+		}
+	}
+
 	privateKey.KeyData = ""
 	return privateKey, nil
 }
 
-func (d *Database) GetPrivateKeys() ([]*models.PrivateKeyInfo, error) {
-	query := `SELECT id, name, fingerprint, key_type, created_at
-			  FROM private_keys ORDER BY created_at DESC`
+// parsePrivateKeyInfo returns the public key of a private key and whether it is encrypted
+func parsePrivateKeyInfo(keyData, passphrase string) (ssh.PublicKey, bool, error) {
+	signer, err := ssh.ParsePrivateKey([]byte(keyData))
+	if err == nil {
+		return signer.PublicKey(), false, nil
+	}
 
-	rows, err := d.db.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query private keys: %w", err)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, false, fmt.Errorf("failed to parse private key: %w", err)
 	}
-	defer rows.Close()
 
-	var keys []*models.PrivateKeyInfo
-	for rows.Next() {
-		key := &models.PrivateKeyInfo{}
-		err := rows.Scan(&key.ID, &key.Name, &key.Fingerprint, &key.KeyType, &key.CreatedAt)
+	if passphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase([]byte(keyData), []byte(passphrase))
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan private key: %w", err)
+			return nil, true, fmt.Errorf("failed to decrypt private key: %w", err)
 		}
-		keys = append(keys, key)
+		return signer.PublicKey(), true, nil
 	}
-
-	return keys, nil
+	if missing.PublicKey != nil {
+		return missing.PublicKey, true, nil
+	}
+	// Legacy PEM keys encrypt the public part as well
+	return nil, true, fmt.Errorf("private key is encrypted, enter its passphrase to import it")
 }
 
-func (d *Database) GetPrivateKey(id string) (*models.PrivateKey, error) {
-	query := `SELECT id, name, fingerprint, key_type, key_data, created_at, updated_at
-			  FROM private_keys WHERE id = ?`
-
-	key := &models.PrivateKey{}
-	var keyData string
-
-	err := d.db.QueryRow(query, id).Scan(&key.ID, &key.Name, &key.Fingerprint,
-		&key.KeyType, &keyData, &key.CreatedAt, &key.UpdatedAt)
+// parseUserCertificate parses an OpenSSH user certificate and checks it was signed for publicKey
+func parseUserCertificate(text string, publicKey ssh.PublicKey) (*ssh.Certificate, error) {
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(text))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("failed to get private key: %w", err)
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
 	}
-
+	cert, ok := parsed.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("not an OpenSSH certificate, expected the contents of a -cert.pub file")
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, errors.New("certificate is a host certificate, not a user certificate")
+	}
+	if publicKey != nil && ssh.FingerprintSHA256(cert.Key) != ssh.FingerprintSHA256(publicKey) {
+		return nil, errors.New("certificate was not signed for this private key")
+	}
+	return cert, nil
+}
+
+// certificateInfo summarizes a certificate for the key list
+func certificateInfo(cert *ssh.Certificate) *models.CertificateInfo {
+	info := &models.CertificateInfo{
+		KeyID:         cert.KeyId,
+		Serial:        cert.Serial,
+		Principals:    cert.ValidPrincipals,
+		ValidAfter:    time.Unix(int64(cert.ValidAfter), 0),
+		CAFingerprint: ssh.FingerprintSHA256(cert.SignatureKey),
+	}
+	if info.Principals == nil {
+		info.Principals = []string{}
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity {
+		validBefore := time.Unix(int64(cert.ValidBefore), 0)
+		info.ValidBefore = &validBefore
+		info.Expired = time.Now().After(validBefore)
+	}
+	return info
+}
+
+// SetPrivateKeyCertificate attaches a renewed user certificate to a stored key, an empty one detaches it
+func (d *Database) SetPrivateKeyCertificate(id, certificate string) (*models.PrivateKeyInfo, error) {
+	key, err := d.GetPrivateKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("private key not found")
+	}
+
+	certificate = strings.TrimSpace(certificate)
+	info := &models.PrivateKeyInfo{
+		ID:          key.ID,
+		Name:        key.Name,
+		Fingerprint: key.Fingerprint,
+		KeyType:     key.KeyType,
+		Encrypted:   key.Encrypted,
+		CreatedAt:   key.CreatedAt,
+	}
+	if certificate != "" {
+		publicKey, _, err := parsePrivateKeyInfo(key.KeyData, "")
+		if err != nil {
+			// Legacy encrypted PEM keys hide their public key, the certificate cannot be matched
+			publicKey = nil
+		}
+		cert, err := parseUserCertificate(certificate, publicKey)
+		if err != nil {
+			return nil, err
+		}
+		info.Certificate = certificateInfo(cert)
+	}
+
+	query := `UPDATE private_keys SET certificate = ?, updated_at = ? WHERE id = ?`
+	if _, err := d.db.Exec(query, nullIfEmpty(certificate), time.Now(), id); err != nil {
+		return nil, fmt.Errorf("failed to update certificate: %w", err)
+	}
+	return info, nil
+}
+
+func (d *Database) GetPrivateKeys() ([]*models.PrivateKeyInfo, error) {
+	query := `SELECT id, name, fingerprint, key_type, encrypted, certificate, created_at
+			  FROM private_keys ORDER BY created_at DESC`
+
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query private keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*models.PrivateKeyInfo
+	for rows.Next() {
+		key := &models.PrivateKeyInfo{}
+		var certificate sql.NullString
+		err := rows.Scan(&key.ID, &key.Name, &key.Fingerprint, &key.KeyType, &key.Encrypted, &certificate, &key.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan private key: %w", err)
+		}
+		if certificate.String != "" {
+			if cert, err := parseUserCertificate(certificate.String, nil); err == nil {
+				key.Certificate = certificateInfo(cert)
+			} else {
+				log.Printf("DATABASE - Ignoring invalid certificate of key %s: %v", key.ID, err)
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (d *Database) GetPrivateKey(id string) (*models.PrivateKey, error) {
+	query := `SELECT id, name, fingerprint, key_type, key_data, encrypted, certificate, public_key, created_at, updated_at
+			  FROM private_keys WHERE id = ?`
+
+	key := &models.PrivateKey{}
+	var keyData string
+	var certificate, publicKey sql.NullString
+
+	err := d.db.QueryRow(query, id).Scan(&key.ID, &key.Name, &key.Fingerprint,
+		&key.KeyType, &keyData, &key.Encrypted, &certificate, &publicKey, &key.CreatedAt, &key.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get private key: %w", err)
+	}
+
 	decrypted, err := d.encryption.Decrypt(keyData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt private key: %w", err)
 	}
 	key.KeyData = decrypted
+	key.Certificate = certificate.String
+	key.PublicKey = publicKey.String
 
 	return key, nil
 }
 
+// GetPublicKey returns the public key of a stored key in authorized_keys format.
+// Keys imported before public keys were stored get theirs derived from the key data.
+func (d *Database) GetPublicKey(id string) (string, error) {
+	key, err := d.GetPrivateKey(id)
+	if err != nil {
+		return "", err
+	}
+	if key == nil {
+		return "", fmt.Errorf("private key not found")
+	}
+	if key.PublicKey != "" {
+		return key.PublicKey, nil
+	}
+
+	publicKey, _, err := parsePrivateKeyInfo(key.KeyData, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to read public key: %w", err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))), nil
+}
+
+// SetHostPrivateKey switches a host to key authentication with a stored key
+func (d *Database) SetHostPrivateKey(hostID, keyID string) error {
+	query := `UPDATE hosts SET auth_method = ?, private_key = NULL, private_key_id = ?, updated_at = ? WHERE id = ?`
+	result, err := d.db.Exec(query, models.AuthPrivateKey, keyID, time.Now(), hostID)
+	if err != nil {
+		return fmt.Errorf("failed to update host: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return fmt.Errorf("host not found")
+	}
+	return nil
+}
+
+// GetPrivateKeyHosts returns the labels of the hosts that use a stored key
+func (d *Database) GetPrivateKeyHosts(id string) ([]string, error) {
+	rows, err := d.db.Query(`SELECT label FROM hosts WHERE private_key_id = ? ORDER BY label`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hosts using private key: %w", err)
+	}
+	defer rows.Close()
+
+	labels := []string{}
+	for rows.Next() {
+		var label string
+		if err := rows.Scan(&label); err != nil {
+			return nil, fmt.Errorf("failed to scan host: %w", err)
+		}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
 func (d *Database) DeletePrivateKey(id string) error {
+	// Refuse to leave hosts without their key
+	users, err := d.GetPrivateKeyHosts(id)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return fmt.Errorf("private key is used by: %s", strings.Join(users, ", "))
+	}
+
+	key, err := d.GetPrivateKey(id)
+	if err != nil {
+		return err
+	}
+	if key != nil && key.Encrypted {
+		if _, err := d.db.Exec(`DELETE FROM key_passphrases WHERE key_id = ?`, KeyDataID(key.KeyData)); err != nil {
+			return fmt.Errorf("failed to delete key passphrase: %w", err)
+		}
+	}
+
 	query := `DELETE FROM private_keys WHERE id = ?`
-	_, err := d.db.Exec(query, id)
+	_, err = d.db.Exec(query, id)
 	if err != nil {
 		return fmt.Errorf("failed to delete private key: %w", err)
 	}
 	return nil
 }
+
+// hostPrivateKeyID returns the stored key a host should reference. Raw key data from older
+// clients is imported into the key store, reusing an existing entry with the same data.
+func (d *Database) hostPrivateKeyID(keyID, keyData, hostLabel string) (string, error) {
+	if keyID != "" {
+		var exists int
+		if err := d.db.QueryRow(`SELECT COUNT(*) FROM private_keys WHERE id = ?`, keyID).Scan(&exists); err != nil {
+			return "", fmt.Errorf("failed to check private key: %w", err)
+		}
+		if exists == 0 {
+			return "", fmt.Errorf("private key %s not found", keyID)
+		}
+		return keyID, nil
+	}
+
+	if strings.TrimSpace(keyData) == "" {
+		return "", nil
+	}
+
+	return d.FindOrCreatePrivateKey(hostLabel+" key", keyData)
+}
+
+// FindOrCreatePrivateKey returns the ID of the stored key with the same data, adding it under name if there is none
+func (d *Database) FindOrCreatePrivateKey(name, keyData string) (string, error) {
+	existingID, err := d.findPrivateKeyByData(keyData)
+	if err != nil {
+		return "", err
+	}
+	if existingID != "" {
+		return existingID, nil
+	}
+
+	key, err := d.CreatePrivateKey(models.PrivateKeyCreateRequest{
+		Name:    name,
+		KeyData: keyData,
+	})
+	if err != nil {
+		return "", err
+	}
+	return key.ID, nil
+}
+
+// findPrivateKeyByData returns the ID of the stored key with the same data, or ""
+func (d *Database) findPrivateKeyByData(keyData string) (string, error) {
+	rows, err := d.db.Query(`SELECT id, key_data FROM private_keys`)
+	if err != nil {
+		return "", fmt.Errorf("failed to query private keys: %w", err)
+	}
+	defer rows.Close()
+
+	want := KeyDataID(keyData)
+	for rows.Next() {
+		var id, encrypted string
+		if err := rows.Scan(&id, &encrypted); err != nil {
+			return "", fmt.Errorf("failed to scan private key: %w", err)
+		}
+		stored, err := d.encryption.Decrypt(encrypted)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt private key: %w", err)
+		}
+		if KeyDataID(stored) == want {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+// MigrateInlineKeys moves private keys stored inline on hosts into the key store.
+// It needs the vault key, so it runs after unlocking rather than in migrate.
+func (d *Database) MigrateInlineKeys() error {
+	rows, err := d.db.Query(`SELECT id, label, private_key FROM hosts WHERE private_key IS NOT NULL AND private_key != ''`)
+	if err != nil {
+		return fmt.Errorf("failed to query inline keys: %w", err)
+	}
+	type inlineKey struct{ hostID, label, encrypted string }
+	var keys []inlineKey
+	for rows.Next() {
+		var key inlineKey
+		if err := rows.Scan(&key.hostID, &key.label, &key.encrypted); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan inline key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+
+	for _, key := range keys {
+		keyData, err := d.encryption.Decrypt(key.encrypted)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt private key of %s: %w", key.label, err)
+		}
+
+		keyID, err := d.hostPrivateKeyID("", keyData, key.label)
+		if err != nil {
+			// Keys we cannot import keep working inline
+			log.Printf("DATABASE - Leaving private key of %s inline: %v", key.label, err)
+			continue
+		}
+
+		if _, err := d.db.Exec(`UPDATE hosts SET private_key_id = ?, private_key = NULL WHERE id = ?`, keyID, key.hostID); err != nil {
+			return fmt.Errorf("failed to link private key of %s: %w", key.label, err)
+		}
+		log.Printf("DATABASE - Moved private key of %s into the key store", key.label)
+	}
+	return nil
+}
+
+func nullIfEmpty(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// KeyDataID identifies a private key by its PEM data, which also works for encrypted
+// keys whose public key is not readable without the passphrase
+func KeyDataID(keyData string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(keyData)))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetKeyPassphrase returns the remembered passphrase of a private key, or "" if there is none
+func (d *Database) GetKeyPassphrase(keyData string) (string, error) {
+	var encrypted string
+	err := d.db.QueryRow(`SELECT passphrase FROM key_passphrases WHERE key_id = ?`, KeyDataID(keyData)).Scan(&encrypted)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get key passphrase: %w", err)
+	}
+
+	passphrase, err := d.encryption.Decrypt(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt key passphrase: %w", err)
+	}
+	return passphrase, nil
+}
+
+// SaveKeyPassphrase remembers the passphrase of a private key in the vault
+func (d *Database) SaveKeyPassphrase(keyData, passphrase string) error {
+	encrypted, err := d.encryption.Encrypt(passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt key passphrase: %w", err)
+	}
+
+	query := `INSERT INTO key_passphrases (key_id, passphrase, created_at) VALUES (?, ?, ?)
+			  ON CONFLICT(key_id) DO UPDATE SET passphrase = excluded.passphrase`
+	if _, err := d.db.Exec(query, KeyDataID(keyData), encrypted, time.Now()); err != nil {
+		return fmt.Errorf("failed to save key passphrase: %w", err)
+	}
+	return nil
+}
+
+// ForgetKeyPassphrases removes every remembered key passphrase
+func (d *Database) ForgetKeyPassphrases() error {
+	if _, err := d.db.Exec(`DELETE FROM key_passphrases`); err != nil {
+		return fmt.Errorf("failed to delete key passphrases: %w", err)
+	}
+	return nil
+}
+
+// secretColumns lists every column encrypted with the vault key
+var secretColumns = []struct {
+	table  string
+	key    string
+	column string
+}{
+	{"hosts", "id", "password"},
+	{"hosts", "id", "private_key"},
+	{"hosts", "id", "totp_secret"},
+	{"private_keys", "id", "key_data"},
+	{"key_passphrases", "key_id", "passphrase"},
+	{"proxies", "id", "password"},
+}
+
+// Vault operations
+
+// getVaultState returns the stored salt and vault settings, found is false before the vault
+// was ever stored. A salt without settings is an installation from before the master password.
+func (d *Database) getVaultState() (salt []byte, meta *vaultMeta, found bool, err error) {
+	var verifier sql.NullString
+	var autoLock int
+	err = d.db.QueryRow(`SELECT salt, verifier, auto_lock_minutes FROM vault WHERE id = 1`).Scan(&salt, &verifier, &autoLock)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, false, nil
+		}
+		return nil, nil, false, fmt.Errorf("failed to read vault: %w", err)
+	}
+	if verifier.Valid {
+		meta = &vaultMeta{Verifier: verifier.String, AutoLockMinutes: autoLock}
+	}
+	return salt, meta, true, nil
+}
+
+// saveVaultState stores the salt and vault settings, meta is nil while there is no master
+// password
+func saveVaultState(exec interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}, salt []byte, meta *vaultMeta) error {
+	var verifier any
+	autoLock := defaultAutoLockMinutes
+	if meta != nil {
+		verifier = meta.Verifier
+		autoLock = meta.AutoLockMinutes
+	}
+	query := `INSERT INTO vault (id, salt, verifier, auto_lock_minutes) VALUES (1, ?, ?, ?)
+			  ON CONFLICT(id) DO UPDATE SET salt = excluded.salt, verifier = excluded.verifier,
+			  auto_lock_minutes = excluded.auto_lock_minutes`
+	if _, err := exec.Exec(query, salt, verifier, autoLock); err != nil {
+		return fmt.Errorf("failed to save vault: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) setVaultAutoLock(minutes int) error {
+	if _, err := d.db.Exec(`UPDATE vault SET auto_lock_minutes = ? WHERE id = 1`, minutes); err != nil {
+		return fmt.Errorf("failed to save vault: %w", err)
+	}
+	return nil
+}
+
+// rekeyVault stores a new salt and verifier and moves every secret column from from to to,
+// all in one transaction so the secrets always match the stored salt. from is nil if there
+// is nothing to re-encrypt.
+func (d *Database) rekeyVault(from, to *EncryptionService, salt []byte, meta *vaultMeta) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	count := 0
+	if from != nil {
+		if count, err = reEncryptSecrets(tx, from, to); err != nil {
+			return err
+		}
+	}
+	if err := saveVaultState(tx, salt, meta); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit re-encryption: %w", err)
+	}
+	log.Printf("DATABASE - Re-encrypted %d secrets", count)
+	return nil
+}
+
+// reEncryptSecrets decrypts every secret column with from and encrypts it with to
+func reEncryptSecrets(tx *sql.Tx, from, to *EncryptionService) (int, error) {
+	count := 0
+	for _, c := range secretColumns {
+		rows, err := tx.Query(fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s IS NOT NULL AND %s != ''`,
+			c.key, c.column, c.table, c.column, c.column))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s.%s: %w", c.table, c.column, err)
+		}
+
+		type secret struct{ key, value string }
+		var secrets []secret
+		for rows.Next() {
+			var row secret
+			if err := rows.Scan(&row.key, &row.value); err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("failed to scan %s.%s: %w", c.table, c.column, err)
+			}
+			secrets = append(secrets, row)
+		}
+		rows.Close()
+
+		for _, row := range secrets {
+			plaintext, err := from.Decrypt(row.value)
+			if err != nil {
+				return 0, fmt.Errorf("failed to decrypt %s.%s of %s: %w", c.table, c.column, row.key, err)
+			}
+			encrypted, err := to.Encrypt(plaintext)
+			if err != nil {
+				return 0, fmt.Errorf("failed to encrypt %s.%s of %s: %w", c.table, c.column, row.key, err)
+			}
+			if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = ? WHERE %s = ?`, c.table, c.column, c.key), encrypted, row.key); err != nil {
+				return 0, fmt.Errorf("failed to update %s.%s of %s: %w", c.table, c.column, row.key, err)
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Known host operations
+
+// GetKnownHosts returns the trusted host keys in the order they were added
+func (d *Database) GetKnownHosts() ([]*models.KnownHost, error) {
+	query := `SELECT id, marker, hosts, algorithm, fingerprint, public_key, comment, accepted_by, first_seen, created_at, updated_at
+			  FROM known_hosts ORDER BY created_at, id`
+
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query known hosts: %w", err)
+	}
+	defer rows.Close()
+
+	var knownHosts []*models.KnownHost
+	for rows.Next() {
+		knownHost := &models.KnownHost{}
+		var marker, comment, acceptedBy sql.NullString
+		err := rows.Scan(&knownHost.ID, &marker, &knownHost.Hosts, &knownHost.Algorithm, &knownHost.Fingerprint,
+			&knownHost.PublicKey, &comment, &acceptedBy, &knownHost.FirstSeen, &knownHost.CreatedAt, &knownHost.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan known host: %w", err)
+		}
+		knownHost.Marker = marker.String
+		knownHost.Comment = comment.String
+		knownHost.AcceptedBy = acceptedBy.String
+		knownHosts = append(knownHosts, knownHost)
+	}
+
+	return knownHosts, nil
+}
+
+// SaveKnownHosts deletes and inserts or updates known host rows in one transaction.
+// Rows without an ID are created.
+func (d *Database) SaveKnownHosts(deleted []string, saved []*models.KnownHost) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, id := range deleted {
+		if _, err := tx.Exec(`DELETE FROM known_hosts WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("failed to delete known host: %w", err)
+		}
+	}
+
+	query := `INSERT INTO known_hosts (id, marker, hosts, algorithm, fingerprint, public_key, comment, accepted_by, first_seen, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			  ON CONFLICT(id) DO UPDATE SET marker = excluded.marker, hosts = excluded.hosts, algorithm = excluded.algorithm,
+			  fingerprint = excluded.fingerprint, public_key = excluded.public_key, comment = excluded.comment,
+			  updated_at = excluded.updated_at`
+
+	now := time.Now()
+	for _, knownHost := range saved {
+		if knownHost.ID == "" {
+			knownHost.ID = uuid.New().String()
+			knownHost.CreatedAt = now
+			if knownHost.FirstSeen.IsZero() {
+				knownHost.FirstSeen = now
+			}
+		}
+		knownHost.UpdatedAt = now
+
+		_, err := tx.Exec(query, knownHost.ID, nullIfEmpty(knownHost.Marker), knownHost.Hosts, knownHost.Algorithm,
+			knownHost.Fingerprint, knownHost.PublicKey, nullIfEmpty(knownHost.Comment), nullIfEmpty(knownHost.AcceptedBy),
+			knownHost.FirstSeen, knownHost.CreatedAt, knownHost.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save known host: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit known hosts: %w", err)
+	}
+	return nil
+}
+
+// Host CA operations
+
+// parseHostCARequest validates a host CA and returns its public key in authorized_keys format
+func parseHostCARequest(req models.HostCACreateRequest) (ssh.PublicKey, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.TrimSpace(req.PublicKey)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA public key: %w", err)
+	}
+	if _, ok := publicKey.(*ssh.Certificate); ok {
+		return nil, fmt.Errorf("expected the CA public key, not a certificate")
+	}
+	return publicKey, nil
+}
+
+func (d *Database) CreateHostCA(req models.HostCACreateRequest) (*models.HostCA, error) {
+	publicKey, err := parseHostCARequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	ca := &models.HostCA{
+		ID:          uuid.New().String(),
+		Name:        strings.TrimSpace(req.Name),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))),
+		Fingerprint: ssh.FingerprintSHA256(publicKey),
+		Tags:        req.Tags,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if ca.Tags == nil {
+		ca.Tags = []string{}
+	}
+
+	tagsJSON, _ := json.Marshal(ca.Tags)
+	query := `INSERT INTO host_cas (id, name, public_key, fingerprint, tags, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?)`
+
+	_, err = d.db.Exec(query, ca.ID, ca.Name, ca.PublicKey, ca.Fingerprint, string(tagsJSON), ca.CreatedAt, ca.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create host CA: %w", err)
+	}
+
+	return ca, nil
+}
+
+func (d *Database) GetHostCAs() ([]*models.HostCA, error) {
+	query := `SELECT id, name, public_key, fingerprint, tags, created_at, updated_at
+			  FROM host_cas ORDER BY name`
+
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query host CAs: %w", err)
+	}
+	defer rows.Close()
+
+	cas := []*models.HostCA{}
+	for rows.Next() {
+		ca := &models.HostCA{}
+		var tagsJSON sql.NullString
+		err := rows.Scan(&ca.ID, &ca.Name, &ca.PublicKey, &ca.Fingerprint, &tagsJSON, &ca.CreatedAt, &ca.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan host CA: %w", err)
+		}
+		ca.Tags = []string{}
+		if tagsJSON.Valid {
+			json.Unmarshal([]byte(tagsJSON.String), &ca.Tags)
+		}
+		cas = append(cas, ca)
+	}
+
+	return cas, nil
+}
+
+func (d *Database) UpdateHostCA(id string, req models.HostCACreateRequest) (*models.HostCA, error) {
+	publicKey, err := parseHostCARequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := req.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	tagsJSON, _ := json.Marshal(tags)
+
+	query := `UPDATE host_cas SET name = ?, public_key = ?, fingerprint = ?, tags = ?, updated_at = ? WHERE id = ?`
+	result, err := d.db.Exec(query, strings.TrimSpace(req.Name), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))),
+		ssh.FingerprintSHA256(publicKey), string(tagsJSON), time.Now(), id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update host CA: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, fmt.Errorf("host CA not found")
+	}
+
+	cas, err := d.GetHostCAs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ca := range cas {
+		if ca.ID == id {
+			return ca, nil
+		}
+	}
+	return nil, fmt.Errorf("host CA not found")
+}
+
+func (d *Database) DeleteHostCA(id string) error {
+	if _, err := d.db.Exec(`DELETE FROM host_cas WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete host CA: %w", err)
+	}
+	return nil
+}
+
+// Proxy operations
+
+// validateProxyRequest checks that a proxy has what its type needs to connect
+func validateProxyRequest(req models.ProxyCreateRequest) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch req.Type {
+	case models.ProxySOCKS5, models.ProxyHTTP:
+		if strings.TrimSpace(req.Host) == "" {
+			return fmt.Errorf("proxy host is required")
+		}
+		if req.Port <= 0 || req.Port > 65535 {
+			return fmt.Errorf("invalid proxy port %d", req.Port)
+		}
+	case models.ProxyCommand:
+		if strings.TrimSpace(req.Command) == "" {
+			return fmt.Errorf("proxy command is required")
+		}
+	default:
+		return fmt.Errorf("unknown proxy type: %s", req.Type)
+	}
+	return nil
+}
+
+func (d *Database) CreateProxy(req models.ProxyCreateRequest) (*models.Proxy, error) {
+	if err := validateProxyRequest(req); err != nil {
+		return nil, err
+	}
+
+	proxy := &models.Proxy{
+		ID:        uuid.New().String(),
+		Name:      strings.TrimSpace(req.Name),
+		Type:      req.Type,
+		Host:      strings.TrimSpace(req.Host),
+		Port:      req.Port,
+		Username:  req.Username,
+		Command:   strings.TrimSpace(req.Command),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	var password string
+	if req.Password != "" {
+		encrypted, err := d.encryption.Encrypt(req.Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt proxy password: %w", err)
+		}
+		password = encrypted
+	}
+
+	query := `INSERT INTO proxies (id, name, type, host, port, username, password, command, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := d.db.Exec(query, proxy.ID, proxy.Name, proxy.Type, nullIfEmpty(proxy.Host), proxy.Port, nullIfEmpty(proxy.Username),
+		nullIfEmpty(password), nullIfEmpty(proxy.Command), proxy.CreatedAt, proxy.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proxy: %w", err)
+	}
+
+	return proxy, nil
+}
+
+// proxyColumns are selected by every proxy query, the password is added by GetProxy only
+const proxyColumns = `id, name, type, host, port, username, command, created_at, updated_at`
+
+func scanProxy(row interface{ Scan(...any) error }, extra ...any) (*models.Proxy, error) {
+	proxy := &models.Proxy{}
+	var host, username, command sql.NullString
+	var port sql.NullInt64
+
+	dest := []any{&proxy.ID, &proxy.Name, &proxy.Type, &host, &port, &username, &command, &proxy.CreatedAt, &proxy.UpdatedAt}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	proxy.Host = host.String
+	proxy.Port = int(port.Int64)
+	proxy.Username = username.String
+	proxy.Command = command.String
+	return proxy, nil
+}
+
+func (d *Database) GetProxies() ([]*models.Proxy, error) {
+	rows, err := d.db.Query(`SELECT ` + proxyColumns + ` FROM proxies ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query proxies: %w", err)
+	}
+	defer rows.Close()
+
+	proxies := []*models.Proxy{}
+	for rows.Next() {
+		proxy, err := scanProxy(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan proxy: %w", err)
+		}
+		proxies = append(proxies, proxy)
+	}
+
+	return proxies, nil
+}
+
+// GetProxy retrieves a proxy by ID with its decrypted password
+func (d *Database) GetProxy(id string) (*models.Proxy, error) {
+	var password sql.NullString
+
+	proxy, err := scanProxy(d.db.QueryRow(`SELECT `+proxyColumns+`, password FROM proxies WHERE id = ?`, id), &password)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get proxy: %w", err)
+	}
+
+	if password.Valid && password.String != "" {
+		decrypted, err := d.encryption.Decrypt(password.String)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt proxy password: %w", err)
+		}
+		proxy.Password = decrypted
+	}
+
+	return proxy, nil
+}
+
+// UpdateProxy replaces a proxy, an empty password keeps the stored one unless the username is cleared
+func (d *Database) UpdateProxy(id string, req models.ProxyCreateRequest) (*models.Proxy, error) {
+	if err := validateProxyRequest(req); err != nil {
+		return nil, err
+	}
+
+	existing, err := d.GetProxy(id)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("proxy not found")
+	}
+
+	password := req.Password
+	if password == "" && req.Username != "" {
+		password = existing.Password
+	}
+	var encrypted string
+	if password != "" {
+		if encrypted, err = d.encryption.Encrypt(password); err != nil {
+			return nil, fmt.Errorf("failed to encrypt proxy password: %w", err)
+		}
+	}
+
+	proxy := &models.Proxy{
+		ID:        id,
+		Name:      strings.TrimSpace(req.Name),
+		Type:      req.Type,
+		Host:      strings.TrimSpace(req.Host),
+		Port:      req.Port,
+		Username:  req.Username,
+		Command:   strings.TrimSpace(req.Command),
+		CreatedAt: existing.CreatedAt,
+		UpdatedAt: time.Now(),
+	}
+
+	query := `UPDATE proxies SET name = ?, type = ?, host = ?, port = ?, username = ?, password = ?, command = ?, updated_at = ?
+			  WHERE id = ?`
+	_, err = d.db.Exec(query, proxy.Name, proxy.Type, nullIfEmpty(proxy.Host), proxy.Port, nullIfEmpty(proxy.Username),
+		nullIfEmpty(encrypted), nullIfEmpty(proxy.Command), proxy.UpdatedAt, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update proxy: %w", err)
+	}
+
+	return proxy, nil
+}
+
+// GetProxyHosts returns the labels of the hosts that use a proxy explicitly
+func (d *Database) GetProxyHosts(id string) ([]string, error) {
+	rows, err := d.db.Query(`SELECT label FROM hosts WHERE proxy_id = ? ORDER BY label`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hosts using proxy: %w", err)
+	}
+	defer rows.Close()
+
+	labels := []string{}
+	for rows.Next() {
+		var label string
+		if err := rows.Scan(&label); err != nil {
+			return nil, fmt.Errorf("failed to scan host: %w", err)
+		}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+func (d *Database) DeleteProxy(id string) error {
+	// Refuse to silently move hosts to the default proxy
+	users, err := d.GetProxyHosts(id)
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return fmt.Errorf("proxy is used by: %s", strings.Join(users, ", "))
+	}
+
+	if _, err := d.db.Exec(`DELETE FROM proxies WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete proxy: %w", err)
+	}
+	return nil
+}
+
+// validateHostProxy checks that a host references an existing proxy, if any
+func (d *Database) validateHostProxy(proxyID string) error {
+	if proxyID == "" || proxyID == models.ProxyDirect {
+		return nil
+	}
+	var exists int
+	if err := d.db.QueryRow(`SELECT COUNT(*) FROM proxies WHERE id = ?`, proxyID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check proxy: %w", err)
+	}
+	if exists == 0 {
+		return fmt.Errorf("proxy %s not found", proxyID)
+	}
+	return nil
+}
+
+// Transfer operations
+
+func (d *Database) CreateTransfer(req models.TransferCreateRequest) (*models.Transfer, error) {
+	if req.HostID == "" {
+		return nil, fmt.Errorf("host is required")
+	}
+	if req.Direction != models.TransferUpload && req.Direction != models.TransferDownload {
+		return nil, fmt.Errorf("unknown transfer direction: %s", req.Direction)
+	}
+	if req.LocalPath == "" || req.RemotePath == "" {
+		return nil, fmt.Errorf("local and remote path are required")
+	}
+	switch req.Symlinks {
+	case "", models.SymlinksFollow, models.SymlinksPreserve, models.SymlinksSkip:
+	default:
+		return nil, fmt.Errorf("unknown symlink policy: %s", req.Symlinks)
+	}
+	switch req.Conflict {
+	case "", models.ConflictOverwrite, models.ConflictSkip, models.ConflictKeepBoth, models.ConflictNewer, models.ConflictSizeDiffers:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy: %s", req.Conflict)
+	}
+
+	transfer := &models.Transfer{
+		ID:         uuid.New().String(),
+		HostID:     req.HostID,
+		ParentID:   req.ParentID,
+		Direction:  req.Direction,
+		LocalPath:  req.LocalPath,
+		RemotePath: req.RemotePath,
+		IsDir:      req.IsDir,
+		Symlinks:   req.Symlinks,
+		Conflict:   req.Conflict,
+		Status:     models.TransferQueued,
+		Size:       req.Size,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+		ETASeconds: -1,
+	}
+
+	query := `INSERT INTO transfers (id, host_id, parent_id, direction, local_path, remote_path, is_dir, symlinks, conflict, status, size, transferred, attempts, error, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := d.db.Exec(query, transfer.ID, transfer.HostID, nullIfEmpty(transfer.ParentID), transfer.Direction,
+		transfer.LocalPath, transfer.RemotePath, transfer.IsDir, nullIfEmpty(string(transfer.Symlinks)),
+		nullIfEmpty(string(transfer.Conflict)), transfer.Status, transfer.Size, transfer.Transferred, transfer.Attempts, nullIfEmpty(transfer.Error),
+		transfer.CreatedAt, transfer.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transfer: %w", err)
+	}
+
+	return transfer, nil
+}
+
+// UpdateTransfer stores the status and progress of a transfer and its paths, which change when
+// a conflict is resolved by keeping both files
+func (d *Database) UpdateTransfer(transfer *models.Transfer) error {
+	transfer.UpdatedAt = time.Now()
+	query := `UPDATE transfers SET local_path = ?, remote_path = ?, status = ?, size = ?, transferred = ?, attempts = ?,
+			  error = ?, updated_at = ? WHERE id = ?`
+
+	_, err := d.db.Exec(query, transfer.LocalPath, transfer.RemotePath, transfer.Status, transfer.Size,
+		transfer.Transferred, transfer.Attempts, nullIfEmpty(transfer.Error), transfer.UpdatedAt, transfer.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update transfer: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) GetTransfers() ([]*models.Transfer, error) {
+	query := `SELECT id, host_id, parent_id, direction, local_path, remote_path, is_dir, symlinks, conflict, status, size, transferred,
+			  attempts, error, created_at, updated_at
+			  FROM transfers ORDER BY created_at`
+
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transfers: %w", err)
+	}
+	defer rows.Close()
+
+	var transfers []*models.Transfer
+	for rows.Next() {
+		transfer := &models.Transfer{ETASeconds: -1}
+		var parentID, symlinks, conflict, transferError sql.NullString
+		err := rows.Scan(&transfer.ID, &transfer.HostID, &parentID, &transfer.Direction, &transfer.LocalPath,
+			&transfer.RemotePath, &transfer.IsDir, &symlinks, &conflict, &transfer.Status, &transfer.Size, &transfer.Transferred,
+			&transfer.Attempts, &transferError, &transfer.CreatedAt, &transfer.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan transfer: %w", err)
+		}
+		transfer.ParentID = parentID.String
+		transfer.Symlinks = models.SymlinkPolicy(symlinks.String)
+		transfer.Conflict = models.ConflictPolicy(conflict.String)
+		transfer.Error = transferError.String
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, nil
+}
+
+func (d *Database) DeleteTransfer(id string) error {
+	if _, err := d.db.Exec(`DELETE FROM transfers WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete transfer: %w", err)
+	}
+	return nil
+}
+
+// Sync profile operations
+
+func validateSyncProfile(req models.SyncProfileCreateRequest) error {
+	if strings.TrimSpace(req.Name) == "" {
+		return fmt.Errorf("name is required")
+	}
+	if req.LocalPath == "" || req.RemotePath == "" {
+		return fmt.Errorf("local and remote path are required")
+	}
+	switch req.Direction {
+	case models.SyncUpload, models.SyncDownload, models.SyncTwoWay:
+	default:
+		return fmt.Errorf("unknown sync direction: %s", req.Direction)
+	}
+	switch req.Compare {
+	case "", models.SyncCompareSizeTime, models.SyncCompareChecksum:
+	default:
+		return fmt.Errorf("unknown sync comparison: %s", req.Compare)
+	}
+	return nil
+}
+
+func (d *Database) CreateSyncProfile(req models.SyncProfileCreateRequest) (*models.SyncProfile, error) {
+	if req.HostID == "" {
+		return nil, fmt.Errorf("host is required")
+	}
+	if err := validateSyncProfile(req); err != nil {
+		return nil, err
+	}
+
+	profile := &models.SyncProfile{
+		ID:               uuid.New().String(),
+		HostID:           req.HostID,
+		Name:             strings.TrimSpace(req.Name),
+		LocalPath:        req.LocalPath,
+		RemotePath:       req.RemotePath,
+		Direction:        req.Direction,
+		Compare:          req.Compare,
+		Include:          req.Include,
+		Exclude:          req.Exclude,
+		DeleteExtraneous: req.DeleteExtraneous,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+	if profile.Compare == "" {
+		profile.Compare = models.SyncCompareSizeTime
+	}
+
+	includeJSON, _ := json.Marshal(profile.Include)
+	excludeJSON, _ := json.Marshal(profile.Exclude)
+
+	query := `INSERT INTO sync_profiles (id, host_id, name, local_path, remote_path, direction, compare, include, exclude,
+			  delete_extraneous, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := d.db.Exec(query, profile.ID, profile.HostID, profile.Name, profile.LocalPath, profile.RemotePath,
+		profile.Direction, profile.Compare, string(includeJSON), string(excludeJSON), profile.DeleteExtraneous,
+		profile.CreatedAt, profile.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sync profile: %w", err)
+	}
+
+	return profile, nil
+}
+
+const syncProfileColumns = `id, host_id, name, local_path, remote_path, direction, compare, include, exclude,
+	delete_extraneous, last_run_at, created_at, updated_at`
+
+func scanSyncProfile(row interface{ Scan(...any) error }) (*models.SyncProfile, error) {
+	profile := &models.SyncProfile{}
+	var includeJSON, excludeJSON sql.NullString
+	var lastRun sql.NullTime
+
+	err := row.Scan(&profile.ID, &profile.HostID, &profile.Name, &profile.LocalPath, &profile.RemotePath,
+		&profile.Direction, &profile.Compare, &includeJSON, &excludeJSON, &profile.DeleteExtraneous, &lastRun,
+		&profile.CreatedAt, &profile.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if includeJSON.Valid {
+		json.Unmarshal([]byte(includeJSON.String), &profile.Include)
+	}
+	if excludeJSON.Valid {
+		json.Unmarshal([]byte(excludeJSON.String), &profile.Exclude)
+	}
+	if lastRun.Valid {
+		profile.LastRunAt = &lastRun.Time
+	}
+	return profile, nil
+}
+
+func (d *Database) GetSyncProfiles(hostID string) ([]*models.SyncProfile, error) {
+	rows, err := d.db.Query(`SELECT `+syncProfileColumns+` FROM sync_profiles WHERE host_id = ? ORDER BY name`, hostID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sync profiles: %w", err)
+	}
+	defer rows.Close()
+
+	var profiles []*models.SyncProfile
+	for rows.Next() {
+		profile, err := scanSyncProfile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan sync profile: %w", err)
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+func (d *Database) GetSyncProfile(id string) (*models.SyncProfile, error) {
+	profile, err := scanSyncProfile(d.db.QueryRow(`SELECT `+syncProfileColumns+` FROM sync_profiles WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get sync profile: %w", err)
+	}
+	return profile, nil
+}
+
+// UpdateSyncProfile changes a profile, the host stays the same. A profile pointed at other
+// directories starts over without the file state of its last run.
+func (d *Database) UpdateSyncProfile(id string, req models.SyncProfileCreateRequest) (*models.SyncProfile, error) {
+	if err := validateSyncProfile(req); err != nil {
+		return nil, err
+	}
+
+	existing, err := d.GetSyncProfile(id)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, fmt.Errorf("sync profile not found")
+	}
+
+	profile := &models.SyncProfile{
+		ID:               id,
+		HostID:           existing.HostID,
+		Name:             strings.TrimSpace(req.Name),
+		LocalPath:        req.LocalPath,
+		RemotePath:       req.RemotePath,
+		Direction:        req.Direction,
+		Compare:          req.Compare,
+		Include:          req.Include,
+		Exclude:          req.Exclude,
+		DeleteExtraneous: req.DeleteExtraneous,
+		LastRunAt:        existing.LastRunAt,
+		CreatedAt:        existing.CreatedAt,
+		UpdatedAt:        time.Now(),
+	}
+	if profile.Compare == "" {
+		profile.Compare = models.SyncCompareSizeTime
+	}
+
+	includeJSON, _ := json.Marshal(profile.Include)
+	excludeJSON, _ := json.Marshal(profile.Exclude)
+
+	query := `UPDATE sync_profiles SET name = ?, local_path = ?, remote_path = ?, direction = ?, compare = ?, include = ?,
+			  exclude = ?, delete_extraneous = ?, updated_at = ? WHERE id = ?`
+
+	_, err = d.db.Exec(query, profile.Name, profile.LocalPath, profile.RemotePath, profile.Direction, profile.Compare,
+		string(includeJSON), string(excludeJSON), profile.DeleteExtraneous, profile.UpdatedAt, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update sync profile: %w", err)
+	}
+
+	if existing.LocalPath != profile.LocalPath || existing.RemotePath != profile.RemotePath {
+		if err := d.SaveSyncState(id, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return profile, nil
+}
+
+func (d *Database) DeleteSyncProfile(id string) error {
+	if err := d.SaveSyncState(id, nil); err != nil {
+		return err
+	}
+	if _, err := d.db.Exec(`DELETE FROM sync_profiles WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete sync profile: %w", err)
+	}
+	return nil
+}
+
+func (d *Database) UpdateSyncProfileLastRun(id string, lastRun time.Time) error {
+	if _, err := d.db.Exec(`UPDATE sync_profiles SET last_run_at = ? WHERE id = ?`, lastRun, id); err != nil {
+		return fmt.Errorf("failed to update sync profile: %w", err)
+	}
+	return nil
+}
+
+// GetSyncState returns the files that were in sync when the profile last ran
+func (d *Database) GetSyncState(profileID string) ([]models.SyncFileState, error) {
+	rows, err := d.db.Query(`SELECT path, size, local_mod_time, remote_mod_time FROM sync_state WHERE profile_id = ?`, profileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sync state: %w", err)
+	}
+	defer rows.Close()
+
+	var files []models.SyncFileState
+	for rows.Next() {
+		var file models.SyncFileState
+		if err := rows.Scan(&file.Path, &file.Size, &file.LocalModTime, &file.RemoteModTime); err != nil {
+			return nil, fmt.Errorf("failed to scan sync state: %w", err)
+		}
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// SaveSyncState replaces the file state of a profile
+func (d *Database) SaveSyncState(profileID string, files []models.SyncFileState) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM sync_state WHERE profile_id = ?`, profileID); err != nil {
+		return fmt.Errorf("failed to clear sync state: %w", err)
+	}
+	for _, file := range files {
+		_, err := tx.Exec(`INSERT INTO sync_state (profile_id, path, size, local_mod_time, remote_mod_time) VALUES (?, ?, ?, ?, ?)`,
+			profileID, file.Path, file.Size, file.LocalModTime, file.RemoteModTime)
+		if err != nil {
+			return fmt.Errorf("failed to save sync state: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit sync state: %w", err)
+	}
+	return nil
+}
+
+// GetSetting returns a stored setting or fallback if it was never set
+func (d *Database) GetSetting(key, fallback string) string {
+	var value string
+	if err := d.db.QueryRow(`SELECT value FROM settings WHERE key = ?`, key).Scan(&value); err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("DATABASE - Failed to read setting %s: %v", key, err)
+		}
+		return fallback
+	}
+	return value
+}
+
+func (d *Database) SetSetting(key, value string) error {
+	query := `INSERT INTO settings (key, value) VALUES (?, ?)
+			  ON CONFLICT(key) DO UPDATE SET value = excluded.value`
+	if _, err := d.db.Exec(query, key, value); err != nil {
+		return fmt.Errorf("failed to save setting %s: %w", key, err)
+	}
+	return nil
+}
@@ -9,12 +9,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"sync"
 
 	"golang.org/x/crypto/argon2"
 )
 
+// ErrVaultLocked is returned by Encrypt and Decrypt while no key is loaded
+var ErrVaultLocked = errors.New("vault is locked")
+
 type EncryptionService struct {
-	key []byte
+	mutex sync.RWMutex
+	key   []byte
 }
 
 func NewEncryptionService(masterPassword string, salt []byte) *EncryptionService {
@@ -24,11 +29,46 @@ func NewEncryptionService(masterPassword string, salt []byte) *EncryptionService
 		rand.Read(salt)
 	}
 
-	// Derive key using Argon2id
-	key := argon2.IDKey([]byte(masterPassword), salt, 1, 64*1024, 4, 32)
-
 	return &EncryptionService{
-		key: key,
+		key: deriveKey(masterPassword, salt),
+	}
+}
+
+// NewLockedEncryptionService returns a service without a key, it fails until a key is set
+func NewLockedEncryptionService() *EncryptionService {
+	return &EncryptionService{}
+}
+
+// Derive key using Argon2id
+func deriveKey(masterPassword string, salt []byte) []byte {
+	return argon2.IDKey([]byte(masterPassword), salt, 1, 64*1024, 4, 32)
+}
+
+// setKey replaces the key in place so every holder of the service sees the new one
+func (e *EncryptionService) setKey(key []byte) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	wipe(e.key)
+	e.key = append([]byte(nil), key...)
+}
+
+// Lock wipes the key from memory
+func (e *EncryptionService) Lock() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	wipe(e.key)
+	e.key = nil
+}
+
+func (e *EncryptionService) IsLocked() bool {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	return e.key == nil
+}
+
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
 	}
 }
 
@@ -38,6 +78,12 @@ func (e *EncryptionService) Encrypt(plaintext string) (string, error) {
 		return "", nil
 	}
 
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if e.key == nil {
+		return "", ErrVaultLocked
+	}
+
 	block, err := aes.NewCipher(e.key)
 	if err != nil {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
@@ -68,6 +114,12 @@ func (e *EncryptionService) Decrypt(ciphertext string) (string, error) {
 		return "", fmt.Errorf("failed to decode base64: %w", err)
 	}
 
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	if e.key == nil {
+		return "", ErrVaultLocked
+	}
+
 	block, err := aes.NewCipher(e.key)
 	if err != nil {
 		return "", fmt.Errorf("failed to create cipher: %w", err)
@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// encrypted with the derived key to check a master password without touching real secrets
+	vaultVerifierText = "termunator vault"
+	// installations before the vault encrypted everything with this password
+	legacyMasterPassword = "default_key"
+
+	defaultAutoLockMinutes = 15
+	idleCheckInterval      = 30 * time.Second
+)
+
+var (
+	ErrWrongMasterPassword = errors.New("wrong master password")
+	ErrVaultNotInitialized = errors.New("vault has no master password yet")
+)
+
+// VaultStatus is reported to the frontend to decide between setup, unlock and the app
+type VaultStatus struct {
+	Initialized     bool `json:"initialized"`
+	Locked          bool `json:"locked"`
+	AutoLockMinutes int  `json:"autoLockMinutes"`
+}
+
+// vaultMeta is stored with the salt, the JSON tags read the vault.json of older installations
+type vaultMeta struct {
+	Verifier        string `json:"verifier"`
+	AutoLockMinutes int    `json:"auto_lock_minutes"`
+}
+
+// Vault owns the master password: it derives the key shared by the database through
+// EncryptionService, locks it after idle time and re-encrypts secrets when the password changes
+type Vault struct {
+	mutex        sync.Mutex
+	db           *Database
+	salt         []byte // nil on a fresh installation
+	meta         *vaultMeta
+	encryption   *EncryptionService
+	lastActivity time.Time
+}
+
+// NewVault loads the vault stored in db, whose encryption service it unlocks. The salt and
+// verifier live in the database so a password change replaces them in the same transaction
+// as the secrets.
+func NewVault(dataDir string, db *Database) (*Vault, error) {
+	v := &Vault{
+		db:           db,
+		encryption:   db.encryption,
+		lastActivity: time.Now(),
+	}
+
+	salt, meta, found, err := db.getVaultState()
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		if salt, meta, err = importVaultFiles(dataDir, db); err != nil {
+			return nil, err
+		}
+	}
+	v.salt = salt
+	v.meta = meta
+	return v, nil
+}
+
+// importVaultFiles moves the salt and vault.json files of older installations into the database
+func importVaultFiles(dataDir string, db *Database) ([]byte, *vaultMeta, error) {
+	saltPath := filepath.Join(dataDir, "salt")
+	metaPath := filepath.Join(dataDir, "vault.json")
+
+	salt, err := os.ReadFile(saltPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("failed to read salt: %w", err)
+	}
+
+	var meta *vaultMeta
+	data, err := os.ReadFile(metaPath)
+	if err == nil {
+		meta = &vaultMeta{}
+		if err := json.Unmarshal(data, meta); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse vault file: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("failed to read vault file: %w", err)
+	}
+
+	if salt == nil && meta == nil {
+		return nil, nil, nil
+	}
+	if err := saveVaultState(db.db, salt, meta); err != nil {
+		return nil, nil, err
+	}
+
+	// The database is read from now on, the files would only go stale
+	for _, p := range []string{saltPath, metaPath} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			log.Printf("VAULT - Failed to remove %s: %v", p, err)
+		}
+	}
+	log.Printf("VAULT - Moved the salt and vault file into the database")
+	return salt, meta, nil
+}
+
+// Encryption returns the service the database encrypts with, it is locked until Unlock or Setup
+func (v *Vault) Encryption() *EncryptionService {
+	return v.encryption
+}
+
+func (v *Vault) Status() VaultStatus {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	status := VaultStatus{
+		Initialized:     v.meta != nil,
+		Locked:          v.encryption.IsLocked(),
+		AutoLockMinutes: defaultAutoLockMinutes,
+	}
+	if v.meta != nil {
+		status.AutoLockMinutes = v.meta.AutoLockMinutes
+	}
+	return status
+}
+
+// Setup sets the first master password. Secrets of installations that predate the
+// vault are moved from the built-in password to the new one.
+func (v *Vault) Setup(password string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.meta != nil {
+		return errors.New("master password is already set")
+	}
+	if password == "" {
+		return errors.New("master password cannot be empty")
+	}
+
+	var legacy *EncryptionService
+	if v.salt != nil {
+		legacy = NewEncryptionService(legacyMasterPassword, v.salt)
+		defer legacy.Lock()
+	}
+
+	meta := &vaultMeta{AutoLockMinutes: defaultAutoLockMinutes}
+	if err := v.rekey(legacy, password, meta); err != nil {
+		return err
+	}
+
+	log.Printf("VAULT - Master password set")
+	return nil
+}
+
+// Unlock derives the key from password and loads it if the password is right
+func (v *Vault) Unlock(password string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.meta == nil {
+		return ErrVaultNotInitialized
+	}
+
+	candidate := NewEncryptionService(password, v.salt)
+	defer candidate.Lock()
+	if !v.verify(candidate) {
+		return ErrWrongMasterPassword
+	}
+
+	v.encryption.setKey(candidate.key)
+	v.lastActivity = time.Now()
+	log.Printf("VAULT - Unlocked")
+	return nil
+}
+
+// Lock wipes the derived key, secrets cannot be read or written until the next Unlock
+func (v *Vault) Lock() {
+	v.encryption.Lock()
+	log.Printf("VAULT - Locked")
+}
+
+// ChangeMasterPassword re-encrypts every secret under a new password and salt
+func (v *Vault) ChangeMasterPassword(oldPassword, newPassword string) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.meta == nil {
+		return ErrVaultNotInitialized
+	}
+	if newPassword == "" {
+		return errors.New("master password cannot be empty")
+	}
+
+	current := NewEncryptionService(oldPassword, v.salt)
+	defer current.Lock()
+	if !v.verify(current) {
+		return ErrWrongMasterPassword
+	}
+
+	meta := *v.meta
+	if err := v.rekey(current, newPassword, &meta); err != nil {
+		return err
+	}
+
+	log.Printf("VAULT - Master password changed")
+	return nil
+}
+
+// rekey derives a key from password with a fresh salt, moves the secrets from the
+// old service to it and loads it, caller holds the lock. from is nil if there is
+// nothing to re-encrypt.
+func (v *Vault) rekey(from *EncryptionService, password string, meta *vaultMeta) error {
+	salt := GenerateSalt()
+	to := NewEncryptionService(password, salt)
+	defer to.Lock()
+
+	verifier, err := to.Encrypt(vaultVerifierText)
+	if err != nil {
+		return fmt.Errorf("failed to create verifier: %w", err)
+	}
+	updated := *meta
+	updated.Verifier = verifier
+
+	// Secrets, salt and verifier change in one transaction, a failure keeps the old ones
+	if err := v.db.rekeyVault(from, to, salt, &updated); err != nil {
+		return err
+	}
+
+	v.salt = salt
+	v.meta = &updated
+	v.encryption.setKey(to.key)
+	v.lastActivity = time.Now()
+	return nil
+}
+
+// verify checks a candidate key against the stored verifier, caller holds the lock
+func (v *Vault) verify(candidate *EncryptionService) bool {
+	plaintext, err := candidate.Decrypt(v.meta.Verifier)
+	return err == nil && plaintext == vaultVerifierText
+}
+
+// SetAutoLock sets the idle minutes before the vault locks itself, 0 disables auto-lock
+func (v *Vault) SetAutoLock(minutes int) error {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if v.meta == nil {
+		return ErrVaultNotInitialized
+	}
+	if minutes < 0 {
+		return errors.New("auto-lock timeout cannot be negative")
+	}
+
+	if err := v.db.setVaultAutoLock(minutes); err != nil {
+		return err
+	}
+	meta := *v.meta
+	meta.AutoLockMinutes = minutes
+	v.meta = &meta
+	return nil
+}
+
+// Touch records user activity and postpones the auto-lock
+func (v *Vault) Touch() {
+	v.mutex.Lock()
+	v.lastActivity = time.Now()
+	v.mutex.Unlock()
+}
+
+// WatchIdle locks the vault after the configured idle time and calls onLock when it did.
+// It returns when stop is closed.
+func (v *Vault) WatchIdle(stop <-chan struct{}, onLock func()) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		v.mutex.Lock()
+		idle := time.Since(v.lastActivity)
+		expired := v.meta != nil && v.meta.AutoLockMinutes > 0 &&
+			idle >= time.Duration(v.meta.AutoLockMinutes)*time.Minute
+		v.mutex.Unlock()
+
+		if expired && !v.encryption.IsLocked() {
+			log.Printf("VAULT - Idle for %s, locking", idle.Round(time.Second))
+			v.Lock()
+			if onLock != nil {
+				onLock()
+			}
+		}
+	}
+}